@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"microsoft.com/divoc/pkg/dateshift"
+	"microsoft.com/divoc/pkg/fhir"
+	"microsoft.com/divoc/pkg/logger"
+	"path/filepath"
+	"time"
+)
+
+// dateshiftCmd moves every date of a dataset in place by a fixed or computed offset
+func dateshiftCmd(args []string) {
+	////////////////////////////////////////////////////////////////////////////////
+	// Parse command line args
+	////////////////////////////////////////////////////////////////////////////////
+	fs := flag.NewFlagSet("dateshift", flag.ExitOnError)
+	dir := fs.String("dir", "", "Synthea output directory to shift in place (the directory containing fhir/ and csv/)")
+	to := fs.String("to", "", "Date (YYYY-MM-DD) the reference date of the dataset is moved to")
+	from := fs.String("from", "", "Reference date (YYYY-MM-DD) of the dataset (default: the latest date found in the dataset -- usually the generation date)")
+	days := fs.Int("days", 0, "Number of days to shift by -- ignored if -to is provided")
+	fs.Parse(args)
+
+	// Validate flags
+	if *dir == "" {
+		logger.Fatal("-dir required")
+	}
+	if *to != "" {
+		toDate, err := time.Parse("2006-01-02", *to)
+		if err != nil {
+			logger.Fatalf("-to must be a date in the form YYYY-MM-DD: %s", *to)
+		}
+		var fromDate time.Time
+		if *from != "" {
+			if fromDate, err = time.Parse("2006-01-02", *from); err != nil {
+				logger.Fatalf("-from must be a date in the form YYYY-MM-DD: %s", *from)
+			}
+		} else if fromDate, err = dateshift.Latest(filepath.Join(*dir, fhir.Subdir)); err != nil {
+			logger.Fatal(err)
+		}
+		*days = dateshift.Days(fromDate, toDate)
+	}
+	if *days == 0 {
+		logger.Fatal("-to or a non-zero -days required")
+	}
+
+	////////////////////////////////////////////////////////////////////////////////
+	// Shift
+	////////////////////////////////////////////////////////////////////////////////
+	logger.Infof("Shifting dates in %s by %d days", *dir, *days)
+	if err := dateshift.Dataset(*dir, *days); err != nil {
+		logger.Error(err)
+		logger.Fatalf("Failed shifting dates in %s", *dir)
+	}
+	logger.Info("Date shift complete!")
+}
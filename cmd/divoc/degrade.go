@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"microsoft.com/divoc/pkg/degrade"
+	"microsoft.com/divoc/pkg/logger"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// degradeCmd writes a reproducibly degraded copy of a dataset for testing downstream pipelines
+func degradeCmd(args []string) {
+	////////////////////////////////////////////////////////////////////////////////
+	// Parse command line args
+	////////////////////////////////////////////////////////////////////////////////
+	fs := flag.NewFlagSet("degrade", flag.ExitOnError)
+	in := fs.String("in", "", "Synthea output directory to degrade (the directory containing fhir/ and csv/) -- it is not modified")
+	out := fs.String("out", "", "Directory to write the degraded copy and its report to")
+	seed := fs.Int64("seed", time.Now().UnixNano(), "Seed of the random source -- rerun with the same seed to reproduce a dataset (default: current time)")
+	rate := fs.Float64("rate", 0.05, "Probability in (0, 1] that each eligible element is degraded")
+	fs.Parse(args)
+
+	// Validate flags
+	if *in == "" {
+		logger.Fatal("-in required")
+	}
+	if *out == "" {
+		logger.Fatal("-out required")
+	}
+
+	////////////////////////////////////////////////////////////////////////////////
+	// Degrade
+	////////////////////////////////////////////////////////////////////////////////
+	logger.Infof("Degrading %s with seed %d", *in, *seed)
+	report, err := degrade.Run(*in, *out, degrade.Options{Seed: *seed, Rate: *rate})
+	if err != nil {
+		logger.Error(err)
+		logger.Fatalf("Failed writing degraded copy to %s", *out)
+	}
+	var kinds []string
+	for kind := range report.Counts {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		logger.Infof("%s: %d", kind, report.Counts[kind])
+	}
+	logger.Infof("Degraded copy written to %s; report written to %s", *out, filepath.Join(*out, degrade.ReportName))
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"microsoft.com/divoc/pkg/export"
+	"microsoft.com/divoc/pkg/fhir"
+	"microsoft.com/divoc/pkg/logger"
+	"microsoft.com/divoc/pkg/parquet"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// exportCommands are the formats available to `divoc export`, keyed by name
+var exportCommands = map[string]command{
+	"parquet": {"Flatten FHIR output to one Parquet dataset per resource type", exportParquetCmd},
+}
+
+func init() {
+	// the SQLite driver is left out of builds without cgo or with the nosqlite tag
+	if export.SQLiteSupported {
+		exportCommands["sqlite"] = command{"Load FHIR and CSV output into a SQLite database", exportSQLiteCmd}
+	}
+}
+
+// exportCmd converts a dataset to another format
+func exportCmd(args []string) {
+	if len(args) == 0 {
+		exportUsage()
+		os.Exit(2)
+	}
+	cmd, ok := exportCommands[args[0]]
+	if !ok {
+		logger.Errorf("Unknown export format: %s", args[0])
+		exportUsage()
+		os.Exit(2)
+	}
+	cmd.run(args[1:])
+}
+
+// exportUsage prints the available export formats to stderr
+func exportUsage() {
+	var names []string
+	for name := range exportCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(os.Stderr, "Usage: divoc export <format> [flags]\n\nFormats:\n")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, exportCommands[name].description)
+	}
+}
+
+// exportParquetCmd writes the FHIR output of a dataset as Parquet
+func exportParquetCmd(args []string) {
+	////////////////////////////////////////////////////////////////////////////////
+	// Parse command line args
+	////////////////////////////////////////////////////////////////////////////////
+	fs := flag.NewFlagSet("export parquet", flag.ExitOnError)
+	in := fs.String("in", "", "Synthea output directory to export (the directory containing fhir/)")
+	out := fs.String("out", "", "Directory to write the Parquet datasets to (default: <in>/parquet)")
+	codec := fs.String("codec", "gzip", "Compression codec of the Parquet pages: gzip or none")
+	fs.Parse(args)
+
+	// Validate flags
+	if *in == "" {
+		logger.Fatal("-in required")
+	}
+	if *out == "" {
+		*out = filepath.Join(*in, export.ParquetSubdir)
+	}
+	parquetCodec, err := parquetCodec(*codec)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	////////////////////////////////////////////////////////////////////////////////
+	// Export
+	////////////////////////////////////////////////////////////////////////////////
+	counts, err := export.Parquet(filepath.Join(*in, fhir.Subdir), *out, parquetCodec)
+	if err != nil {
+		logger.Error(err)
+		logger.Fatalf("Failed exporting Parquet to %s", *out)
+	}
+	for t, n := range counts {
+		logger.Infof("%s: %d rows", t, n)
+	}
+	logger.Infof("Parquet datasets written to %s", *out)
+}
+
+// exportSQLiteCmd loads the FHIR and CSV output of a dataset into a new SQLite database
+func exportSQLiteCmd(args []string) {
+	////////////////////////////////////////////////////////////////////////////////
+	// Parse command line args
+	////////////////////////////////////////////////////////////////////////////////
+	fs := flag.NewFlagSet("export sqlite", flag.ExitOnError)
+	in := fs.String("in", "", "Synthea output directory to export (the directory containing fhir/ and csv/)")
+	out := fs.String("out", "", "Path of the SQLite database to create -- must not exist")
+	fs.Parse(args)
+
+	// Validate flags
+	if *in == "" {
+		logger.Fatal("-in required")
+	}
+	if *out == "" {
+		logger.Fatal("-out required")
+	}
+
+	////////////////////////////////////////////////////////////////////////////////
+	// Export
+	////////////////////////////////////////////////////////////////////////////////
+	counts, err := export.SQLite(*in, *out)
+	if err != nil {
+		logger.Error(err)
+		logger.Fatalf("Failed exporting SQLite database to %s", *out)
+	}
+	for table, n := range counts {
+		logger.Infof("%s: %d rows", table, n)
+	}
+	logger.Infof("SQLite database written to %s", *out)
+}
+
+// parquetCodec parses the name of a Parquet compression codec
+func parquetCodec(name string) (parquet.Codec, error) {
+	switch name {
+	case "gzip":
+		return parquet.Gzip, nil
+	case "none":
+		return parquet.Uncompressed, nil
+	}
+	return 0, fmt.Errorf("unknown parquet codec: %s", name)
+}
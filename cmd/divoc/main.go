@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"microsoft.com/divoc/pkg/logger"
+	"os"
+	"sort"
+)
+
+// command is a divoc sub-command; run is called with the arguments following the command name
+type command struct {
+	description string
+	run         func(args []string)
+}
+
+// commands available to the divoc cli, keyed by name
+var commands = map[string]command{
+	"subset":    {"Select a cohort of patients from a generated dataset", subsetCmd},
+	"normalize": {"Merge duplicate Organization, Practitioner and Location resources", normalizeCmd},
+	"dateshift": {"Move every date of a dataset to a new reference date", dateshiftCmd},
+	"manifest":  {"Write a FHIR Bulk Data $export manifest for the NDJSON output of a dataset", manifestCmd},
+	"export":    {"Export a dataset to another format (parquet, or sqlite in cgo builds)", exportCmd},
+	"degrade":   {"Write a reproducibly degraded copy of a dataset with a report of every change", degradeCmd},
+	"query":     {"Search a dataset with FHIR search syntax and write the matches as NDJSON", queryCmd},
+	"split":     {"Split large NDJSON files into numbered parts capped by size or resource count", splitCmd},
+	"validate":  {"Check the CSV output of Synthea against the column layout of a Synthea version", validateCmd},
+	"upload":    {"Upload a dataset to Azure Blob Storage with AzCopy, or resume its interrupted upload", uploadCmd},
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		logger.Errorf("Unknown command: %s", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+	cmd.run(flag.Args()[1:])
+}
+
+// usage prints the available commands to stderr
+func usage() {
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: divoc <command> [flags]\n\nCommands:\n")
+	for _, name := range names {
+		fmt.Fprintf(out, "  %-10s %s\n", name, commands[name].description)
+	}
+	fmt.Fprintf(out, "\nRun `divoc <command> -help` to view the flags of a command.\n")
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"microsoft.com/divoc/pkg/bulkdata"
+	"microsoft.com/divoc/pkg/fhir"
+	"microsoft.com/divoc/pkg/logger"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// manifestCmd writes a FHIR Bulk Data $export manifest for the NDJSON output of a dataset
+func manifestCmd(args []string) {
+	////////////////////////////////////////////////////////////////////////////////
+	// Parse command line args
+	////////////////////////////////////////////////////////////////////////////////
+	fs := flag.NewFlagSet("manifest", flag.ExitOnError)
+	dir := fs.String("dir", "", "Synthea output directory containing fhir/ NDJSON files")
+	baseURL := fs.String("base-url", "", "URL the fhir/ directory is (or will be) uploaded to; eg: https://<account>.blob.core.windows.net/<container>/fhir")
+	request := fs.String("request", "", "'request' of the manifest (default: <base-url>/$export)")
+	requiresAccessToken := fs.Bool("requires-access-token", true, "Whether the output URLs require an access token")
+	out := fs.String("out", "", "Path to write the manifest to (default: <dir>/manifest.json)")
+	fs.Parse(args)
+
+	// Validate flags
+	if *dir == "" {
+		logger.Fatal("-dir required")
+	}
+	if *baseURL == "" {
+		logger.Fatal("-base-url required")
+	}
+	if *request == "" {
+		*request = strings.TrimSuffix(*baseURL, "/") + "/$export"
+	}
+	if *out == "" {
+		*out = filepath.Join(*dir, bulkdata.ManifestName)
+	}
+
+	////////////////////////////////////////////////////////////////////////////////
+	// Write manifest
+	////////////////////////////////////////////////////////////////////////////////
+	// use the time the dataset was last written as the transaction time
+	fhirDir := filepath.Join(*dir, fhir.Subdir)
+	info, err := os.Stat(fhirDir)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	manifest, err := bulkdata.NewManifest(fhirDir, *baseURL, *request, info.ModTime())
+	if err != nil {
+		logger.Error(err)
+		logger.Fatalf("Failed listing NDJSON files in %s", fhirDir)
+	}
+	manifest.RequiresAccessToken = *requiresAccessToken
+	if len(manifest.Output) == 0 {
+		logger.Warnf("No NDJSON files found in %s", fhirDir)
+	}
+	if err := manifest.Write(*out); err != nil {
+		logger.Error(err)
+		logger.Fatalf("Failed writing manifest to %s", *out)
+	}
+	logger.Infof("Wrote manifest listing %d files to %s", len(manifest.Output), *out)
+}
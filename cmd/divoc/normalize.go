@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"microsoft.com/divoc/pkg/fhir"
+	"microsoft.com/divoc/pkg/flags"
+	"microsoft.com/divoc/pkg/logger"
+	"microsoft.com/divoc/pkg/providers"
+	"path/filepath"
+)
+
+// normalizeCmd merges the shared provider resources of one or more datasets in place
+func normalizeCmd(args []string) {
+	////////////////////////////////////////////////////////////////////////////////
+	// Parse command line args
+	////////////////////////////////////////////////////////////////////////////////
+	fs := flag.NewFlagSet("normalize", flag.ExitOnError)
+	var dirs flags.StringList
+	fs.Var(&dirs, "dir", "Synthea output directory to normalize in place -- repeat to merge the providers of several sharded or appended datasets")
+	out := fs.String("providers-out", "", "Directory to write the merged provider resources to (default: the fhir/ directory of the first -dir)")
+	fs.Parse(args)
+
+	// Validate flags
+	if len(dirs) == 0 {
+		logger.Fatal("-dir required")
+	}
+	var fhirDirs []string
+	for _, dir := range dirs {
+		fhirDirs = append(fhirDirs, filepath.Join(dir, fhir.Subdir))
+	}
+	if *out == "" {
+		*out = fhirDirs[0]
+	}
+
+	////////////////////////////////////////////////////////////////////////////////
+	// Normalize
+	////////////////////////////////////////////////////////////////////////////////
+	summary, err := providers.Normalize(*out, fhirDirs...)
+	if err != nil {
+		logger.Error(err)
+		logger.Fatal("Failed normalizing shared provider resources")
+	}
+	for t, n := range summary.Resources {
+		logger.Infof("%s: %d canonical, %d duplicates merged", t, n, summary.Duplicates[t])
+	}
+	logger.Infof("Rewrote %d references; providers written to %s", summary.References, *out)
+}
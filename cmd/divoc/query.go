@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"microsoft.com/divoc/pkg/fhir"
+	"microsoft.com/divoc/pkg/logger"
+	"microsoft.com/divoc/pkg/search"
+	"os"
+	"path/filepath"
+)
+
+// queryCmd evaluates a FHIR search over a local dataset and writes the matches as NDJSON
+func queryCmd(args []string) {
+	////////////////////////////////////////////////////////////////////////////////
+	// Parse command line args
+	////////////////////////////////////////////////////////////////////////////////
+	fs := flag.NewFlagSet("query", flag.ExitOnError)
+	dir := fs.String("dir", "", "Synthea output directory to search (the directory containing fhir/), or the fhir directory itself")
+	out := fs.String("out", "", "File to write matching resources to as NDJSON (default: stdout)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: divoc query [flags] '<Type>?<param>=<value>&...'\n\n"+
+			"Example: divoc query -dir ./output 'Observation?code=http://loinc.org|8867-4&subject=Patient/123&date=ge2020-01'\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	// Validate flags
+	if *dir == "" {
+		logger.Fatal("-dir required")
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	query, err := search.Parse(fs.Arg(0))
+	if err != nil {
+		logger.Fatal(err)
+	}
+	fhirDir := *dir
+	if info, err := os.Stat(filepath.Join(*dir, fhir.Subdir)); err == nil && info.IsDir() {
+		fhirDir = filepath.Join(*dir, fhir.Subdir)
+	}
+
+	////////////////////////////////////////////////////////////////////////////////
+	// Query
+	////////////////////////////////////////////////////////////////////////////////
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		defer f.Close()
+		w = f
+	}
+	// matches are streamed -- nothing else may be logged to stdout unless writing to a file
+	writer := fhir.NewNDJSONWriter(w)
+	matches, err := search.Dir(fhirDir, query, writer.Write)
+	if flushErr := writer.Flush(); err == nil {
+		err = flushErr
+	}
+	if err != nil {
+		logger.Error(err)
+		logger.Fatalf("Failed searching %s", fhirDir)
+	}
+	if *out != "" {
+		logger.Infof("Wrote %d matches to %s", matches, *out)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"microsoft.com/divoc/pkg/bulkdata"
+	"microsoft.com/divoc/pkg/logger"
+	"sort"
+)
+
+// splitCmd splits large NDJSON files of a dataset into numbered parts
+func splitCmd(args []string) {
+	////////////////////////////////////////////////////////////////////////////////
+	// Parse command line args
+	////////////////////////////////////////////////////////////////////////////////
+	fs := flag.NewFlagSet("split", flag.ExitOnError)
+	dir := fs.String("dir", "", "Directory of NDJSON files to split in place, eg: the fhir directory of a dataset (not searched recursively)")
+	maxBytes := fs.Int64("max-bytes", 0, "Maximum bytes per NDJSON file")
+	maxResources := fs.Int("max-resources", 0, "Maximum resources per NDJSON file")
+	fs.Parse(args)
+
+	// Validate flags
+	if *dir == "" {
+		logger.Fatal("-dir required")
+	}
+	if *maxBytes <= 0 && *maxResources <= 0 {
+		logger.Fatal("-max-bytes or -max-resources required")
+	}
+
+	////////////////////////////////////////////////////////////////////////////////
+	// Split
+	////////////////////////////////////////////////////////////////////////////////
+	parts, err := bulkdata.Split(*dir, bulkdata.Limits{MaxBytes: *maxBytes, MaxResources: *maxResources})
+	if err != nil {
+		logger.Error(err)
+		logger.Fatalf("Failed splitting NDJSON files in %s", *dir)
+	}
+	var paths []string
+	for path := range parts {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		logger.Infof("%s: %d parts", path, parts[path])
+	}
+	logger.Infof("Split %d files", len(parts))
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"microsoft.com/divoc/pkg/fhir"
+	"microsoft.com/divoc/pkg/flags"
+	"microsoft.com/divoc/pkg/logger"
+	"microsoft.com/divoc/pkg/subset"
+	"sort"
+)
+
+// subsetCmd selects a cohort of patients from a Synthea output directory and writes it as a new dataset
+func subsetCmd(args []string) {
+	////////////////////////////////////////////////////////////////////////////////
+	// Parse command line args
+	////////////////////////////////////////////////////////////////////////////////
+	fs := flag.NewFlagSet("subset", flag.ExitOnError)
+	in := fs.String("in", "", "Synthea output directory to select patients from (the directory containing fhir/ and csv/)")
+	out := fs.String("out", "", "Directory to write the subset dataset to")
+	var conditions, observations, medications, procedures, genders flags.StringList
+	var minAge, maxAge flags.OptionalInt
+	fs.Var(&conditions, "condition", "Condition code in FHIR token form '[system|]code' -- repeat to match any of several codes")
+	fs.Var(&observations, "observation", "Observation code in FHIR token form '[system|]code' -- repeat to match any of several codes")
+	fs.Var(&medications, "medication", "Medication code in FHIR token form '[system|]code' -- repeat to match any of several codes")
+	fs.Var(&procedures, "procedure", "Procedure code in FHIR token form '[system|]code' -- repeat to match any of several codes")
+	fs.Var(&genders, "gender", "Patient gender (male, female, other, unknown) -- repeat to match any of several genders")
+	fs.Var(&minAge, "min-age", "Minimum patient age in years")
+	fs.Var(&maxAge, "max-age", "Maximum patient age in years")
+	fs.Parse(args)
+
+	// Validate flags
+	if *in == "" {
+		logger.Fatal("-in required")
+	}
+	if *out == "" {
+		logger.Fatal("-out required")
+	}
+
+	////////////////////////////////////////////////////////////////////////////////
+	// Subset
+	////////////////////////////////////////////////////////////////////////////////
+	criteria := subset.Criteria{
+		Conditions:   tokens(conditions),
+		Observations: tokens(observations),
+		Medications:  tokens(medications),
+		Procedures:   tokens(procedures),
+		Genders:      genders,
+		MinAge:       minAge.Value,
+		MaxAge:       maxAge.Value,
+	}
+	logger.Infof("Selecting patients from %s", *in)
+	summary, err := subset.Run(*in, *out, criteria)
+	if err != nil {
+		logger.Error(err)
+		logger.Fatalf("Failed writing subset to %s", *out)
+	}
+
+	var types []string
+	for t := range summary.Resources {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	for _, t := range types {
+		logger.Infof("%s: %d", t, summary.Resources[t])
+	}
+	logger.Infof("Wrote %d patients to %s", summary.Patients, *out)
+}
+
+// tokens parses each value as a FHIR search token
+func tokens(values []string) (parsed []fhir.Token) {
+	for _, v := range values {
+		parsed = append(parsed, fhir.ParseToken(v))
+	}
+	return parsed
+}
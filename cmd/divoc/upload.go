@@ -0,0 +1,194 @@
+package main
+
+import (
+	"flag"
+	"microsoft.com/divoc/pkg/azure/auth"
+	"microsoft.com/divoc/pkg/azure/azcopy"
+	"microsoft.com/divoc/pkg/destination"
+	"microsoft.com/divoc/pkg/logger"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// uploadCmd uploads an existing dataset to Azure Blob Storage with AzCopy, or resumes its interrupted upload
+func uploadCmd(args []string) {
+	////////////////////////////////////////////////////////////////////////////////
+	// Parse command line args
+	////////////////////////////////////////////////////////////////////////////////
+	fs := flag.NewFlagSet("upload", flag.ExitOnError)
+	dir := fs.String("dir", "", "Synthea output directory to upload (the directory containing fhir/ and csv/); eg: the output kept by a failed generate-fhir run")
+	resume := fs.Bool("resume", false, "Resume the unfinished AzCopy jobs uploading -dir to the container instead of uploading every file again -- -dir is uploaded if there are none")
+	jobID := fs.String("job-id", "", "With -resume, ID of the AzCopy job to resume (default: every unfinished job uploading -dir to the container)")
+	maxResumes := fs.Int("max-resumes", azcopy.DefaultMaxResumes, "Times a failed AzCopy job is resumed with 'azcopy jobs resume' before the upload fails")
+	resumeDelay := fs.Duration("resume-delay", azcopy.DefaultResumeDelay, "Delay before resuming a failed AzCopy job, doubled before each next resume")
+	verifyUpload := fs.Bool("verify-upload", false, "After uploading, verify every file exists in the container with the same size -- always done with -resume")
+	spClientId := fs.String("sp-client-id", "", "Service principal client ID to authenticate with AzCopy -- The principal must have 'Storage Blob Data Contributor' role on the target storage account")
+	spClientSecret := fs.String("sp-client-secret", "", "Service principal client secret to authenticate with AzCopy")
+	spCertificatePath := fs.String("sp-certificate-path", "", "PEM or PFX file of a service principal certificate and its private key to authenticate with instead of -sp-client-secret")
+	spCertificatePassword := fs.String("sp-certificate-password", "", "Password of an encrypted -sp-certificate-path (default: $AZCOPY_SPA_CERT_PASSWORD)")
+	spTenantId := fs.String("sp-tenant-id", "", "Service principal tenant ID to authenticate with AzCopy -- also selects the tenant of -auth-mode azure-cli")
+	authMode := fs.String("auth-mode", azcopy.LoginServicePrincipal, "How AzCopy logs in: 'service-principal' with the -sp-* flags, 'managed-identity' of the Azure VM or build agent it runs on, or 'azure-cli' to reuse an 'az login' session")
+	identityClientID := fs.String("identity-client-id", "", "With -auth-mode managed-identity, client ID of the user-assigned identity to log in with (default: the system-assigned identity)")
+	identityObjectID := fs.String("identity-object-id", "", "With -auth-mode managed-identity, object ID of the user-assigned identity to log in with")
+	identityResourceID := fs.String("identity-resource-id", "", "With -auth-mode managed-identity, resource ID of the user-assigned identity to log in with")
+	storageAccount := fs.String("storage-account", "", "Azure storage account name to upload to")
+	storageContainer := fs.String("storage-container", "", "Azure storage blob container name to upload to")
+	storageSASURL := fs.String("storage-sas-url", "", "Container SAS URL to upload to instead of -storage-account, -storage-container and a service principal (default: $AZURE_STORAGE_SAS_URL)")
+	storageSASToken := fs.String("storage-sas-token", "", "SAS token of -storage-container to authenticate with instead of a service principal (default: $AZURE_STORAGE_SAS_TOKEN)")
+	storageSASTokenFile := fs.String("storage-sas-token-file", "", "File holding the -storage-sas-token")
+	fs.Parse(args)
+
+	// SAS flags default to the environment after parsing, so -help never prints a token
+	if *storageSASURL == "" {
+		*storageSASURL = os.Getenv("AZURE_STORAGE_SAS_URL")
+	}
+	if *storageSASToken == "" {
+		*storageSASToken = os.Getenv("AZURE_STORAGE_SAS_TOKEN")
+	}
+	if *spCertificatePassword == "" {
+		*spCertificatePassword = os.Getenv("AZCOPY_SPA_CERT_PASSWORD")
+	}
+	sp := auth.ServicePrincipal{
+		ApplicationId:       *spClientId,
+		Password:            *spClientSecret,
+		CertificatePath:     *spCertificatePath,
+		CertificatePassword: *spCertificatePassword,
+		Tenant:              *spTenantId,
+	}
+
+	// Validate flags
+	if *dir == "" {
+		logger.Fatal("-dir required")
+	}
+	sasContainerURL, sas, err := auth.LoadSAS(*storageSASURL, *storageSASTokenFile, *storageSASToken)
+	if err != nil {
+		logger.Error(err)
+		logger.Fatal("Invalid -storage-sas-url, -storage-sas-token-file or -storage-sas-token")
+	}
+	if sas == "" && *authMode == azcopy.LoginServicePrincipal && !sp.Complete() {
+		logger.Fatal("-sp-client-id, -sp-client-secret (or -sp-certificate-path) and -sp-tenant-id, or a -storage-sas-* flag required")
+	}
+	if *spClientSecret != "" && *spCertificatePath != "" {
+		logger.Fatal("Only one of -sp-client-secret and -sp-certificate-path can be set")
+	}
+	if sasContainerURL == "" {
+		if *storageAccount == "" {
+			logger.Fatal("-storage-account required")
+		}
+		if *storageContainer == "" {
+			logger.Fatal("-storage-container required")
+		}
+	}
+	validMode := false
+	for _, mode := range azcopy.LoginModes {
+		validMode = validMode || mode == *authMode
+	}
+	if !validMode {
+		logger.Fatalf("-auth-mode must be one of %s: %s", strings.Join(azcopy.LoginModes, ", "), *authMode)
+	}
+	if *jobID != "" && !*resume {
+		logger.Fatal("-job-id requires -resume")
+	}
+	absDir, err := filepath.Abs(*dir)
+	if err != nil {
+		logger.Error(err)
+		logger.Fatalf("Failed to calculate absolute path for -dir: %s", *dir)
+	}
+
+	////////////////////////////////////////////////////////////////////////////////
+	// Login
+	////////////////////////////////////////////////////////////////////////////////
+	azc, err := azcopy.InstallIfNotPresentAndGetCtx()
+	if err != nil {
+		logger.Error(err)
+		logger.Fatal("Failed to install AzCopy")
+	}
+	defer azc.Cleanup()
+	azc.Retry = azcopy.RetryPolicy{MaxResumes: *maxResumes, Delay: *resumeDelay}
+
+	dest := destination.NewAzCopy(azc, *storageAccount, *storageContainer)
+	if sas != "" {
+		// the SAS authorizes every azcopy command, so no login is needed
+		if sasContainerURL != "" {
+			dest.ContainerURL = sasContainerURL
+		}
+		dest.SAS = sas
+	} else {
+		logger.Infof("Logging into AzCopy with %s credentials...", *authMode)
+		login := azcopy.LoginOptions{
+			Mode:               *authMode,
+			ServicePrincipal:   sp,
+			IdentityClientID:   *identityClientID,
+			IdentityObjectID:   *identityObjectID,
+			IdentityResourceID: *identityResourceID,
+			Tenant:             *spTenantId,
+		}
+		if err := azc.LoginWithOptions(login); err != nil {
+			logger.Error(err)
+			logger.Fatalf("Failed to authenticate AzCopy with %s credentials", *authMode)
+		}
+	}
+
+	////////////////////////////////////////////////////////////////////////////////
+	// Upload, or resume the interrupted jobs
+	////////////////////////////////////////////////////////////////////////////////
+	var jobIDs []string
+	if *jobID != "" {
+		jobIDs = []string{*jobID}
+	} else if *resume {
+		jobs, err := azc.Jobs()
+		if err != nil {
+			logger.Error(err)
+			logger.Fatal("Failed listing AzCopy jobs")
+		}
+		for _, job := range jobs {
+			if !azcopy.Finished(job.Status) && uploads(job.Command, absDir, dest.ContainerURL) {
+				logger.Infof("Found %s AzCopy job %s: %s", job.Status, job.JobID, job.Command)
+				jobIDs = append(jobIDs, job.JobID)
+			}
+		}
+		if len(jobIDs) == 0 {
+			logger.Warnf("No unfinished AzCopy job uploading %s to %s: uploading every file", absDir, dest.ContainerURL)
+		}
+	}
+
+	if len(jobIDs) == 0 {
+		logger.Infof("Beginning data upload from %s to %s", absDir, dest.ContainerURL)
+		err := dest.Upload(absDir)
+		logger.Info(dest.Report())
+		if err != nil {
+			logger.Error(err)
+			logger.Fatalf("Failed uploading files from %s to %s", absDir, dest.ContainerURL)
+		}
+	}
+	for _, id := range jobIDs {
+		logger.Infof("Resuming AzCopy job %s...", id)
+		summary, err := azc.Resume(id, dest.SAS)
+		if summary != nil {
+			logger.Info(summary.String())
+		}
+		if err != nil {
+			logger.Error(err)
+			logger.Fatalf("Failed resuming AzCopy job %s", id)
+		}
+	}
+
+	// resumed jobs only cover the files they were started with
+	if *verifyUpload || len(jobIDs) > 0 {
+		logger.Info("Verifying upload...")
+		if err := dest.Verify(absDir); err != nil {
+			logger.Error(err)
+			logger.Fatalf("Failed verifying upload to %s -- rerun without -resume to upload every file", dest.ContainerURL)
+		}
+	}
+	logger.Infof("Upload of %s to %s complete!", absDir, dest.ContainerURL)
+}
+
+// uploads reports whether the command line of an azcopy job uploads dir to a container URL; eg: `copy <dir>/* <url>`
+// or `sync <dir> <url>`
+func uploads(command string, dir string, url string) bool {
+	command += " "
+	fromDir := strings.Contains(command, " "+dir+" ") || strings.Contains(command, " "+filepath.Join(dir, "*")+" ")
+	return fromDir && strings.Contains(command, " "+url)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"microsoft.com/divoc/pkg/logger"
+	syntheacsv "microsoft.com/divoc/pkg/synthea/csv"
+	"os"
+	"path/filepath"
+)
+
+// validateCmd checks the CSV output of Synthea against the column layout of a known Synthea version
+func validateCmd(args []string) {
+	////////////////////////////////////////////////////////////////////////////////
+	// Parse command line args
+	////////////////////////////////////////////////////////////////////////////////
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	dir := fs.String("dir", "", "Synthea output directory to validate (the directory containing csv/), or the csv directory itself")
+	version := fs.String("synthea-version", syntheacsv.DefaultVersion, "Synthea version whose CSV column layout to validate against")
+	fs.Parse(args)
+
+	// Validate flags
+	if *dir == "" {
+		logger.Fatal("-dir required")
+	}
+	csvDir := *dir
+	if info, err := os.Stat(filepath.Join(*dir, syntheacsv.Subdir)); err == nil && info.IsDir() {
+		csvDir = filepath.Join(*dir, syntheacsv.Subdir)
+	}
+
+	////////////////////////////////////////////////////////////////////////////////
+	// Validate
+	////////////////////////////////////////////////////////////////////////////////
+	issues, err := syntheacsv.Validate(csvDir, *version)
+	if err != nil {
+		logger.Error(err)
+		logger.Fatalf("Failed validating %s", csvDir)
+	}
+	for _, issue := range issues {
+		logger.Warn(issue)
+	}
+	if len(issues) > 0 {
+		logger.Fatalf("%s does not match the Synthea %s CSV layout: %d issues", csvDir, *version, len(issues))
+	}
+	logger.Infof("%s matches the Synthea %s CSV layout", csvDir, *version)
+}
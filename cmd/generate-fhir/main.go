@@ -1,16 +1,39 @@
 package main
 
 import (
+	"crypto/tls"
 	"flag"
 	"fmt"
+	"microsoft.com/divoc/pkg/aws/s3"
 	"microsoft.com/divoc/pkg/azure/auth"
 	"microsoft.com/divoc/pkg/azure/azcopy"
+	"microsoft.com/divoc/pkg/azure/blob"
+	"microsoft.com/divoc/pkg/bulkdata"
+	"microsoft.com/divoc/pkg/compress"
+	"microsoft.com/divoc/pkg/dateshift"
+	"microsoft.com/divoc/pkg/degrade"
+	"microsoft.com/divoc/pkg/destination"
+	"microsoft.com/divoc/pkg/export"
+	"microsoft.com/divoc/pkg/fhir"
+	"microsoft.com/divoc/pkg/flags"
+	"microsoft.com/divoc/pkg/kafka"
 	"microsoft.com/divoc/pkg/logger"
+	"microsoft.com/divoc/pkg/parquet"
+	"microsoft.com/divoc/pkg/providers"
 	"microsoft.com/divoc/pkg/synthea"
+	syntheacsv "microsoft.com/divoc/pkg/synthea/csv"
+	"microsoft.com/divoc/pkg/transform"
+	"os"
 	"path"
 	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 )
 
+// kafkaAcksValues are the values of -kafka-acks
+var kafkaAcksValues = map[string]int{"all": kafka.AcksAll, "leader": kafka.AcksLeader, "none": kafka.AcksNone}
+
 func main() {
 	////////////////////////////////////////////////////////////////////////////////
 	// Parse command line args
@@ -20,34 +43,326 @@ func main() {
 	state := flag.String("synthea-state", "California", "State which the sample size will be generated in")
 	city := flag.String("synthea-city", "San Francisco", "City which the sample size will be generated in")
 	csv := flag.Bool("synthea-csv", false, "Generate CSV output in addition to FHIR")
+	csvVersion := flag.String("synthea-csv-version", syntheacsv.DefaultVersion, "Synthea version whose column layout the -synthea-csv output is validated against")
+	csvStrict := flag.Bool("synthea-csv-strict", false, "Fail instead of warning when the -synthea-csv output does not match the layout of -synthea-csv-version")
 	ndjson := flag.Bool("synthea-ndjson", false, "Generate bulk FHIR dumps in NDJSON format (standard JSON will not be generated)")
 	noClean := flag.Bool("synthea-no-clean", false, "Do not cleanup temporary directories after running -- useful if you want to generated output locally")
 	syntheaPath := flag.String("synthea-path", "", "Path to local Synthea repository -- if provided, will skip cloning the repo locally and force -synthea-no-clean, if not, will clone the repository to a temporary directory")
 
+	// Post-processing flags
+	normalizeProviders := flag.Bool("normalize-providers", false, "Merge duplicate Organization, Practitioner and Location resources by identifier into a single provider Bundle (or NDJSON set) before upload")
+	dateShiftTo := flag.String("date-shift-to", "", "Shift every date in the dataset (FHIR and CSV) so the generation date lands on this date (YYYY-MM-DD) -- intervals and patient ages are kept")
+	dateShiftDays := flag.Int("date-shift-days", 0, "Shift every date in the dataset (FHIR and CSV) by this many days -- ignored if -date-shift-to is provided")
+	parquetOut := flag.Bool("parquet", false, "Also flatten the FHIR output to one Parquet dataset per resource type in the 'parquet' output directory, uploaded next to the raw data")
+	degradeRate := flag.Float64("degrade-rate", 0, "If > 0, also write a degraded copy of the dataset to -degrade-out, or upload it with -upload-degraded, degrading each eligible element with this probability")
+	degradeOut := flag.String("degrade-out", "", "Directory to write the -degrade-rate copy to -- required with -degrade-rate unless -upload-degraded is set")
+	uploadDegraded := flag.Bool("upload-degraded", false, "Upload the -degrade-rate copy to the 'degraded' directory of the destination, next to the clean dataset -- azcopy, blob, s3 and local destinations only")
+	degradeSeed := flag.Int64("degrade-seed", 1, "Seed of the random source used by -degrade-rate -- the same seed always produces the same degraded copy")
+	gzipOut := flag.Bool("gzip", false, "Gzip the FHIR output (.ndjson.gz / .json.gz) before upload -- blobs are uploaded with 'Content-Encoding: gzip' and their FHIR Content-Type")
+	gzipCSV := flag.Bool("gzip-csv", false, "With -gzip, also gzip the CSV output (.csv.gz)")
+	ndjsonMaxBytes := flag.Int64("ndjson-max-bytes", 0, "If > 0, split NDJSON files larger than this many bytes into numbered parts; eg: Observation.000.ndjson, Observation.001.ndjson")
+	ndjsonMaxResources := flag.Int("ndjson-max-resources", 0, "If > 0, split NDJSON files with more than this many resources into numbered parts")
+	bulkManifest := flag.Bool("bulk-manifest", false, "With -synthea-ndjson, write a FHIR Bulk Data $export manifest listing every uploaded NDJSON file to 'manifest.json' at the root of the container")
+	bulkManifestRequest := flag.String("bulk-manifest-request", "", "'request' of the -bulk-manifest (default: <container URL>/fhir/$export)")
+	var transforms flags.StringList
+	flag.Var(&transforms, "transform", fmt.Sprintf("Transformer to apply to every resource before upload in the form 'name[=arg]' -- repeat to chain several, applied in order (available: %s)", strings.Join(transform.Names(), ", ")))
+	transformConfig := flag.String("transform-config", "", "Path to a JSON file listing transformers to apply before any -transform flags; eg: {\"transformers\": [{\"name\": \"meta-source\", \"arg\": \"https://example.com\"}]}")
+
+	// Destination flags
+	destinationType := flag.String("destination", "azcopy", "Where to upload the generated data: 'azcopy' (Azure Blob Storage via AzCopy), 'blob' (Azure Blob Storage via the REST API, without AzCopy), 's3' (Amazon S3 or S3-compatible storage), 'fhir' (the REST API of a FHIR server), 'kafka' (a topic per resource type) or 'local' (a directory, eg: a mounted share)")
+	destinationPath := flag.String("destination-path", "", "Directory to copy the generated data to with -destination local")
+	storageEndpoint := flag.String("storage-endpoint", "", "Blob service endpoint with -destination blob (default: https://<storage-account>.blob.core.windows.net) -- eg: http://127.0.0.1:10000/devstoreaccount1 for the Azurite emulator")
+	storageKey := flag.String("storage-key", os.Getenv("AZURE_STORAGE_KEY"), "Storage account key to authenticate with -destination blob instead of a service principal (default: $AZURE_STORAGE_KEY)")
+	uploadConcurrency := flag.Int("upload-concurrency", blob.DefaultConcurrency, "Maximum number of requests in flight with -destination blob, s3 or fhir")
+	verifyUpload := flag.Bool("verify-upload", false, "After uploading, verify every file exists at the destination with the same size")
+	uploadMode := flag.String("upload-mode", "copy", "How -destination azcopy uploads: 'copy' every file, or 'sync' only new and modified files, so the container mirrors the dataset (see -sync-delete-destination)")
+	syncDeleteDestination := flag.String("sync-delete-destination", azcopy.DeleteNever, "With -upload-mode sync, whether blobs of the container not in the dataset are deleted: 'false', 'prompt' (azcopy asks before deleting) or 'true' (requires -sync-delete-confirm)")
+	syncDeleteConfirm := flag.String("sync-delete-confirm", "", "Name of the -storage-container, confirming -sync-delete-destination true may delete its blobs")
+	azcopyMaxResumes := flag.Int("azcopy-max-resumes", azcopy.DefaultMaxResumes, "Times a failed AzCopy job is resumed with 'azcopy jobs resume' before the upload fails")
+	azcopyResumeDelay := flag.Duration("azcopy-resume-delay", azcopy.DefaultResumeDelay, "Delay before resuming a failed AzCopy job, doubled before each next resume")
+
+	// S3 flags
+	s3Bucket := flag.String("s3-bucket", "", "S3 bucket to push FHIR data to with -destination s3")
+	s3Prefix := flag.String("s3-prefix", "", "Key prefix to upload under within -s3-bucket; eg: datasets/divoc")
+	s3Region := flag.String("s3-region", s3.DefaultRegion, "S3 region to sign requests for")
+	s3Endpoint := flag.String("s3-endpoint", "", "S3 endpoint (default: https://s3.<s3-region>.amazonaws.com) -- eg: http://127.0.0.1:9000 for MinIO")
+	s3PathStyle := flag.Bool("s3-path-style", false, "Address the bucket as a path of -s3-endpoint instead of a subdomain, as MinIO and most S3-compatible storage require")
+	s3AccessKeyID := flag.String("s3-access-key-id", os.Getenv("AWS_ACCESS_KEY_ID"), "S3 access key ID (default: $AWS_ACCESS_KEY_ID)")
+	s3SecretAccessKey := flag.String("s3-secret-access-key", os.Getenv("AWS_SECRET_ACCESS_KEY"), "S3 secret access key (default: $AWS_SECRET_ACCESS_KEY) -- temporary credentials also use $AWS_SESSION_TOKEN")
+
+	// FHIR server flags
+	fhirServerURL := flag.String("fhir-url", "", "Base URL of the FHIR server to load the generated data into with -destination fhir or -fhir-import; eg: http://localhost:8080/fhir")
+	fhirToken := flag.String("fhir-bearer-token", os.Getenv("FHIR_BEARER_TOKEN"), "Bearer token to authenticate with the FHIR server (default: $FHIR_BEARER_TOKEN) -- if empty, an Azure AD token of the -sp-* service principal is used when provided")
+	fhirImport := flag.Bool("fhir-import", false, "With -synthea-ndjson, $import the uploaded NDJSON files into the FHIR server at -fhir-url once uploaded, and wait for the import to complete")
+	fhirImportMode := flag.String("fhir-import-mode", bulkdata.IncrementalLoad, "Mode of -fhir-import: IncrementalLoad, or InitialLoad for an empty server")
+	fhirImportInterval := flag.Duration("fhir-import-poll-interval", 10*time.Second, "How often to check the status of -fhir-import")
+	fhirImportTimeout := flag.Duration("fhir-import-timeout", 0, "Fail if -fhir-import has not completed after this long -- 0 waits forever")
+
+	// Kafka flags
+	kafkaBrokers := flag.String("kafka-brokers", "", "Comma separated bootstrap brokers to publish resources to with -destination kafka; eg: localhost:9092")
+	kafkaTopic := flag.String("kafka-topic", "", "Publish every resource to this topic instead of a topic per resource type")
+	kafkaTopicPrefix := flag.String("kafka-topic-prefix", "fhir.", "Prefix of the topic of each resource type; eg: fhir.Patient")
+	kafkaAcks := flag.String("kafka-acks", "all", "Acknowledgements required for a batch to be published: 'all' in-sync replicas, the partition 'leader', or 'none'")
+	kafkaCompression := flag.String("kafka-compression", kafka.CompressionNone, "Compression of published batches: none or gzip")
+	kafkaBatchBytes := flag.Int("kafka-batch-bytes", kafka.DefaultBatchBytes, "Bytes of messages buffered before they are published -- keep below the max message size of the brokers")
+	kafkaMaxRetries := flag.Int("kafka-max-retries", kafka.DefaultMaxRetries, "Retries of batches failing with a retriable error -- retried batches may be published twice; 0 never publishes a message twice")
+	kafkaTLS := flag.Bool("kafka-tls", false, "Connect to the Kafka brokers over TLS")
+
 	// azcopy flags
 	spClientId := flag.String("sp-client-id", "", "Service principal client ID to authenticate with AzCopy -- The principal must have 'Storage Blob Data Contributor' role on the target storage account")
 	spClientSecret := flag.String("sp-client-secret", "", "Service principal client secret to authenticate with AzCopy")
-	spTenantId := flag.String("sp-tenant-id", "", "Service principal tenant ID to authenticate with AzCopy")
+	spCertificatePath := flag.String("sp-certificate-path", "", "PEM or PFX file of a service principal certificate and its private key to authenticate with instead of -sp-client-secret -- -destination blob and fhir, and -fhir-import, only read unencrypted PEM files")
+	spCertificatePassword := flag.String("sp-certificate-password", "", "Password of an encrypted -sp-certificate-path, read by AzCopy (default: $AZCOPY_SPA_CERT_PASSWORD)")
+	spTenantId := flag.String("sp-tenant-id", "", "Service principal tenant ID to authenticate with AzCopy -- also selects the tenant of -azcopy-auth-mode azure-cli")
+	azcopyAuthMode := flag.String("azcopy-auth-mode", azcopy.LoginServicePrincipal, "How AzCopy logs in: 'service-principal' with the -sp-* flags, 'managed-identity' of the Azure VM or build agent it runs on, or 'azure-cli' to reuse an 'az login' session")
+	identityClientID := flag.String("identity-client-id", "", "With -azcopy-auth-mode managed-identity, client ID of the user-assigned identity to log in with (default: the system-assigned identity)")
+	identityObjectID := flag.String("identity-object-id", "", "With -azcopy-auth-mode managed-identity, object ID of the user-assigned identity to log in with")
+	identityResourceID := flag.String("identity-resource-id", "", "With -azcopy-auth-mode managed-identity, resource ID of the user-assigned identity to log in with")
 	storageAccount := flag.String("storage-account", "", "Azure storage account name to push FHIR data to")
 	storageContainer := flag.String("storage-container", "", "Azure storage blob container name to push FHIR data to")
+	storageSASURL := flag.String("storage-sas-url", "", "Container SAS URL to upload to with -destination azcopy or blob, instead of -storage-account, -storage-container and a service principal (default: $AZURE_STORAGE_SAS_URL)")
+	storageSASToken := flag.String("storage-sas-token", "", "SAS token of -storage-container to authenticate with -destination azcopy or blob instead of a service principal (default: $AZURE_STORAGE_SAS_TOKEN)")
+	storageSASTokenFile := flag.String("storage-sas-token-file", "", "File holding the -storage-sas-token")
 
 	flag.Parse()
 
+	// SAS flags default to the environment after parsing, so -help never prints a token
+	if *storageSASURL == "" {
+		*storageSASURL = os.Getenv("AZURE_STORAGE_SAS_URL")
+	}
+	if *storageSASToken == "" {
+		*storageSASToken = os.Getenv("AZURE_STORAGE_SAS_TOKEN")
+	}
+	storageSAS := *storageSASURL != "" || *storageSASTokenFile != "" || *storageSASToken != ""
+	if *spCertificatePassword == "" {
+		*spCertificatePassword = os.Getenv("AZCOPY_SPA_CERT_PASSWORD")
+	}
+	sp := auth.ServicePrincipal{
+		ApplicationId:       *spClientId,
+		Password:            *spClientSecret,
+		CertificatePath:     *spCertificatePath,
+		CertificatePassword: *spCertificatePassword,
+		Tenant:              *spTenantId,
+	}
+
 	// Validate flags
-	if *spClientId == "" {
-		logger.Fatal("-sp-client-id required")
+	switch *destinationType {
+	case "azcopy":
+		if !storageSAS && *azcopyAuthMode == azcopy.LoginServicePrincipal {
+			if *spClientId == "" {
+				logger.Fatal("-sp-client-id required")
+			}
+			if *spClientSecret == "" && *spCertificatePath == "" {
+				logger.Fatal("-sp-client-secret or -sp-certificate-path required")
+			}
+			if *spTenantId == "" {
+				logger.Fatal("-sp-tenant-id required")
+			}
+		}
+		if *storageSASURL == "" {
+			if *storageAccount == "" {
+				logger.Fatal("-storage-account required")
+			}
+			if *storageContainer == "" {
+				logger.Fatal("-storage-container required")
+			}
+		}
+	case "blob":
+		if *storageKey == "" && !storageSAS {
+			if !sp.Complete() {
+				logger.Fatal("-storage-key, a -storage-sas-* flag or -sp-client-id, -sp-client-secret (or -sp-certificate-path) and -sp-tenant-id required with -destination blob")
+			}
+		}
+		if *storageSASURL == "" {
+			if *storageAccount == "" {
+				logger.Fatal("-storage-account required")
+			}
+			if *storageContainer == "" {
+				logger.Fatal("-storage-container required")
+			}
+		}
+	case "s3":
+		if *s3Bucket == "" {
+			logger.Fatal("-s3-bucket required with -destination s3")
+		}
+		if *s3AccessKeyID == "" || *s3SecretAccessKey == "" {
+			logger.Fatal("-s3-access-key-id and -s3-secret-access-key required with -destination s3")
+		}
+	case "fhir":
+		if *fhirServerURL == "" {
+			logger.Fatal("-fhir-url required with -destination fhir")
+		}
+		if *gzipOut {
+			logger.Fatal("-gzip cannot be used with -destination fhir")
+		}
+		if *bulkManifest {
+			logger.Fatal("-bulk-manifest cannot be used with -destination fhir")
+		}
+	case "kafka":
+		if *kafkaBrokers == "" {
+			logger.Fatal("-kafka-brokers required with -destination kafka")
+		}
+		if _, ok := kafkaAcksValues[*kafkaAcks]; !ok {
+			logger.Fatalf("-kafka-acks must be all, leader or none: %s", *kafkaAcks)
+		}
+		if *gzipOut {
+			logger.Fatal("-gzip cannot be used with -destination kafka")
+		}
+		if *bulkManifest {
+			logger.Fatal("-bulk-manifest cannot be used with -destination kafka")
+		}
+		if *verifyUpload {
+			logger.Fatal("-verify-upload cannot be used with -destination kafka")
+		}
+	case "local":
+		if *destinationPath == "" {
+			logger.Fatal("-destination-path required with -destination local")
+		}
+	default:
+		logger.Fatalf("-destination must be azcopy, blob, s3, fhir, kafka or local: %s", *destinationType)
+	}
+	switch *azcopyAuthMode {
+	case azcopy.LoginServicePrincipal, azcopy.LoginAzureCLI:
+	case azcopy.LoginManagedIdentity:
+		ids := 0
+		for _, id := range []string{*identityClientID, *identityObjectID, *identityResourceID} {
+			if id != "" {
+				ids++
+			}
+		}
+		if ids > 1 {
+			logger.Fatal("Only one of -identity-client-id, -identity-object-id and -identity-resource-id can be set")
+		}
+	default:
+		logger.Fatalf("-azcopy-auth-mode must be one of %s: %s", strings.Join(azcopy.LoginModes, ", "), *azcopyAuthMode)
+	}
+	if *spClientSecret != "" && *spCertificatePath != "" {
+		logger.Fatal("Only one of -sp-client-secret and -sp-certificate-path can be set")
+	}
+	// tokens requested without azcopy need an unencrypted PEM certificate: check it before generating anything
+	nativeToken := (*destinationType == "blob" && *storageKey == "" && !storageSAS) ||
+		((*destinationType == "fhir" || *fhirImport) && *fhirToken == "")
+	if nativeToken {
+		if err := sp.CheckCertificate(); err != nil {
+			logger.Error(err)
+			logger.Fatal("Invalid -sp-certificate-path: -destination blob and fhir, and -fhir-import, require a PEM file of a certificate and its unencrypted private key")
+		}
 	}
-	if *spClientSecret == "" {
-		logger.Fatal("-sp-client-secret required")
+	if *degradeRate > 0 && !*uploadDegraded && *degradeOut == "" {
+		logger.Fatal("-degrade-out or -upload-degraded required with -degrade-rate")
 	}
-	if *spTenantId == "" {
-		logger.Fatal("-sp-tenant-id required")
+	if *degradeOut != "" && *degradeRate <= 0 {
+		logger.Fatal("-degrade-out requires -degrade-rate")
 	}
-	if *storageAccount == "" {
-		logger.Fatal("-storage-account required")
+	if *uploadDegraded {
+		if *degradeRate <= 0 {
+			logger.Fatal("-upload-degraded requires -degrade-rate")
+		}
+		if *destinationType == "fhir" || *destinationType == "kafka" {
+			logger.Fatalf("-upload-degraded cannot be used with -destination %s", *destinationType)
+		}
+		if *degradeOut != "" {
+			logger.Fatal("-degrade-out cannot be used with -upload-degraded")
+		}
 	}
-	if *storageContainer == "" {
-		logger.Fatal("-storage-container required")
+	if *azcopyAuthMode != azcopy.LoginServicePrincipal && *destinationType != "azcopy" {
+		logger.Fatal("-azcopy-auth-mode requires -destination azcopy")
+	}
+
+	// parse the SAS of the storage destinations before generating anything
+	var sasContainerURL string
+	var sas auth.SAS
+	if storageSAS && (*destinationType == "azcopy" || *destinationType == "blob") {
+		var err error
+		sasContainerURL, sas, err = auth.LoadSAS(*storageSASURL, *storageSASTokenFile, *storageSASToken)
+		if err != nil {
+			logger.Error(err)
+			logger.Fatal("Invalid -storage-sas-url, -storage-sas-token-file or -storage-sas-token")
+		}
+	}
+	containerName := *storageContainer
+	if sasContainerURL != "" {
+		containerName = path.Base(sasContainerURL)
+	}
+	switch *uploadMode {
+	case "copy":
+		if *syncDeleteDestination != azcopy.DeleteNever {
+			logger.Fatal("-sync-delete-destination requires -upload-mode sync")
+		}
+	case "sync":
+		if *destinationType != "azcopy" {
+			logger.Fatal("-upload-mode sync requires -destination azcopy")
+		}
+		if *gzipOut {
+			logger.Fatal("-gzip cannot be used with -upload-mode sync: azcopy sync cannot set the Content-Encoding of gzipped files")
+		}
+		switch *syncDeleteDestination {
+		case azcopy.DeleteNever:
+		case azcopy.DeletePrompt:
+			if !azcopy.Interactive() {
+				logger.Fatal("-sync-delete-destination prompt requires a terminal to answer the prompts of azcopy on: use false, or true with -sync-delete-confirm")
+			}
+		case azcopy.DeleteAlways:
+			if *syncDeleteConfirm != containerName {
+				logger.Fatalf("-sync-delete-destination true deletes every blob of the container not in the dataset: confirm with -sync-delete-confirm %s", containerName)
+			}
+		default:
+			logger.Fatalf("-sync-delete-destination must be false, prompt or true: %s", *syncDeleteDestination)
+		}
+	default:
+		logger.Fatalf("-upload-mode must be copy or sync: %s", *uploadMode)
+	}
+	if *fhirImport {
+		if !*ndjson {
+			logger.Fatal("-fhir-import requires -synthea-ndjson")
+		}
+		if *fhirServerURL == "" {
+			logger.Fatal("-fhir-url required with -fhir-import")
+		}
+		switch *destinationType {
+		case "azcopy", "blob", "s3":
+		default:
+			logger.Fatal("-fhir-import requires a storage destination the FHIR server can read from: azcopy, blob or s3")
+		}
+		if *gzipOut {
+			// -gzip replaces the .ndjson files by .ndjson.gz, which $import does not read as application/fhir+ndjson
+			logger.Fatal("-fhir-import cannot be used with -gzip")
+		}
+		if *fhirImportMode != bulkdata.InitialLoad && *fhirImportMode != bulkdata.IncrementalLoad {
+			logger.Fatalf("-fhir-import-mode must be %s or %s: %s", bulkdata.IncrementalLoad, bulkdata.InitialLoad, *fhirImportMode)
+		}
+	}
+	var transformSpecs []transform.Spec
+	if *transformConfig != "" {
+		specs, err := transform.LoadConfig(*transformConfig)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		transformSpecs = specs
+	}
+	for _, t := range transforms {
+		transformSpecs = append(transformSpecs, transform.ParseSpec(t))
+	}
+	pipeline, err := transform.NewPipeline(transformSpecs)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	var shiftTo time.Time
+	if *dateShiftTo != "" {
+		if shiftTo, err = time.Parse("2006-01-02", *dateShiftTo); err != nil {
+			logger.Fatalf("-date-shift-to must be a date in the form YYYY-MM-DD: %s", *dateShiftTo)
+		}
+	}
+	if *bulkManifest && !*ndjson {
+		logger.Fatal("-bulk-manifest requires -synthea-ndjson")
+	}
+	if *gzipCSV && !*gzipOut {
+		logger.Fatal("-gzip-csv requires -gzip")
+	}
+	if *csv {
+		if _, err := syntheacsv.Schemas(*csvVersion); err != nil {
+			logger.Fatal(err)
+		}
 	}
 	// if -synthea-path provided:
 	// - set -synthea-no-clean to true
@@ -107,37 +422,352 @@ func main() {
 		logger.Fatal(err)
 	}
 	syntheaOut := path.Join(installPath, "output")
+	generatedAt := time.Now()
 	logger.Infof("Completed generating FHIR data at: %s", syntheaOut)
+	if *csv {
+		issues, err := syntheacsv.Validate(path.Join(syntheaOut, syntheacsv.Subdir), *csvVersion)
+		if err != nil {
+			logger.Error(err)
+			logger.Fatal("Failed validating Synthea CSV output")
+		}
+		for _, issue := range issues {
+			logger.Warn(issue)
+		}
+		if len(issues) > 0 {
+			if *csvStrict {
+				logger.Fatalf("Synthea CSV output does not match the Synthea %s layout", *csvVersion)
+			}
+			logger.Warnf("Synthea CSV output does not match the Synthea %s layout: %d issues", *csvVersion, len(issues))
+		}
+	}
 
 	////////////////////////////////////////////////////////////////////////////////
-	// Copy data to Azure storage
+	// Post-process generated data
 	////////////////////////////////////////////////////////////////////////////////
-	azc, err := azcopy.InstallIfNotPresentAndGetCtx()
-	if err != nil {
-		logger.Error(err)
-		logger.Fatal("Failed to install AzCopy")
+	fhirOut := path.Join(syntheaOut, fhir.Subdir)
+	if *normalizeProviders {
+		logger.Info("Normalizing shared provider resources...")
+		summary, err := providers.Normalize(fhirOut, fhirOut)
+		if err != nil {
+			logger.Error(err)
+			logger.Fatal("Failed normalizing shared provider resources")
+		}
+		logger.Infof("Normalization complete! Rewrote %d references", summary.References)
+	}
+	if !shiftTo.IsZero() {
+		latest, err := dateshift.Latest(fhirOut)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		*dateShiftDays = dateshift.Days(latest, shiftTo)
+	}
+	if *dateShiftDays != 0 {
+		logger.Infof("Shifting dates by %d days...", *dateShiftDays)
+		if err := dateshift.Dataset(syntheaOut, *dateShiftDays); err != nil {
+			logger.Error(err)
+			logger.Fatal("Failed shifting dates of generated data")
+		}
+	}
+	if len(pipeline) > 0 {
+		logger.Infof("Applying %d transformers...", len(pipeline))
+		files, err := transform.Apply(fhirOut, pipeline)
+		if err != nil {
+			logger.Error(err)
+			logger.Fatal("Failed transforming generated data")
+		}
+		logger.Infof("Transformed %d files", files)
+	}
+	if *degradeRate > 0 {
+		// kept out of the uploaded output unless asked for, so intentionally corrupted records never land next to the
+		// clean dataset by mistake
+		degradedOut := *degradeOut
+		if *uploadDegraded {
+			degradedOut = path.Join(syntheaOut, "degraded")
+		}
+		logger.Infof("Writing degraded copy of the dataset to %s...", degradedOut)
+		report, err := degrade.Run(syntheaOut, degradedOut, degrade.Options{Seed: *degradeSeed, Rate: *degradeRate})
+		if err != nil {
+			logger.Error(err)
+			logger.Fatal("Failed degrading generated data")
+		}
+		logger.Infof("Degraded copy complete! Made %d changes", len(report.Changes))
 	}
-	defer azc.Cleanup()
 
-	// Login to azcopy with provided SP
-	logger.Info("Logging into AzCopy with provided service principal credentials...")
-	sp := auth.ServicePrincipal{
-		ApplicationId: *spClientId,
-		Password:      *spClientSecret,
-		Tenant:        *spTenantId,
+	if *parquetOut {
+		logger.Info("Exporting Parquet datasets...")
+		if _, err := export.Parquet(fhirOut, path.Join(syntheaOut, export.ParquetSubdir), parquet.Gzip); err != nil {
+			logger.Error(err)
+			logger.Fatal("Failed exporting Parquet datasets")
+		}
 	}
-	if err = azc.Login(sp); err != nil {
-		logger.Error(err)
-		logger.Fatal("Failed to authenticate AzCopy with provided service principal credentials")
+
+	if *ndjsonMaxBytes > 0 || *ndjsonMaxResources > 0 {
+		logger.Info("Splitting large NDJSON files...")
+		parts, err := bulkdata.Split(fhirOut, bulkdata.Limits{MaxBytes: *ndjsonMaxBytes, MaxResources: *ndjsonMaxResources})
+		if err != nil {
+			logger.Error(err)
+			logger.Fatal("Failed splitting NDJSON files")
+		}
+		logger.Infof("Split %d NDJSON files", len(parts))
+	}
+
+	if *gzipOut {
+		// only the FHIR (and CSV) output -- reports, exports and Synthea metadata are uploaded as-is
+		roots := []string{syntheaOut}
+		if *uploadDegraded {
+			roots = append(roots, path.Join(syntheaOut, "degraded"))
+		}
+		compressed := 0
+		logger.Info("Compressing output...")
+		for _, root := range roots {
+			dirs := map[string][]string{path.Join(root, fhir.Subdir): {".ndjson", ".json"}}
+			if *gzipCSV {
+				dirs[path.Join(root, syntheacsv.Subdir)] = []string{".csv"}
+			}
+			for dir, extensions := range dirs {
+				if _, err := os.Stat(dir); os.IsNotExist(err) {
+					continue
+				}
+				files, err := compress.Dir(dir, compress.Options{Extensions: extensions})
+				if err != nil {
+					logger.Error(err)
+					logger.Fatal("Failed compressing generated data")
+				}
+				compressed += len(files)
+			}
+		}
+		logger.Infof("Compressed %d files", compressed)
+	}
+
+	////////////////////////////////////////////////////////////////////////////////
+	// Upload data to the destination
+	////////////////////////////////////////////////////////////////////////////////
+	var dest destination.Destination
+	switch *destinationType {
+	case "local":
+		local, err := destination.NewLocal(*destinationPath)
+		if err != nil {
+			logger.Error(err)
+			logger.Fatalf("Failed creating destination directory %s", *destinationPath)
+		}
+		dest = local
+	case "azcopy":
+		azc, err := azcopy.InstallIfNotPresentAndGetCtx()
+		if err != nil {
+			logger.Error(err)
+			logger.Fatal("Failed to install AzCopy")
+		}
+		defer azc.Cleanup()
+		azc.Retry = azcopy.RetryPolicy{MaxResumes: *azcopyMaxResumes, Delay: *azcopyResumeDelay}
+
+		azcopyDest := destination.NewAzCopy(azc, *storageAccount, *storageContainer)
+		if sas != "" {
+			// the SAS authorizes every azcopy command, so no login is needed
+			logger.Info("Authenticating AzCopy with the provided SAS")
+			if sasContainerURL != "" {
+				azcopyDest.ContainerURL = sasContainerURL
+			}
+			azcopyDest.SAS = sas
+		} else {
+			// Login to azcopy with provided SP, managed identity or Azure CLI session
+			logger.Infof("Logging into AzCopy with %s credentials...", *azcopyAuthMode)
+			login := azcopy.LoginOptions{
+				Mode:               *azcopyAuthMode,
+				ServicePrincipal:   sp,
+				IdentityClientID:   *identityClientID,
+				IdentityObjectID:   *identityObjectID,
+				IdentityResourceID: *identityResourceID,
+				Tenant:             *spTenantId,
+			}
+			if err = azc.LoginWithOptions(login); err != nil {
+				logger.Error(err)
+				logger.Fatalf("Failed to authenticate AzCopy with %s credentials", *azcopyAuthMode)
+			}
+			logger.Info("Login complete!")
+		}
+		if *uploadMode == "sync" {
+			azcopyDest.Sync = true
+			azcopyDest.DeleteDestination = *syncDeleteDestination
+			if *syncDeleteDestination == azcopy.DeleteAlways {
+				logger.Warnf("Blobs of %s not in the dataset will be deleted", azcopyDest.ContainerURL)
+			}
+		}
+		dest = azcopyDest
+	case "blob":
+		var credential blob.Credential
+		if sas != "" {
+			credential = blob.NewSAS(sas)
+		} else if *storageKey != "" {
+			key, err := blob.NewSharedKey(*storageAccount, *storageKey)
+			if err != nil {
+				logger.Error(err)
+				logger.Fatal("Invalid -storage-key")
+			}
+			credential = key
+		} else {
+			credential = blob.NewToken(sp)
+		}
+		endpoint := *storageEndpoint
+		if endpoint == "" {
+			endpoint = "https://" + *storageAccount + ".blob.core.windows.net"
+		}
+		containerURL := strings.TrimSuffix(endpoint, "/") + "/" + *storageContainer
+		if sasContainerURL != "" {
+			containerURL = sasContainerURL
+		}
+		client, err := blob.NewClient(containerURL, credential, *uploadConcurrency)
+		if err != nil {
+			logger.Error(err)
+			logger.Fatalf("Invalid -storage-endpoint %s", endpoint)
+		}
+		dest = destination.NewBlob(client)
+	case "s3":
+		credentials := s3.EnvCredentials()
+		credentials.AccessKeyID = *s3AccessKeyID
+		credentials.SecretAccessKey = *s3SecretAccessKey
+		client, err := s3.NewClient(*s3Endpoint, *s3Region, *s3Bucket, *s3PathStyle, credentials, *uploadConcurrency)
+		if err != nil {
+			logger.Error(err)
+			logger.Fatalf("Invalid -s3-endpoint %s", *s3Endpoint)
+		}
+		dest = destination.NewS3(client, *s3Prefix)
+	case "fhir":
+		client, err := fhir.NewClient(*fhirServerURL, fhirAuthorizer(*fhirServerURL, *fhirToken, sp), *uploadConcurrency)
+		if err != nil {
+			logger.Error(err)
+			logger.Fatalf("Invalid -fhir-url %s", *fhirServerURL)
+		}
+		dest = destination.NewFHIRServer(client)
+	case "kafka":
+		config := kafka.Config{
+			Brokers:     strings.Split(*kafkaBrokers, ","),
+			Acks:        kafkaAcksValues[*kafkaAcks],
+			Compression: *kafkaCompression,
+			BatchBytes:  *kafkaBatchBytes,
+			MaxRetries:  *kafkaMaxRetries,
+		}
+		if *kafkaTLS {
+			config.TLS = &tls.Config{}
+		}
+		writer, err := kafka.NewWriter(config)
+		if err != nil {
+			logger.Error(err)
+			logger.Fatal("Invalid Kafka flags")
+		}
+		defer writer.Close()
+		dest = destination.NewKafka(writer, *kafkaTopic, *kafkaTopicPrefix)
+	}
+
+	if *bulkManifest {
+		fhirURL := dest.URL(fhir.Subdir)
+		request := *bulkManifestRequest
+		if request == "" {
+			request = fhirURL + "/$export"
+		}
+		manifest, err := bulkdata.NewManifest(fhirOut, fhirURL, request, generatedAt)
+		if err != nil {
+			logger.Error(err)
+			logger.Fatal("Failed building Bulk Data manifest")
+		}
+		// storage containers only allow authenticated reads
+		manifest.RequiresAccessToken = *destinationType != "local"
+		if err := manifest.Write(path.Join(syntheaOut, bulkdata.ManifestName)); err != nil {
+			logger.Error(err)
+			logger.Fatal("Failed writing Bulk Data manifest")
+		}
+		logger.Infof("Wrote Bulk Data manifest listing %d files", len(manifest.Output))
 	}
-	logger.Info("Login complete!")
 
-	// Copy the contents of the synthea output directory to azure storage
-	targetBlob := fmt.Sprintf("https://%s.blob.core.windows.net/%s", *storageAccount, *storageContainer)
-	logger.Infof("Beginning data copy from %s to %s", syntheaOut, targetBlob)
-	if err = azc.Copy(path.Join(syntheaOut, "*"), targetBlob); err != nil {
+	// Upload the contents of the synthea output directory
+	target := dest.URL("")
+	logger.Infof("Beginning data upload from %s to %s", syntheaOut, target)
+	err = dest.Upload(syntheaOut)
+	if reporter, ok := dest.(destination.Reporter); ok {
+		logger.Info(reporter.Report())
+	}
+	if err != nil {
 		logger.Error(err)
-		logger.Fatalf("Failed copying files from %s to %s", syntheaOut, targetBlob)
+		if *destinationType == "azcopy" {
+			// exiting skips the cleanup of the output, so the upload can be resumed
+			logger.Warnf("The generated data is kept at %s: resume its upload with `divoc upload -resume -dir %s` and the same -sp-* and -storage-* flags", syntheaOut, syntheaOut)
+		}
+		logger.Fatalf("Failed uploading files from %s to %s", syntheaOut, target)
 	}
 	logger.Info("Transfer complete!")
+	if *verifyUpload {
+		logger.Info("Verifying upload...")
+		if err := dest.Verify(syntheaOut); err != nil {
+			logger.Error(err)
+			logger.Fatalf("Failed verifying upload to %s", target)
+		}
+		logger.Info("Verification complete!")
+	}
+
+	////////////////////////////////////////////////////////////////////////////////
+	// Import the uploaded data into the FHIR server
+	////////////////////////////////////////////////////////////////////////////////
+	if *fhirImport {
+		client, err := fhir.NewClient(*fhirServerURL, fhirAuthorizer(*fhirServerURL, *fhirToken, sp), 1)
+		if err != nil {
+			logger.Error(err)
+			logger.Fatalf("Invalid -fhir-url %s", *fhirServerURL)
+		}
+		inputs, err := bulkdata.NewManifest(fhirOut, dest.URL(fhir.Subdir), "", generatedAt)
+		if err != nil {
+			logger.Error(err)
+			logger.Fatal("Failed listing NDJSON files to import")
+		}
+		logger.Infof("Starting %s $import of %d files into %s...", *fhirImportMode, len(inputs.Output), *fhirServerURL)
+		statusURL, err := bulkdata.StartImport(client, inputs, *fhirImportMode)
+		if err != nil {
+			logger.Error(err)
+			logger.Fatal("Failed starting $import")
+		}
+		result, err := bulkdata.PollImport(client, statusURL, *fhirImportInterval, *fhirImportTimeout, func(progress string) {
+			if progress != "" {
+				logger.Infof("Import in progress: %s", progress)
+			}
+		})
+		if err != nil {
+			logger.Error(err)
+			logger.Fatalf("Failed importing into %s", *fhirServerURL)
+		}
+		imported := map[string]int{}
+		for _, output := range result.Output {
+			imported[output.Type] += output.Count
+		}
+		importErrors := result.ImportErrors()
+		var types []string
+		for t := range imported {
+			types = append(types, t)
+		}
+		for t := range importErrors {
+			if _, ok := imported[t]; !ok {
+				types = append(types, t)
+			}
+		}
+		sort.Strings(types)
+		for _, t := range types {
+			logger.Infof("Imported %d %s resources, %d failed", imported[t], t, importErrors[t])
+		}
+		for _, e := range result.Error {
+			logger.Warnf("Failed importing %d resources of %s -- details at %s", e.Count, e.InputURL, e.URL)
+		}
+		if len(result.Error) > 0 {
+			logger.Fatalf("Failed importing %d files into %s", len(result.Error), *fhirServerURL)
+		}
+		logger.Info("Import complete!")
+	}
+}
+
+// fhirAuthorizer authorizes requests to a FHIR server with a bearer token, or an Azure AD token of a service principal
+// for the server. Returns nil for servers without authentication.
+func fhirAuthorizer(serverURL string, token string, sp auth.ServicePrincipal) fhir.Authorizer {
+	if token != "" {
+		return fhir.BearerToken(token)
+	}
+	if sp.Complete() {
+		return auth.NewToken(sp, strings.TrimSuffix(serverURL, "/")+"/.default")
+	}
+	return nil
 }
@@ -4,5 +4,6 @@ go 1.14
 
 require (
 	github.com/google/go-github/v31 v31.0.0
+	github.com/mattn/go-sqlite3 v1.14.5
 	github.com/sirupsen/logrus v1.6.0
 )
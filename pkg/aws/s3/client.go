@@ -0,0 +1,291 @@
+package s3
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"microsoft.com/divoc/pkg/logger"
+	"microsoft.com/divoc/pkg/rest"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults of the Client settings
+const (
+	DefaultRegion      = "us-east-1"
+	DefaultPartSize    = 8 << 20 // 8 MiB -- S3 requires at least 5 MiB for every part but the last
+	DefaultConcurrency = rest.DefaultConcurrency
+	DefaultMaxRetries  = rest.DefaultMaxRetries
+)
+
+// Properties set on uploaded objects
+type Properties struct {
+	ContentType     string
+	ContentEncoding string
+}
+
+// Client uploads to, lists and deletes objects of a single bucket through the S3 REST API
+type Client struct {
+	Endpoint    *url.URL // eg: https://s3.us-east-1.amazonaws.com or http://127.0.0.1:9000 for MinIO
+	Bucket      string
+	Region      string
+	PathStyle   bool // address the bucket as a path of the endpoint (eg: MinIO) rather than a subdomain
+	Credentials Credentials
+	PartSize    int64        // files larger than this are uploaded in parts of this size
+	REST        *rest.Client // sends the requests of the client
+}
+
+// NewClient returns a Client for a bucket with the default settings. An empty endpoint uses the AWS endpoint of the
+// region. concurrency bounds the number of requests in flight, and the parts held in memory, across all uploads of the
+// client; <= 0 uses DefaultConcurrency.
+func NewClient(endpoint string, region string, bucket string, pathStyle bool, credentials Credentials, concurrency int) (*Client, error) {
+	if region == "" {
+		region = DefaultRegion
+	}
+	if endpoint == "" {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	}
+	u, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("S3 endpoint must be an absolute URL: %s", endpoint)
+	}
+	c := &Client{
+		Endpoint:    u,
+		Bucket:      bucket,
+		Region:      region,
+		PathStyle:   pathStyle,
+		Credentials: credentials,
+		PartSize:    DefaultPartSize,
+	}
+	c.REST = rest.NewClient(concurrency, c.prepare)
+	return c, nil
+}
+
+// ObjectURL returns the URL of an object from its key. An empty key is the URL of the bucket.
+func (c *Client) ObjectURL(key string) *url.URL {
+	u := *c.Endpoint
+	path := c.Endpoint.Path
+	if c.PathStyle {
+		path += "/" + c.Bucket
+	} else {
+		u.Host = c.Bucket + "." + u.Host
+	}
+	if key != "" || !c.PathStyle {
+		path += "/" + strings.TrimPrefix(key, "/")
+	}
+	u.Path = path
+	u.RawPath = canonicalURI(u.Path)
+	return &u
+}
+
+// UploadFile uploads a local file to an object, replacing it if it exists. Files up to PartSize are uploaded with a
+// single request; larger files are uploaded as a multipart upload with parts in parallel, which is aborted if any
+// part fails. Every request carries the MD5 hash of its content.
+func (c *Client) UploadFile(path string, key string, props Properties) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if info.Size() <= c.PartSize {
+		return c.REST.ReadAll(f, func(data []byte) error {
+			header := props.header()
+			header.Set("Content-MD5", contentMD5(data))
+			return c.do(http.MethodPut, key, nil, header, data)
+		})
+	}
+
+	uploadID, err := c.createMultipartUpload(key, props)
+	if err != nil {
+		return err
+	}
+	etags, err := c.uploadParts(f, key, uploadID)
+	if err == nil {
+		err = c.completeMultipartUpload(key, uploadID, etags)
+	}
+	if err != nil {
+		// parts of an incomplete upload are billed until it is aborted
+		if abortErr := c.do(http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, http.Header{}, nil); abortErr != nil {
+			logger.Warnf("Failed aborting multipart upload of %s: %s", key, abortErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// createMultipartUpload starts a multipart upload and returns its id
+func (c *Client) createMultipartUpload(key string, props Properties) (string, error) {
+	resp, err := c.request(http.MethodPost, key, url.Values{"uploads": {""}}, props.header(), nil)
+	if err != nil {
+		return "", err
+	}
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.Unmarshal(resp.Body, &result); err != nil || result.UploadID == "" {
+		return "", fmt.Errorf("failed parsing multipart upload of %s: %s", key, resp.Body)
+	}
+	return result.UploadID, nil
+}
+
+// uploadParts uploads the parts of a file in parallel and returns their ETags in order
+func (c *Client) uploadParts(f *os.File, key string, uploadID string) ([]string, error) {
+	etags := map[int]string{}
+	var lock sync.Mutex
+	parts, err := c.REST.UploadChunks(f, c.PartSize, func(i int, part []byte) error {
+		header := http.Header{}
+		header.Set("Content-MD5", contentMD5(part))
+		query := url.Values{"partNumber": {strconv.Itoa(i + 1)}, "uploadId": {uploadID}}
+		resp, err := c.request(http.MethodPut, key, query, header, part)
+		if err != nil {
+			return err
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		etags[i] = resp.Header.Get("ETag")
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	ordered := make([]string, parts)
+	for i := range ordered {
+		ordered[i] = etags[i]
+	}
+	return ordered, nil
+}
+
+// completeMultipartUpload assembles the uploaded parts into the object
+func (c *Client) completeMultipartUpload(key string, uploadID string, etags []string) error {
+	var body bytes.Buffer
+	body.WriteString("<CompleteMultipartUpload>")
+	for i, etag := range etags {
+		fmt.Fprintf(&body, "<Part><PartNumber>%d</PartNumber><ETag>", i+1)
+		xml.EscapeText(&body, []byte(etag))
+		body.WriteString("</ETag></Part>")
+	}
+	body.WriteString("</CompleteMultipartUpload>")
+	header := http.Header{}
+	header.Set("Content-MD5", contentMD5(body.Bytes()))
+	// completing an upload twice completes it once, so failures are retried like those of idempotent requests. S3 may
+	// report a failed completion with a 200 status and an Error body.
+	resp, err := c.REST.DoIdempotent(http.MethodPost, c.target(key, url.Values{"uploadId": {uploadID}}), header, body.Bytes())
+	if err != nil {
+		return err
+	}
+	if bytes.Contains(resp.Body, []byte("<Error>")) {
+		return fmt.Errorf("failed completing multipart upload of %s: %s", key, resp.Body)
+	}
+	return nil
+}
+
+// List returns the size of every object in the bucket whose key starts with prefix, keyed by key
+func (c *Client) List(prefix string) (map[string]int64, error) {
+	objects := map[string]int64{}
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}}
+		if prefix != "" {
+			query.Set("prefix", prefix)
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		var page struct {
+			Contents []struct {
+				Key  string `xml:"Key"`
+				Size int64  `xml:"Size"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		resp, err := c.request(http.MethodGet, "", query, http.Header{}, nil)
+		if err != nil {
+			return nil, err
+		}
+		if err := xml.Unmarshal(resp.Body, &page); err != nil {
+			return nil, fmt.Errorf("failed parsing object list: %s", err)
+		}
+		for _, object := range page.Contents {
+			objects[object.Key] = object.Size
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// Delete an object. Deleting an object which does not exist is not an error.
+func (c *Client) Delete(key string) error {
+	err := c.do(http.MethodDelete, key, nil, http.Header{}, nil)
+	if statusErr, ok := err.(*StatusError); ok && statusErr.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
+
+// header returns the request headers setting the properties on an uploaded object
+func (p Properties) header() http.Header {
+	header := http.Header{}
+	if p.ContentType != "" {
+		header.Set("Content-Type", p.ContentType)
+	}
+	if p.ContentEncoding != "" {
+		header.Set("Content-Encoding", p.ContentEncoding)
+	}
+	return header
+}
+
+// StatusError is an unexpected response from S3
+type StatusError = rest.StatusError
+
+// do sends a request and discards the response
+func (c *Client) do(method string, key string, query url.Values, header http.Header, body []byte) error {
+	_, err := c.request(method, key, query, header, body)
+	return err
+}
+
+// request sends a request for an object (or the bucket if key is empty) through the REST client of the client
+func (c *Client) request(method string, key string, query url.Values, header http.Header, body []byte) (*rest.Response, error) {
+	return c.REST.Do(method, c.target(key, query), header, body)
+}
+
+// target returns the URL of a request for an object (or the bucket if key is empty)
+func (c *Client) target(key string, query url.Values) string {
+	target := c.ObjectURL(key)
+	// send the query exactly as it is signed
+	target.RawQuery = canonicalQuery(query)
+	return target.String()
+}
+
+// prepare signs every attempt of a request
+func (c *Client) prepare(req *http.Request, body []byte) error {
+	payloadHash := emptyHash
+	if len(body) > 0 {
+		payloadHash = hashHex(body)
+	}
+	c.Credentials.Sign(req, c.Region, payloadHash, time.Now())
+	return nil
+}
+
+// contentMD5 returns the base64 encoded MD5 hash of data for the Content-MD5 header
+func contentMD5(data []byte) string {
+	sum := md5.Sum(data)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
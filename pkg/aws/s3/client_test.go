@@ -0,0 +1,409 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"microsoft.com/divoc/pkg/internal/testutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testCredentials sign the requests of the test clients
+var testCredentials = Credentials{AccessKeyID: "key", SecretAccessKey: "secret", SessionToken: "token"}
+
+// request is a request received by fakeS3
+type request struct {
+	method string
+	host   string
+	path   string
+	query  url.Values
+	md5    string // Content-MD5 header
+	size   int    // bytes of the body
+}
+
+// fakeS3 is an in-memory S3 bucket named "bucket", addressed path-style (like MinIO) or virtual-hosted (like AWS).
+// Every request must carry a valid SigV4 signature of testCredentials in DefaultRegion.
+type fakeS3 struct {
+	t        *testing.T
+	lock     sync.Mutex
+	requests []request
+	objects  map[string][]byte
+	types    map[string]string
+	uploads  map[string]map[int][]byte // parts by upload id and part number
+	aborted  []string
+	failPart int // part number to fail with a 400, if any
+	next     int
+}
+
+func newFakeS3(t *testing.T) *fakeS3 {
+	return &fakeS3{t: t, objects: map[string][]byte{}, types: map[string]string{}, uploads: map[string]map[int][]byte{}}
+}
+
+// checkSignature recomputes the signature of a request from the headers it signed
+func (s *fakeS3) checkSignature(r *http.Request, body []byte) {
+	auth := r.Header.Get("Authorization")
+	fields := map[string]string{}
+	for _, field := range strings.Split(strings.TrimPrefix(auth, "AWS4-HMAC-SHA256 "), ", ") {
+		if i := strings.Index(field, "="); i >= 0 {
+			fields[field[:i]] = field[i+1:]
+		}
+	}
+	signedHeaders := ";" + fields["SignedHeaders"] + ";"
+	for _, name := range []string{"host", "x-amz-date", "x-amz-content-sha256", "x-amz-security-token"} {
+		if !strings.Contains(signedHeaders, ";"+name+";") {
+			s.t.Errorf("%s %s: %s not signed", r.Method, r.URL, name)
+		}
+	}
+	if r.Header.Get("Content-MD5") != "" && !strings.Contains(signedHeaders, ";content-md5;") {
+		s.t.Errorf("%s %s: Content-MD5 not signed", r.Method, r.URL)
+	}
+	if r.Header.Get("x-amz-content-sha256") != hashHex(body) {
+		s.t.Errorf("%s %s: x-amz-content-sha256 does not match the body", r.Method, r.URL)
+	}
+
+	date, err := time.Parse(timeFormat, r.Header.Get("x-amz-date"))
+	if err != nil {
+		s.t.Errorf("%s %s: invalid x-amz-date: %s", r.Method, r.URL, err)
+		return
+	}
+	signed, err := http.NewRequest(r.Method, "http://"+r.Host+r.URL.RequestURI(), nil)
+	if err != nil {
+		s.t.Error(err)
+		return
+	}
+	for _, name := range strings.Split(fields["SignedHeaders"], ";") {
+		if name != "host" {
+			signed.Header[http.CanonicalHeaderKey(name)] = r.Header[http.CanonicalHeaderKey(name)]
+		}
+	}
+	testCredentials.Sign(signed, DefaultRegion, r.Header.Get("x-amz-content-sha256"), date)
+	if expected := signed.Header.Get("Authorization"); auth != expected {
+		s.t.Errorf("%s %s: Authorization %q, expected %q", r.Method, r.URL, auth, expected)
+	}
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	s.checkSignature(r, body)
+	if md5Header := r.Header.Get("Content-MD5"); md5Header != "" && md5Header != contentMD5(body) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	// the bucket is the first label of the host, or the first segment of the path
+	var key string
+	if strings.HasPrefix(r.Host, "bucket.") {
+		key = strings.TrimPrefix(r.URL.Path, "/")
+	} else if r.URL.Path == "/bucket" || strings.HasPrefix(r.URL.Path, "/bucket/") {
+		key = strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/bucket"), "/")
+	} else {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	query := r.URL.Query()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.requests = append(s.requests, request{r.Method, r.Host, r.URL.Path, query, r.Header.Get("Content-MD5"), len(body)})
+	switch {
+	case r.Method == http.MethodPost && query["uploads"] != nil:
+		s.next++
+		id := fmt.Sprintf("upload-%d", s.next)
+		s.uploads[id] = map[int][]byte{}
+		s.types[key] = r.Header.Get("Content-Type")
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>%s</UploadId></InitiateMultipartUploadResult>", id)
+	case r.Method == http.MethodPut && query.Get("uploadId") != "":
+		part, _ := strconv.Atoi(query.Get("partNumber"))
+		upload, ok := s.uploads[query.Get("uploadId")]
+		if !ok || part < 1 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if part == s.failPart {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		upload[part] = body
+		w.Header().Set("ETag", fmt.Sprintf(`"etag-%d"`, part))
+	case r.Method == http.MethodPost && query.Get("uploadId") != "":
+		var complete struct {
+			Parts []struct {
+				PartNumber int
+				ETag       string
+			} `xml:"Part"`
+		}
+		if err := xml.Unmarshal(body, &complete); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		parts := s.uploads[query.Get("uploadId")]
+		var data []byte
+		for i, part := range complete.Parts {
+			if part.PartNumber != i+1 || part.ETag != fmt.Sprintf(`"etag-%d"`, i+1) {
+				fmt.Fprint(w, "<Error><Code>InvalidPart</Code></Error>")
+				return
+			}
+			data = append(data, parts[part.PartNumber]...)
+		}
+		s.objects[key] = data
+		delete(s.uploads, query.Get("uploadId"))
+		fmt.Fprint(w, "<CompleteMultipartUploadResult></CompleteMultipartUploadResult>")
+	case r.Method == http.MethodDelete && query.Get("uploadId") != "":
+		s.aborted = append(s.aborted, query.Get("uploadId"))
+		delete(s.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut:
+		s.objects[key] = body
+		s.types[key] = r.Header.Get("Content-Type")
+	case r.Method == http.MethodGet && key == "" && query.Get("list-type") == "2":
+		// pages of 2 objects, continued from the key in the token
+		var keys []string
+		for k := range s.objects {
+			if strings.HasPrefix(k, query.Get("prefix")) && k >= query.Get("continuation-token") {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		truncated := len(keys) > 2
+		fmt.Fprint(w, "<ListBucketResult>")
+		for i, k := range keys {
+			if i == 2 {
+				fmt.Fprintf(w, "<NextContinuationToken>%s</NextContinuationToken>", k)
+				break
+			}
+			fmt.Fprintf(w, "<Contents><Key>%s</Key><Size>%d</Size></Contents>", k, len(s.objects[k]))
+		}
+		fmt.Fprintf(w, "<IsTruncated>%t</IsTruncated></ListBucketResult>", truncated)
+	case r.Method == http.MethodDelete:
+		if _, ok := s.objects[key]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// received returns the requests received since the last call
+func (s *fakeS3) received() []request {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	requests := s.requests
+	s.requests = nil
+	return requests
+}
+
+// newTestClient returns a client of a fake bucket. Virtual-hosted requests (to bucket.127.0.0.1) reach the fake
+// through a dialer ignoring the host.
+func newTestClient(t *testing.T, pathStyle bool) (*Client, *fakeS3, func()) {
+	fake := newFakeS3(t)
+	server := httptest.NewServer(fake)
+	client, err := NewClient(server.URL, "", "bucket", pathStyle, testCredentials, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.PartSize = 100
+	client.REST.HTTPClient = &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, server.Listener.Addr().String())
+		},
+	}}
+	return client, fake, server.Close
+}
+
+func TestUploadFile(t *testing.T) {
+	client, fake, done := newTestClient(t, true)
+	defer done()
+	dir, err := ioutil.TempDir("", "s3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, size := range []int{0, 10, 100, 101, 1003} {
+		key := fmt.Sprintf("fhir/file %d.ndjson", size)
+		path, data := testutil.WriteFile(t, dir, fmt.Sprint(size), size)
+		if err := client.UploadFile(path, key, Properties{ContentType: "application/fhir+ndjson"}); err != nil {
+			t.Fatalf("upload of %d bytes: %s", size, err)
+		}
+		if !bytes.Equal(fake.objects[key], data) {
+			t.Errorf("upload of %d bytes: object holds %d bytes", size, len(fake.objects[key]))
+		}
+		if fake.types[key] != "application/fhir+ndjson" {
+			t.Errorf("upload of %d bytes: content type %q", size, fake.types[key])
+		}
+
+		requests := fake.received()
+		if size <= 100 {
+			// a single PUT of the whole file
+			if len(requests) != 1 || requests[0].method != http.MethodPut || len(requests[0].query) != 0 ||
+				requests[0].md5 != contentMD5(data) {
+				t.Errorf("upload of %d bytes: requests %v", size, requests)
+			}
+			continue
+		}
+		// create, one PUT per part of at most PartSize bytes in any order, then complete
+		parts := (size + 99) / 100
+		if len(requests) != parts+2 {
+			t.Fatalf("upload of %d bytes: %d requests, expected %d", size, len(requests), parts+2)
+		}
+		create, complete := requests[0], requests[len(requests)-1]
+		if create.method != http.MethodPost || !reflect.DeepEqual(create.query, url.Values{"uploads": {""}}) {
+			t.Errorf("upload of %d bytes: created with %v", size, create)
+		}
+		uploadID := fmt.Sprintf("upload-%d", fake.next)
+		if complete.method != http.MethodPost || !reflect.DeepEqual(complete.query, url.Values{"uploadId": {uploadID}}) || complete.md5 == "" {
+			t.Errorf("upload of %d bytes: completed with %v", size, complete)
+		}
+		seen := map[int]bool{}
+		for _, part := range requests[1 : len(requests)-1] {
+			number, _ := strconv.Atoi(part.query.Get("partNumber"))
+			if part.method != http.MethodPut || part.query.Get("uploadId") != uploadID || len(part.query) != 2 || seen[number] {
+				t.Errorf("upload of %d bytes: part %v", size, part)
+				continue
+			}
+			seen[number] = true
+			expected := data[(number-1)*100:]
+			if len(expected) > 100 {
+				expected = expected[:100]
+			}
+			if part.size != len(expected) || part.md5 != contentMD5(expected) {
+				t.Errorf("upload of %d bytes: part %d of %d bytes with Content-MD5 %s", size, number, part.size, part.md5)
+			}
+		}
+		if len(seen) != parts || !seen[1] || !seen[parts] {
+			t.Errorf("upload of %d bytes: parts %v", size, seen)
+		}
+	}
+	if len(fake.uploads) != 0 {
+		t.Errorf("%d multipart uploads left incomplete", len(fake.uploads))
+	}
+}
+
+func TestUploadFileAbortsFailedMultipartUpload(t *testing.T) {
+	client, fake, done := newTestClient(t, true)
+	defer done()
+	dir, err := ioutil.TempDir("", "s3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path, _ := testutil.WriteFile(t, dir, "a", 1000)
+
+	fake.failPart = 3
+	err = client.UploadFile(path, "a", Properties{})
+	if statusErr, ok := err.(*StatusError); !ok || statusErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected a 400 StatusError, got %v", err)
+	}
+	if len(fake.aborted) != 1 || fake.aborted[0] != "upload-1" || len(fake.uploads) != 0 {
+		t.Errorf("multipart upload not aborted: %v", fake.aborted)
+	}
+	if _, ok := fake.objects["a"]; ok {
+		t.Error("object of a failed upload exists")
+	}
+	requests := fake.received()
+	if abort := requests[len(requests)-1]; abort.method != http.MethodDelete || !reflect.DeepEqual(abort.query, url.Values{"uploadId": {"upload-1"}}) {
+		t.Errorf("aborted with %v", abort)
+	}
+}
+
+func TestAddressing(t *testing.T) {
+	for _, pathStyle := range []bool{true, false} {
+		client, fake, done := newTestClient(t, pathStyle)
+		dir, err := ioutil.TempDir("", "s3")
+		if err != nil {
+			t.Fatal(err)
+		}
+		path, _ := testutil.WriteFile(t, dir, "a", 10)
+		if err := client.UploadFile(path, "fhir/a b+c.ndjson", Properties{}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.List("fhir/"); err != nil {
+			t.Fatal(err)
+		}
+		if err := client.Delete("fhir/a b+c.ndjson"); err != nil {
+			t.Fatal(err)
+		}
+		os.RemoveAll(dir)
+		done()
+
+		host, objectPath, bucketPath := client.Endpoint.Host, "/bucket/fhir/a b+c.ndjson", "/bucket"
+		if !pathStyle {
+			host, objectPath, bucketPath = "bucket."+client.Endpoint.Host, "/fhir/a b+c.ndjson", "/"
+		}
+		var got []string
+		for _, r := range fake.received() {
+			if r.host != host {
+				t.Errorf("path style %t: %s %s sent to host %s, expected %s", pathStyle, r.method, r.path, r.host, host)
+			}
+			got = append(got, r.method+" "+r.path+"?"+r.query.Encode())
+		}
+		expected := []string{
+			"PUT " + objectPath + "?",
+			"GET " + bucketPath + "?list-type=2&prefix=fhir%2F",
+			"DELETE " + objectPath + "?",
+		}
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("path style %t: requests %q, expected %q", pathStyle, got, expected)
+		}
+	}
+}
+
+func TestListAndDelete(t *testing.T) {
+	client, fake, done := newTestClient(t, true)
+	defer done()
+	for _, key := range []string{"csv/a.csv", "fhir/a.ndjson", "fhir/b.ndjson", "fhir/c.ndjson", "fhir/d.ndjson"} {
+		fake.objects[key] = []byte(key)
+	}
+
+	objects, err := client.List("fhir/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 4 || objects["fhir/d.ndjson"] != int64(len("fhir/d.ndjson")) {
+		t.Errorf("unexpected objects %v", objects)
+	}
+	// pages are continued with the token of the previous page
+	var tokens []string
+	for _, r := range fake.received() {
+		tokens = append(tokens, r.query.Get("continuation-token"))
+	}
+	if !reflect.DeepEqual(tokens, []string{"", "fhir/c.ndjson"}) {
+		t.Errorf("listed with continuation tokens %q", tokens)
+	}
+
+	if err := client.Delete("fhir/a.ndjson"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Delete("fhir/a.ndjson"); err != nil {
+		t.Errorf("deleting a missing object: %s", err)
+	}
+}
+
+func TestObjectURL(t *testing.T) {
+	client, err := NewClient("", "eu-west-1", "bucket", false, Credentials{}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := client.ObjectURL("fhir/a b.ndjson").String(); got != "https://bucket.s3.eu-west-1.amazonaws.com/fhir/a%20b.ndjson" {
+		t.Errorf("virtual-hosted URL %s", got)
+	}
+	client.PathStyle = true
+	if got := client.ObjectURL("").String(); got != "https://s3.eu-west-1.amazonaws.com/bucket" {
+		t.Errorf("path-style bucket URL %s", got)
+	}
+}
@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+)
+
+// certificate of a service principal with its private key, signing client assertions in place of a client secret
+type certificate struct {
+	cert *x509.Certificate
+	key  *rsa.PrivateKey
+}
+
+// loadCertificate reads a PEM file holding a certificate and its unencrypted RSA private key. PFX files and encrypted
+// keys are only supported by azcopy login.
+func loadCertificate(path string) (*certificate, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Contains(data, []byte("-----BEGIN")) {
+		return nil, fmt.Errorf("%s is not a PEM file -- convert a PFX certificate with `openssl pkcs12 -in <pfx> -out <pem> -nodes`", path)
+	}
+	c := &certificate{}
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		switch block.Type {
+		case "CERTIFICATE":
+			if c.cert == nil {
+				if c.cert, err = x509.ParseCertificate(block.Bytes); err != nil {
+					return nil, fmt.Errorf("failed parsing certificate of %s: %s", path, err)
+				}
+			}
+		case "RSA PRIVATE KEY":
+			if x509.IsEncryptedPEMBlock(block) {
+				return nil, fmt.Errorf("private key of %s is encrypted -- only azcopy login supports encrypted keys", path)
+			}
+			if c.key, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+				return nil, fmt.Errorf("failed parsing private key of %s: %s", path, err)
+			}
+		case "PRIVATE KEY":
+			key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("failed parsing private key of %s: %s", path, err)
+			}
+			rsaKey, ok := key.(*rsa.PrivateKey)
+			if !ok {
+				return nil, fmt.Errorf("private key of %s must be an RSA key", path)
+			}
+			c.key = rsaKey
+		case "ENCRYPTED PRIVATE KEY":
+			return nil, fmt.Errorf("private key of %s is encrypted -- only azcopy login supports encrypted keys", path)
+		}
+	}
+	if c.cert == nil || c.key == nil {
+		return nil, fmt.Errorf("%s must hold a certificate and its private key", path)
+	}
+	return c, nil
+}
+
+// assertion returns a client assertion of a service principal for a token endpoint: a JWT signed by the certificate,
+// which Azure AD identifies by its thumbprint.
+// https://docs.microsoft.com/azure/active-directory/develop/active-directory-certificate-credentials
+func (c *certificate) assertion(clientID string, endpoint string, now time.Time) (string, error) {
+	thumbprint := sha1.Sum(c.cert.Raw)
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "x5t": base64.RawURLEncoding.EncodeToString(thumbprint[:])})
+	if err != nil {
+		return "", err
+	}
+	var jti [16]byte
+	if _, err := rand.Read(jti[:]); err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"aud": endpoint,
+		"iss": clientID,
+		"sub": clientID,
+		"jti": hex.EncodeToString(jti[:]),
+		"nbf": now.Unix(),
+		"exp": now.Add(10 * time.Minute).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, c.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", errors.New("failed signing client assertion: " + err.Error())
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
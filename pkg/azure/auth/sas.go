@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// SAS is a shared access signature token; eg: sv=2020-08-04&sp=racwdl&sr=c&se=2021-01-01T00:00:00Z&sig=...
+// It formats as REDACTED so it cannot be logged by mistake; use Token to read it.
+type SAS string
+
+// NewSAS returns the SAS of a token, with or without its leading '?'
+func NewSAS(token string) (SAS, error) {
+	token = strings.TrimPrefix(strings.TrimSpace(token), "?")
+	query, err := url.ParseQuery(token)
+	if err != nil || query.Get("sig") == "" {
+		return "", errors.New("SAS token must be a query string with a signature (sig)")
+	}
+	return SAS(token), nil
+}
+
+// ReadSAS returns the SAS of the token in a file
+func ReadSAS(path string) (SAS, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return NewSAS(string(data))
+}
+
+// ParseSASURL splits a SAS URL into the URL of its resource and its SAS; eg: a container SAS URL into the container URL
+func ParseSASURL(sasURL string) (string, SAS, error) {
+	u, err := url.Parse(strings.TrimSpace(sasURL))
+	if err != nil {
+		return "", "", fmt.Errorf("invalid SAS URL: %s", Redact(err.Error()))
+	}
+	sas, err := NewSAS(u.RawQuery)
+	if err != nil {
+		return "", "", err
+	}
+	u.RawQuery = ""
+	return strings.TrimSuffix(u.String(), "/"), sas, nil
+}
+
+// Token returns the token of the SAS
+func (s SAS) Token() string {
+	return string(s)
+}
+
+// String redacts the SAS
+func (s SAS) String() string {
+	if s == "" {
+		return ""
+	}
+	return "REDACTED"
+}
+
+// AddTo returns a URL with the SAS added to its query
+func (s SAS) AddTo(u string) string {
+	if s == "" {
+		return u
+	}
+	if strings.Contains(u, "?") {
+		return u + "&" + string(s)
+	}
+	return u + "?" + string(s)
+}
+
+// signature matches the signature of a SAS, as a URL query parameter or an escaped one
+var signature = regexp.MustCompile(`(?i)(sig(=|%3D))[^&\s"'\\]+`)
+
+// Redact the signature of any SAS in a text; eg: the URL of a failed request
+func Redact(s string) string {
+	return signature.ReplaceAllString(s, "${1}REDACTED")
+}
+
+// LoadSAS returns the SAS of a SAS URL along with the URL of its resource, else the SAS of the token in tokenFile, else
+// the SAS of a token. Returns no SAS if all are empty.
+func LoadSAS(sasURL string, tokenFile string, token string) (string, SAS, error) {
+	switch {
+	case sasURL != "":
+		return ParseSASURL(sasURL)
+	case tokenFile != "":
+		sas, err := ReadSAS(tokenFile)
+		return "", sas, err
+	case token != "":
+		sas, err := NewSAS(token)
+		return "", sas, err
+	}
+	return "", "", nil
+}
@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const (
+	testSignature = "c2VjcmV0LXNpZ25hdHVyZQ%3D%3D"
+	testToken     = "sv=2019-12-12&ss=b&srt=co&sp=rwdlac&se=2020-06-02T00:00:00Z&st=2020-06-01T00:00:00Z&spr=https&sig=" + testSignature
+	testSASURL    = "https://account.blob.core.windows.net/container?" + testToken
+)
+
+// checkRedacted fails the test if s holds any part of the signature of the test SAS
+func checkRedacted(t *testing.T, s string) {
+	t.Helper()
+	for _, part := range []string{testSignature, "c2VjcmV0", "LXNpZ25hdHVyZQ"} {
+		if strings.Contains(s, part) {
+			t.Errorf("signature not redacted: %s", s)
+			return
+		}
+	}
+}
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected string
+	}{
+		{testSASURL, strings.Replace(testSASURL, testSignature, "REDACTED", 1)},
+		{"?" + testToken, "?" + strings.Replace(testToken, testSignature, "REDACTED", 1)},
+		{
+			// the error of a failed request
+			`Put "` + testSASURL + `": dial tcp: i/o timeout`,
+			`Put "` + strings.Replace(testSASURL, testSignature, "REDACTED", 1) + `": dial tcp: i/o timeout`,
+		},
+		{
+			// escaped, as in the URL of a redirect
+			"https://login.example.com/?next=" + url.QueryEscape(testSASURL),
+			"https://login.example.com/?next=" + strings.Replace(url.QueryEscape(testSASURL), "sig%3D"+url.QueryEscape(testSignature), "sig%3DREDACTED", 1),
+		},
+		{
+			// in the JSON of azcopy output
+			`{"Dst":"` + testSASURL + `","ErrorCode":"403"}`,
+			`{"Dst":"` + strings.Replace(testSASURL, testSignature, "REDACTED", 1) + `","ErrorCode":"403"}`,
+		},
+		{"copy a?sig=one b?SIG=two", "copy a?sig=REDACTED b?SIG=REDACTED"},
+		{"https://account.blob.core.windows.net/container/signature.ndjson", "https://account.blob.core.windows.net/container/signature.ndjson"},
+	}
+	for _, test := range tests {
+		redacted := Redact(test.text)
+		if redacted != test.expected {
+			t.Errorf("%s: redacted as %s, expected %s", test.text, redacted, test.expected)
+		}
+		checkRedacted(t, redacted)
+	}
+}
+
+func TestSAS(t *testing.T) {
+	for _, sasURL := range []string{testSASURL, " " + testSASURL + "\n", "https://account.blob.core.windows.net/container/?" + testToken} {
+		u, sas, err := ParseSASURL(sasURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u != "https://account.blob.core.windows.net/container" || sas.Token() != testToken {
+			t.Errorf("%s: parsed %s and %s", sasURL, u, sas.Token())
+		}
+		if sas.AddTo(u) != testSASURL {
+			t.Errorf("%s: added as %s", sasURL, sas.AddTo(u))
+		}
+
+		// every way a SAS may be formatted in a message or a log
+		options := struct {
+			ContainerURL string
+			SAS          SAS
+		}{u, sas}
+		for _, s := range []string{
+			sas.String(),
+			fmt.Sprint(sas),
+			fmt.Sprintf("%s %v %+v %q", sas, sas, sas, sas),
+			fmt.Sprintf("%v %+v", options, options),
+			fmt.Sprintf("%v", []SAS{sas}),
+			fmt.Sprintf("%v", map[string]SAS{u: sas}),
+		} {
+			if !strings.Contains(s, "REDACTED") {
+				t.Errorf("SAS formatted as %s", s)
+			}
+			checkRedacted(t, s)
+		}
+	}
+
+	if _, _, err := ParseSASURL("https://account.blob.core.windows.net/container?sv=2019-12-12&sp=rw"); err == nil {
+		t.Error("expected an error without a signature")
+	}
+	if _, _, err := ParseSASURL("https://account.blob.core.windows.net/%zz?" + testToken); err == nil {
+		t.Error("expected an error parsing an invalid URL")
+	} else {
+		checkRedacted(t, err.Error())
+	}
+	if sas, err := NewSAS("?" + testToken); err != nil || sas.Token() != testToken {
+		t.Errorf("SAS of a token: %v", err)
+	}
+}
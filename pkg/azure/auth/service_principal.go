@@ -1,9 +1,26 @@
 package auth
 
 type ServicePrincipal struct {
-	ApplicationId string
-	Password      string
-	Tenant        string
-	DisplayName   string // no usage as of yet
-	Name          string // no usage as of yet
+	ApplicationId       string
+	Password            string // client secret -- not needed with a certificate
+	CertificatePath     string // PEM or PFX file of a certificate and its private key, authenticating instead of the Password
+	CertificatePassword string // of the certificate file, if encrypted
+	Tenant              string
+	DisplayName         string // no usage as of yet
+	Name                string // no usage as of yet
+}
+
+// Complete reports whether the service principal has an ID, a tenant, and a secret or certificate to authenticate with
+func (sp ServicePrincipal) Complete() bool {
+	return sp.ApplicationId != "" && sp.Tenant != "" && (sp.Password != "" || sp.CertificatePath != "")
+}
+
+// CheckCertificate reports whether the certificate of the service principal, if any, can sign the requests of a Token:
+// only azcopy login reads PFX files and encrypted keys
+func (sp ServicePrincipal) CheckCertificate() error {
+	if sp.CertificatePath == "" {
+		return nil
+	}
+	_, err := loadCertificate(sp.CertificatePath)
+	return err
 }
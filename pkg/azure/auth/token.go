@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Token authorizes requests with an Azure AD access token of a service principal, refreshed before it expires
+type Token struct {
+	ServicePrincipal ServicePrincipal
+	Scope            string // eg: https://storage.azure.com/.default
+	AuthorityHost    string // defaults to https://login.microsoftonline.com
+	HTTPClient       *http.Client
+
+	lock        sync.Mutex
+	token       string
+	expires     time.Time
+	certificate *certificate // of the service principal, loaded on first use
+}
+
+// NewToken returns a Token of a service principal for a scope
+func NewToken(sp ServicePrincipal, scope string) *Token {
+	return &Token{ServicePrincipal: sp, Scope: scope, AuthorityHost: "https://login.microsoftonline.com", HTTPClient: http.DefaultClient}
+}
+
+// Authorize sets the bearer token of the request, requesting a new token if needed
+func (t *Token) Authorize(req *http.Request) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	// refresh a little early so a token never expires mid-request
+	if t.token == "" || time.Now().Add(5*time.Minute).After(t.expires) {
+		if err := t.refresh(); err != nil {
+			return err
+		}
+	}
+	req.Header.Set("Authorization", "Bearer "+t.token)
+	return nil
+}
+
+// refresh requests a new access token with the client credentials flow, authenticating with the client secret or the
+// certificate of the service principal
+func (t *Token) refresh() error {
+	endpoint := strings.TrimSuffix(t.AuthorityHost, "/") + "/" + url.PathEscape(t.ServicePrincipal.Tenant) + "/oauth2/v2.0/token"
+	form := url.Values{
+		"grant_type": {"client_credentials"},
+		"client_id":  {t.ServicePrincipal.ApplicationId},
+		"scope":      {t.Scope},
+	}
+	if t.ServicePrincipal.CertificatePath != "" {
+		if t.certificate == nil {
+			c, err := loadCertificate(t.ServicePrincipal.CertificatePath)
+			if err != nil {
+				return err
+			}
+			t.certificate = c
+		}
+		assertion, err := t.certificate.assertion(t.ServicePrincipal.ApplicationId, endpoint, time.Now())
+		if err != nil {
+			return err
+		}
+		form.Set("client_assertion_type", "urn:ietf:params:oauth:client-assertion-type:jwt-bearer")
+		form.Set("client_assertion", assertion)
+	} else {
+		form.Set("client_secret", t.ServicePrincipal.Password)
+	}
+	resp, err := t.HTTPClient.PostForm(endpoint, form)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed requesting access token for service principal %s: %s: %s", t.ServicePrincipal.ApplicationId, resp.Status, body)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil {
+		return err
+	}
+	t.token = token.AccessToken
+	t.expires = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	return nil
+}
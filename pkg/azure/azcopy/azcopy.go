@@ -3,22 +3,27 @@ package azcopy
 import (
 	"archive/zip"
 	"bytes"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"microsoft.com/divoc/pkg/azure/auth"
 	"microsoft.com/divoc/pkg/logger"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 )
 
 type Context struct {
-	BinPath     string // path to the azcopy binary on host
-	tempInstall bool   // if azcopy was install by this cli -- signals Clean() to remove it
+	BinPath     string           // path to the azcopy binary on host
+	Progress    func(JobSummary) // called with the progress of running jobs -- logged if nil
+	Retry       RetryPolicy      // of failed copy and sync jobs
+	tempInstall bool             // if azcopy was install by this cli -- signals Clean() to remove it
 }
 
 // install azcopy to a temporary directory in os.TempDir
@@ -118,56 +123,237 @@ func readZipFile(zf *zip.File) ([]byte, error) {
 
 // Login to azcopy via the provided service principal
 func (ctx Context) Login(sp auth.ServicePrincipal) error {
-	cmd := exec.Command(ctx.BinPath,
-		"login",
-		"--service-principal",
-		"--application-id", sp.ApplicationId,
-		"--tenant-id", sp.Tenant)
-	cmd.Env = append(cmd.Env, fmt.Sprintf("AZCOPY_SPA_CLIENT_SECRET=%s", sp.Password))
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	home, err := os.UserHomeDir() // must run in user home because azcopy stores its login credentials in ~/.azcopy
-	if err != nil {
-		return err
+	return ctx.LoginWithOptions(LoginOptions{Mode: LoginServicePrincipal, ServicePrincipal: sp})
+}
+
+// Modes of LoginOptions
+const (
+	LoginServicePrincipal = "service-principal" // with the client secret or certificate of a service principal
+	LoginManagedIdentity  = "managed-identity"  // with the managed identity of the Azure VM (or other resource) azcopy runs on
+	LoginAzureCLI         = "azure-cli"         // with the credentials of the `az login` session of the host
+)
+
+// LoginModes are the modes of LoginOptions
+var LoginModes = []string{LoginServicePrincipal, LoginManagedIdentity, LoginAzureCLI}
+
+// LoginOptions select how azcopy logs in
+type LoginOptions struct {
+	Mode             string                // LoginServicePrincipal (default), LoginManagedIdentity or LoginAzureCLI
+	ServicePrincipal auth.ServicePrincipal // with LoginServicePrincipal
+
+	// with LoginManagedIdentity, at most one of the IDs of a user-assigned identity -- the system-assigned identity is
+	// used if none is set
+	IdentityClientID   string
+	IdentityObjectID   string
+	IdentityResourceID string
+
+	Tenant string // optional tenant ID with LoginAzureCLI
+}
+
+// args returns the azcopy login flags and environment of the options
+func (o LoginOptions) args() (args []string, env []string, err error) {
+	switch o.Mode {
+	case "", LoginServicePrincipal:
+		sp := o.ServicePrincipal
+		args = []string{"--service-principal", "--application-id", sp.ApplicationId, "--tenant-id", sp.Tenant}
+		if sp.CertificatePath != "" {
+			// azcopy runs in the home directory, so the path must be absolute
+			certificatePath, err := filepath.Abs(sp.CertificatePath)
+			if err != nil {
+				return nil, nil, err
+			}
+			args = append(args, "--certificate-path", certificatePath)
+			env = []string{fmt.Sprintf("AZCOPY_SPA_CERT_PASSWORD=%s", sp.CertificatePassword)}
+		} else {
+			env = []string{fmt.Sprintf("AZCOPY_SPA_CLIENT_SECRET=%s", sp.Password)}
+		}
+	case LoginManagedIdentity:
+		args = []string{"--identity"}
+		if o.IdentityClientID != "" {
+			args = append(args, "--identity-client-id", o.IdentityClientID)
+		}
+		if o.IdentityObjectID != "" {
+			args = append(args, "--identity-object-id", o.IdentityObjectID)
+		}
+		if o.IdentityResourceID != "" {
+			args = append(args, "--identity-resource-id", o.IdentityResourceID)
+		}
+		if len(args) > 3 {
+			return nil, nil, errors.New("at most one of the client, object and resource ID of a managed identity can be set")
+		}
+	case LoginAzureCLI:
+		args = []string{"--login-type", "AzCLI"} // requires azcopy 10.22 or later
+		if o.Tenant != "" {
+			args = append(args, "--tenant-id", o.Tenant)
+		}
+	default:
+		return nil, nil, fmt.Errorf("azcopy login mode must be one of %s: %s", strings.Join(LoginModes, ", "), o.Mode)
 	}
-	cmd.Dir = home
-	logger.Debug(fmt.Sprintf("Running: %v", cmd))
-	if err := cmd.Run(); err != nil {
+	return args, env, nil
+}
+
+// LoginWithOptions logs in to azcopy like Login, with the credentials selected by the options
+func (ctx Context) LoginWithOptions(o LoginOptions) error {
+	args, env, err := o.args()
+	if err != nil {
 		return err
 	}
+	_, err = ctx.run(env, append([]string{"login"}, args...)...)
+	return err
+}
 
-	return nil
+// CopyOptions limit which files a copy transfers and set the properties of the blobs it uploads
+type CopyOptions struct {
+	IncludePattern  string // only copy files whose name matches; eg: "*.ndjson.gz"
+	ExcludePattern  string // skip files whose name matches; eg: "*.gz"
+	ContentType     string // Content-Type of uploaded blobs -- guessed from the file extension if empty
+	ContentEncoding string // Content-Encoding of uploaded blobs; eg: "gzip"
+}
+
+// args returns the azcopy flags of the options
+func (o CopyOptions) args() (args []string) {
+	if o.IncludePattern != "" {
+		args = append(args, "--include-pattern", o.IncludePattern)
+	}
+	if o.ExcludePattern != "" {
+		args = append(args, "--exclude-pattern", o.ExcludePattern)
+	}
+	if o.ContentType != "" {
+		args = append(args, "--content-type", o.ContentType, "--no-guess-mime-type")
+	}
+	if o.ContentEncoding != "" {
+		args = append(args, "--content-encoding", o.ContentEncoding)
+	}
+	return args
 }
 
 // Copy data from one location to another.
 // Shells out to azcopy under the hood, so it supports any `from` and `to` that binary does.
 // Must run Login() prior to usage unless host has already logged in by other means.
-func (ctx Context) Copy(from string, to string) (err error) {
-	// if `from` does not start with "http:" it is a filesystem path
+// Failed jobs are resumed under the retry policy of the context. Returns the summary of the azcopy job, which is also
+// returned with the error of a job which still fails; its JobID then allows resuming it later.
+func (ctx Context) Copy(from string, to string) (*JobSummary, error) {
+	return ctx.CopyWithOptions(from, to, CopyOptions{})
+}
+
+// CopyWithOptions copies data from one location to another like Copy, limited and configured by the provided options
+func (ctx Context) CopyWithOptions(from string, to string, o CopyOptions) (*JobSummary, error) {
+	// if `from` is not an http(s) URL it is a filesystem path
 	// convert `from` to absolute path if is a filesystem path
-	if !strings.EqualFold(from[0:4], "http:") {
+	if lower := strings.ToLower(from); !strings.HasPrefix(lower, "http://") && !strings.HasPrefix(lower, "https://") {
 		absFrom, err := filepath.Abs(from)
 		if err != nil {
 			logger.Error(err)
-			return fmt.Errorf("failed to calculate absolute path for: %s", from)
+			return nil, fmt.Errorf("failed to calculate absolute path for: %s", from)
 		}
 		from = absFrom
 	}
 
-	cmd := exec.Command(ctx.BinPath, "copy", from, to, "--recursive")
-	cmd.Stdout = os.Stdout
+	summary, err := ctx.run(nil, append([]string{"copy", from, to, "--recursive"}, o.args()...)...)
+	// azcopy does not keep the SAS of a job, so it is passed again to resume it
+	var destinationSAS auth.SAS
+	if u, err := url.Parse(to); err == nil && u.Query().Get("sig") != "" {
+		destinationSAS = auth.SAS(u.RawQuery)
+	}
+	return ctx.retry(summary, err, func(jobID string) (*JobSummary, error) {
+		return ctx.resume(jobID, destinationSAS)
+	})
+}
+
+// Values of SyncOptions.DeleteDestination
+const (
+	DeleteNever  = "false"  // keep blobs without a matching source file
+	DeleteAlways = "true"   // delete blobs without a matching source file
+	DeletePrompt = "prompt" // azcopy asks on the terminal before deleting blobs without a matching source file
+)
+
+// SyncOptions limit which files a sync compares and whether blobs missing from the source are deleted
+type SyncOptions struct {
+	IncludePattern    string // only sync files whose name matches; eg: "*.ndjson"
+	ExcludePattern    string // skip files whose name matches; eg: "*.csv"
+	DeleteDestination string // DeleteNever (default), DeleteAlways or DeletePrompt
+}
+
+// args returns the azcopy flags of the options
+func (o SyncOptions) args() (args []string) {
+	if o.IncludePattern != "" {
+		args = append(args, "--include-pattern", o.IncludePattern)
+	}
+	if o.ExcludePattern != "" {
+		args = append(args, "--exclude-pattern", o.ExcludePattern)
+	}
+	deleteDestination := o.DeleteDestination
+	if deleteDestination == "" {
+		deleteDestination = DeleteNever
+	}
+	return append(args, "--delete-destination", deleteDestination)
+}
+
+// Sync the blobs under `to` with the files of the local directory `from`: only files which are new or modified since
+// the last sync are uploaded, and blobs without a matching file are deleted if the options say so.
+// Must run Login() prior to usage unless host has already logged in by other means.
+// Failed syncs are run again under the retry policy of the context. Returns the summary of the azcopy job like Copy.
+func (ctx Context) Sync(from string, to string, o SyncOptions) (*JobSummary, error) {
+	switch o.DeleteDestination {
+	case "", DeleteNever, DeleteAlways:
+	case DeletePrompt:
+		if !Interactive() {
+			return nil, fmt.Errorf("azcopy sync delete destination %s requires a terminal to answer its prompts on", DeletePrompt)
+		}
+	default:
+		return nil, fmt.Errorf("azcopy sync delete destination must be %s, %s or %s: %s", DeleteNever, DeleteAlways, DeletePrompt, o.DeleteDestination)
+	}
+	absFrom, err := filepath.Abs(from)
+	if err != nil {
+		logger.Error(err)
+		return nil, fmt.Errorf("failed to calculate absolute path for: %s", from)
+	}
+
+	args := append([]string{"sync", absFrom, to, "--recursive"}, o.args()...)
+	summary, err := ctx.run(nil, args...)
+	return ctx.retry(summary, err, func(string) (*JobSummary, error) {
+		return ctx.run(nil, args...)
+	})
+}
+
+// List the blobs under a container or virtual directory URL.
+// Returns the size in bytes of each blob keyed by its path relative to the URL.
+func (ctx Context) List(url string) (map[string]int64, error) {
+	cmd := exec.Command(ctx.BinPath, "list", url, "--machine-readable")
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
 	cmd.Stderr = os.Stderr
 	home, err := os.UserHomeDir() // must run in user home because azcopy stores its login credentials in ~/.azcopy
 	if err != nil {
-		return err
+		return nil, err
 	}
 	cmd.Dir = home
-	logger.Debugf("Running: %v", cmd)
+	logger.Debugf("Running: %s", auth.Redact(cmd.String()))
 	if err := cmd.Run(); err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	// each blob is listed as `INFO: <path>;  Content Length: <bytes>`
+	blobs := map[string]int64{}
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		line = strings.TrimSpace(strings.TrimPrefix(line, "INFO: "))
+		i := strings.LastIndex(line, ";  Content Length: ")
+		if i < 0 {
+			continue
+		}
+		size, err := strconv.ParseInt(strings.TrimSpace(line[i+len(";  Content Length: "):]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing azcopy list output %q: %s", line, err)
+		}
+		blobs[line[:i]] = size
+	}
+	return blobs, nil
+}
+
+// Remove a blob, or every blob under a virtual directory, at url
+func (ctx Context) Remove(url string) error {
+	_, err := ctx.run(nil, "remove", url, "--recursive")
+	return err
 }
 
 // Remove temporary azcopy installation if installed during init()
@@ -0,0 +1,95 @@
+package azcopy
+
+import (
+	"encoding/json"
+	"fmt"
+	"microsoft.com/divoc/pkg/azure/auth"
+	"microsoft.com/divoc/pkg/logger"
+	"time"
+)
+
+// Defaults of the RetryPolicy settings
+const (
+	DefaultMaxResumes  = 3
+	DefaultResumeDelay = 30 * time.Second
+)
+
+// RetryPolicy of the failed jobs of a Context. Failed copy jobs are resumed with `azcopy jobs resume`, which only
+// transfers the files the job has not transferred yet; failed sync jobs are run again, which does the same.
+type RetryPolicy struct {
+	MaxResumes int           // resumes of a failed job before giving up; 0 never resumes
+	Delay      time.Duration // before the first resume, doubled before each of the next ones
+}
+
+// retry a failed job under the retry policy of the context with rerun until it succeeds. Returns the summary and error
+// of its last run. Commands failing before they start a job (eg: without a login) are not retried.
+func (ctx Context) retry(summary *JobSummary, err error, rerun func(jobID string) (*JobSummary, error)) (*JobSummary, error) {
+	delay := ctx.Retry.Delay
+	for resumes := 0; err != nil && resumes < ctx.Retry.MaxResumes; resumes++ {
+		if summary == nil || summary.JobID == "" {
+			return summary, err
+		}
+		logger.Warnf("azcopy job %s failed, resuming in %s (%d of %d): %s", summary.JobID, delay, resumes+1, ctx.Retry.MaxResumes, err)
+		time.Sleep(delay)
+		delay *= 2
+		var rerunSummary *JobSummary
+		rerunSummary, err = rerun(summary.JobID)
+		if rerunSummary != nil {
+			summary = rerunSummary
+		}
+	}
+	return summary, err
+}
+
+// Resume a failed or interrupted job, transferring the files it has not transferred yet, under the retry policy of
+// the context. azcopy does not keep SAS tokens, so jobs uploading to a SAS URL need its SAS again; "" otherwise.
+// Returns the summary of the whole job.
+func (ctx Context) Resume(jobID string, destinationSAS auth.SAS) (*JobSummary, error) {
+	resume := func(jobID string) (*JobSummary, error) {
+		return ctx.resume(jobID, destinationSAS)
+	}
+	summary, err := resume(jobID)
+	if summary == nil {
+		summary = &JobSummary{JobID: jobID}
+	}
+	return ctx.retry(summary, err, resume)
+}
+
+// resume a job once
+func (ctx Context) resume(jobID string, destinationSAS auth.SAS) (*JobSummary, error) {
+	args := []string{"jobs", "resume", jobID}
+	if destinationSAS != "" {
+		args = append(args, "--destination-sas", destinationSAS.Token())
+	}
+	return ctx.run(nil, args...)
+}
+
+// Job of the azcopy job history of the host
+type Job struct {
+	JobID   string
+	Command string // eg: copy /tmp/synthea/output/* https://<account>.blob.core.windows.net/<container> --recursive
+	Status  string // one of the Status constants
+}
+
+// Jobs lists the jobs azcopy ran on the host, which it keeps in ~/.azcopy
+func (ctx Context) Jobs() ([]Job, error) {
+	out, err := ctx.exec(nil, "jobs", "list")
+	if err != nil {
+		return nil, err
+	}
+	var list struct {
+		JobIDDetails []struct {
+			JobId         string
+			CommandString string
+			JobStatus     json.RawMessage
+		}
+	}
+	if err := json.Unmarshal([]byte(out.end), &list); err != nil {
+		return nil, fmt.Errorf("failed parsing azcopy jobs list output %q: %s", out.end, err)
+	}
+	var jobs []Job
+	for _, j := range list.JobIDDetails {
+		jobs = append(jobs, Job{JobID: j.JobId, Command: auth.Redact(j.CommandString), Status: text(j.JobStatus)})
+	}
+	return jobs, nil
+}
@@ -0,0 +1,140 @@
+package azcopy
+
+import (
+	"io/ioutil"
+	"microsoft.com/divoc/pkg/azure/auth"
+	"microsoft.com/divoc/pkg/logger"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+const (
+	testSignature = "c2VjcmV0LXNpZ25hdHVyZQ%3D%3D"
+	testToken     = "se=2020-06-02T00%3A00%3A00Z&sig=" + testSignature + "&sp=rwdl&sr=c&sv=2019-12-12"
+)
+
+// captureDebug returns what f logs at level Debug or above, which the logger writes to stdout
+func captureDebug(t *testing.T, f func()) string {
+	out, err := ioutil.TempFile("", "azcopy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+	stdout := os.Stdout
+	os.Stdout = out
+	logger.SetLevelDebug()
+	defer func() {
+		os.Stdout = stdout
+		logger.SetLevelInfo()
+	}()
+	f()
+	data, err := ioutil.ReadFile(out.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// checkRedacted fails the test if s holds the signature of the test SAS
+func checkRedacted(t *testing.T, s string) {
+	t.Helper()
+	if strings.Contains(s, "c2VjcmV0") {
+		t.Errorf("signature not redacted: %s", s)
+	}
+}
+
+func TestJobs(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake azcopy is a shell script")
+	}
+	dir, err := ioutil.TempDir("", "azcopy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var jobs []Job
+	logs := captureDebug(t, func() {
+		jobs, err = fakeAzCopy(t, dir, "jobs_list.jsonl", 0).Jobs()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Job{
+		{"4a6b1e6c-7f1d-4b4c-6c1e-2b3a1d0e9f8a", "copy /tmp/synthea/output/fhir/* https://account.blob.core.windows.net/container?se=2020-06-02T00%3A00%3A00Z&sig=REDACTED&sp=rwdl&sr=c&sv=2019-12-12 --recursive --output-type json", StatusCompleted},
+		{"9c2e4f10-3b5a-2d4e-7a6f-1c0d2e3f4a5b", "sync /tmp/synthea/output/fhir https://account.blob.core.windows.net/container?se=2020-06-02T00%3A00%3A00Z&sig=REDACTED&sp=rwdl&sr=c&sv=2019-12-12 --recursive --delete-destination false --output-type json", StatusCompletedWithErrors},
+	}
+	if len(jobs) != len(expected) {
+		t.Fatalf("listed %+v", jobs)
+	}
+	for i, job := range jobs {
+		if job != expected[i] {
+			t.Errorf("listed %+v, expected %+v", job, expected[i])
+		}
+		checkRedacted(t, job.Command)
+	}
+	checkRedacted(t, logs)
+}
+
+func TestRunningRedactsSAS(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake azcopy is a shell script")
+	}
+	dir, err := ioutil.TempDir("", "azcopy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sas, err := auth.NewSAS(testToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		run  func(ctx Context) (*JobSummary, error)
+		arg  string // passed to azcopy with the SAS
+	}{
+		{"copy", func(ctx Context) (*JobSummary, error) {
+			return ctx.Copy(dir, sas.AddTo("https://account.blob.core.windows.net/container"))
+		}, sas.AddTo("https://account.blob.core.windows.net/container")},
+		{"sync", func(ctx Context) (*JobSummary, error) {
+			return ctx.Sync(dir, sas.AddTo("https://account.blob.core.windows.net/container"), SyncOptions{})
+		}, sas.AddTo("https://account.blob.core.windows.net/container")},
+		{"resume", func(ctx Context) (*JobSummary, error) {
+			return ctx.Resume("4a6b1e6c-7f1d-4b4c-6c1e-2b3a1d0e9f8a", sas)
+		}, testToken},
+	}
+	for _, test := range tests {
+		ctx := fakeAzCopy(t, dir, "copy_failed.jsonl", 1)
+		var summary *JobSummary
+		logs := captureDebug(t, func() {
+			summary, err = test.run(ctx)
+		})
+		if !strings.Contains(logs, "Running: "+ctx.BinPath+" "+test.name) && !strings.Contains(logs, "Running: "+ctx.BinPath+" jobs "+test.name) {
+			t.Errorf("%s: no Running line in %s", test.name, logs)
+		}
+		checkRedacted(t, logs)
+		if err == nil {
+			t.Fatalf("%s: expected an error", test.name)
+		}
+		checkRedacted(t, err.Error())
+		checkRedacted(t, summary.String())
+		for _, transfer := range summary.FailedTransfers {
+			checkRedacted(t, transfer.Dst)
+		}
+
+		// azcopy itself gets the SAS
+		args, err := ioutil.ReadFile(filepath.Join(dir, "args"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(args), test.arg+"\n") {
+			t.Errorf("%s: ran azcopy with %s", test.name, args)
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package azcopy
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"microsoft.com/divoc/pkg/azure/auth"
+	"microsoft.com/divoc/pkg/logger"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Types of the messages azcopy writes with `--output-type json`
+const (
+	MessageInit     = "Init"     // the job started; content is a JSON object with its JobID and LogFileLocation
+	MessageInfo     = "Info"     // content is text
+	MessageError    = "Error"    // content is text
+	MessagePrompt   = "Prompt"   // content is a question answered on stdin; its answers are in PromptDetails
+	MessageProgress = "Progress" // content is a JSON JobSummary
+	MessageEndOfJob = "EndOfJob" // content is a JSON JobSummary, or text for commands without a job; eg: login
+)
+
+// message is a line of azcopy JSON output
+type message struct {
+	TimeStamp      time.Time
+	MessageType    string
+	MessageContent string
+	PromptDetails  promptDetails // set on Prompt messages
+}
+
+// promptDetails are the valid answers of a Prompt message
+type promptDetails struct {
+	PromptType      string // eg: DeleteDestination
+	PromptTarget    string // eg: the blob to delete
+	ResponseOptions []struct {
+		ResponseType             string // eg: YesForAll
+		UserFriendlyResponseType string // eg: Yes for all
+		ResponseString           string // the answer to type; eg: a
+	}
+}
+
+// Interactive reports whether stdin is a terminal, which the prompts of azcopy can be answered on. /dev/null (eg: the
+// stdin of a container run without -t) is a character device too, but never answers.
+func Interactive() bool {
+	info, err := os.Stdin.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	null, err := os.Stat(os.DevNull)
+	return err != nil || !os.SameFile(info, null)
+}
+
+// question formats a Prompt message as its question followed by the answers to type; eg:
+// `Delete fhir/a.ndjson? [y] Yes, [n] No, [a] Yes for all, [l] No for all: `
+func (m message) question() string {
+	question := strings.TrimSpace(m.MessageContent)
+	if question == "" {
+		question = strings.TrimSpace(m.PromptDetails.PromptType+" "+m.PromptDetails.PromptTarget) + "?"
+	}
+	var answers []string
+	for _, option := range m.PromptDetails.ResponseOptions {
+		name := option.UserFriendlyResponseType
+		if name == "" {
+			name = option.ResponseType
+		}
+		answers = append(answers, fmt.Sprintf("[%s] %s", option.ResponseString, name))
+	}
+	if len(answers) == 0 {
+		return question + " "
+	}
+	return question + " " + strings.Join(answers, ", ") + ": "
+}
+
+// Transfer of a file by an azcopy job
+type Transfer struct {
+	Src       string
+	Dst       string
+	Status    string // eg: Failed, SkippedEntityAlreadyExists
+	ErrorCode int    // HTTP status of a failed transfer
+}
+
+// Statuses of azcopy jobs
+const (
+	StatusInProgress                    = "InProgress"
+	StatusPaused                        = "Paused"
+	StatusCancelled                     = "Cancelled"
+	StatusCompleted                     = "Completed"
+	StatusCompletedWithSkipped          = "CompletedWithSkipped"
+	StatusCompletedWithErrors           = "CompletedWithErrors"
+	StatusCompletedWithErrorsAndSkipped = "CompletedWithErrorsAndSkipped"
+	StatusFailed                        = "Failed"
+)
+
+// Finished reports whether a job of a status transferred every file it had to; skipped files already existed
+func Finished(status string) bool {
+	return status == StatusCompleted || status == StatusCompletedWithSkipped
+}
+
+// JobSummary is the state of an azcopy job, reported while the job runs and once it ends
+type JobSummary struct {
+	JobID              string
+	Status             string // one of the Status constants
+	TotalTransfers     int64
+	TransfersCompleted int64
+	TransfersFailed    int64
+	TransfersSkipped   int64
+	BytesTransferred   int64
+	BytesExpected      int64
+	PercentComplete    float64
+	FailedTransfers    []Transfer
+	SkippedTransfers   []Transfer
+}
+
+// String summarizes the files and bytes transferred
+func (s *JobSummary) String() string {
+	return fmt.Sprintf("azcopy job %s %s: %d of %d files transferred, %d failed, %d skipped, %d of %d bytes",
+		s.JobID, s.Status, s.TransfersCompleted, s.TotalTransfers, s.TransfersFailed, s.TransfersSkipped, s.BytesTransferred, s.BytesExpected)
+}
+
+// jsonSummary is the JSON of a JobSummary. Depending on the azcopy version, numbers are encoded as numbers or strings,
+// which json.Number accepts both of.
+type jsonSummary struct {
+	JobID                 string
+	JobStatus             json.RawMessage
+	TotalTransfers        json.Number
+	TransfersCompleted    json.Number
+	TransfersFailed       json.Number
+	TransfersSkipped      json.Number
+	TotalBytesTransferred json.Number
+	TotalBytesExpected    json.Number
+	PercentComplete       json.Number
+	FailedTransfers       []jsonTransfer
+	SkippedTransfers      []jsonTransfer
+}
+
+type jsonTransfer struct {
+	Src            string
+	Dst            string
+	TransferStatus json.RawMessage
+	ErrorCode      json.Number
+}
+
+// parseSummary parses the JobSummary of a Progress or EndOfJob message. Progress messages have no JobID, which is
+// jobID then; the one of the Init message of the job.
+func parseSummary(content string, jobID string) (*JobSummary, error) {
+	var j jsonSummary
+	if err := json.Unmarshal([]byte(content), &j); err != nil {
+		return nil, err
+	}
+	if j.JobID != "" {
+		jobID = j.JobID
+	}
+	if jobID == "" {
+		return nil, fmt.Errorf("azcopy job summary without a JobID: %s", content) // eg: the output of jobs list
+	}
+	s := &JobSummary{
+		JobID:              jobID,
+		Status:             text(j.JobStatus),
+		TotalTransfers:     integer(j.TotalTransfers),
+		TransfersCompleted: integer(j.TransfersCompleted),
+		TransfersFailed:    integer(j.TransfersFailed),
+		TransfersSkipped:   integer(j.TransfersSkipped),
+		BytesTransferred:   integer(j.TotalBytesTransferred),
+		BytesExpected:      integer(j.TotalBytesExpected),
+	}
+	s.PercentComplete, _ = j.PercentComplete.Float64()
+	for _, t := range j.FailedTransfers {
+		s.FailedTransfers = append(s.FailedTransfers, t.transfer())
+	}
+	for _, t := range j.SkippedTransfers {
+		s.SkippedTransfers = append(s.SkippedTransfers, t.transfer())
+	}
+	return s, nil
+}
+
+func (t jsonTransfer) transfer() Transfer {
+	return Transfer{Src: t.Src, Dst: t.Dst, Status: text(t.TransferStatus), ErrorCode: int(integer(t.ErrorCode))}
+}
+
+// integer returns the value of a number, or 0 if it is missing
+func integer(n json.Number) int64 {
+	i, _ := n.Int64()
+	return i
+}
+
+// text returns a JSON string, or the JSON of any other value; eg: a status encoded as a number
+func text(raw json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	return string(raw)
+}
+
+// logProgress is the default Context.Progress
+func logProgress(s JobSummary) {
+	logger.Infof("%.1f%%, %d done, %d failed, %d skipped, %d total", s.PercentComplete, s.TransfersCompleted, s.TransfersFailed, s.TransfersSkipped, s.TotalTransfers)
+}
+
+// output of an azcopy command
+type output struct {
+	summary *JobSummary // the latest state of the job of the command, if it runs one
+	end     string      // content of the EndOfJob message
+}
+
+// jobID returns the JobID of the job of the command, or "" if it has not started one
+func (out output) jobID() string {
+	if out.summary == nil {
+		return ""
+	}
+	return out.summary.JobID
+}
+
+// run an azcopy command with JSON output like exec, returning the summary of its job
+func (ctx Context) run(env []string, args ...string) (*JobSummary, error) {
+	out, err := ctx.exec(env, args...)
+	return out.summary, err
+}
+
+// exec runs an azcopy command with JSON output and env added to the environment, logging its messages and reporting
+// the progress of its job to ctx.Progress. Returns its output along with any error; the summary of a job which fails
+// holds at least its JobID, so it can be resumed. Prompts are written to stderr and answered on stdin.
+func (ctx Context) exec(env []string, args ...string) (out output, err error) {
+	cmd := exec.Command(ctx.BinPath, append(args, "--output-type", "json")...)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	cmd.Stderr = os.Stderr
+	cmd.Stdin = os.Stdin
+	home, err := os.UserHomeDir() // must run in user home because azcopy stores its login credentials in ~/.azcopy
+	if err != nil {
+		return out, err
+	}
+	cmd.Dir = home
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return out, err
+	}
+	logger.Debugf("Running: %s", auth.Redact(cmd.String()))
+	if err := cmd.Start(); err != nil {
+		return out, err
+	}
+	out, failures, readErr := ctx.readOutput(stdout)
+	command := args[0]
+	if command == "jobs" && len(args) > 1 {
+		command += " " + args[1] // eg: jobs resume
+	}
+	if err := cmd.Wait(); err != nil {
+		if len(failures) > 0 {
+			return out, fmt.Errorf("azcopy %s failed: %s: %s", command, err, strings.Join(failures, "; "))
+		}
+		return out, fmt.Errorf("azcopy %s failed: %s", command, err)
+	}
+	if readErr != nil {
+		return out, fmt.Errorf("failed reading azcopy output: %s", readErr)
+	}
+	return out, nil
+}
+
+// readOutput handles the messages of azcopy JSON output until it ends. Returns the output and the text of its Error
+// messages.
+func (ctx Context) readOutput(r io.Reader) (out output, failures []string, err error) {
+	progress := ctx.Progress
+	if progress == nil {
+		progress = logProgress
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64<<20) // the summary of a job lists every failed transfer
+	for scanner.Scan() {
+		line := auth.Redact(strings.TrimSpace(scanner.Text())) // azcopy may echo the SAS of a URL
+		if line == "" {
+			continue
+		}
+		var m message
+		if err := json.Unmarshal([]byte(line), &m); err != nil || m.MessageType == "" {
+			logger.Info(line) // eg: a panic of azcopy
+			continue
+		}
+		switch m.MessageType {
+		case MessageInit:
+			logger.Debugf("azcopy job started: %s", m.MessageContent)
+			var init struct{ JobID string }
+			if json.Unmarshal([]byte(m.MessageContent), &init) == nil && init.JobID != "" && out.summary == nil {
+				out.summary = &JobSummary{JobID: init.JobID, Status: StatusInProgress}
+			}
+		case MessageError:
+			logger.Error(m.MessageContent)
+			failures = append(failures, m.MessageContent)
+		case MessagePrompt:
+			// azcopy reads the answer from stdin, which it shares with this process
+			fmt.Fprint(os.Stderr, m.question())
+		case MessageProgress:
+			if s, err := parseSummary(m.MessageContent, out.jobID()); err == nil {
+				out.summary = s
+				progress(*s)
+			} else {
+				logger.Debugf("Failed parsing azcopy progress: %s", err)
+			}
+		case MessageEndOfJob:
+			out.end = m.MessageContent
+			if s, err := parseSummary(m.MessageContent, out.jobID()); err == nil {
+				out.summary = s
+			} else if !strings.HasPrefix(m.MessageContent, "{") {
+				logger.Info(m.MessageContent)
+			}
+		default:
+			logger.Info(m.MessageContent)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		io.Copy(ioutil.Discard, r) // keep draining the output so azcopy does not block writing it
+		return out, failures, err
+	}
+	return out, failures, nil
+}
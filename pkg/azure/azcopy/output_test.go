@@ -0,0 +1,188 @@
+package azcopy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestPromptQuestion(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected string
+	}{
+		{
+			`{"TimeStamp":"2020-06-01T10:00:00.0000000Z","MessageType":"Prompt","MessageContent":"The blob fhir/old.ndjson does not exist at the source. Do you want to delete it?","PromptDetails":{"PromptType":"DeleteDestination","ResponseOptions":[{"ResponseType":"Yes","UserFriendlyResponseType":"Yes","ResponseString":"y"},{"ResponseType":"No","UserFriendlyResponseType":"No","ResponseString":"n"},{"ResponseType":"YesForAll","UserFriendlyResponseType":"Yes for all","ResponseString":"a"},{"ResponseType":"NoForAll","UserFriendlyResponseType":"No for all","ResponseString":"l"}],"PromptTarget":"fhir/old.ndjson"}}`,
+			"The blob fhir/old.ndjson does not exist at the source. Do you want to delete it? [y] Yes, [n] No, [a] Yes for all, [l] No for all: ",
+		},
+		{
+			`{"MessageType":"Prompt","MessageContent":"","PromptDetails":{"PromptType":"Cancel","ResponseOptions":[{"ResponseType":"Yes","ResponseString":"y"},{"ResponseType":"No","ResponseString":"n"}]}}`,
+			"Cancel? [y] Yes, [n] No: ",
+		},
+		{
+			`{"MessageType":"Prompt","MessageContent":"Continue?\n"}`,
+			"Continue? ",
+		},
+	}
+	for _, test := range tests {
+		var m message
+		if err := json.Unmarshal([]byte(test.line), &m); err != nil {
+			t.Fatal(err)
+		}
+		if got := m.question(); got != test.expected {
+			t.Errorf("asked %q, expected %q", got, test.expected)
+		}
+	}
+}
+
+// readFixture reads a recorded azcopy output of testdata, returning its output, the text of its Error messages and the
+// progress reported
+func readFixture(t *testing.T, name string) (output, []string, []JobSummary) {
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var progress []JobSummary
+	ctx := Context{Progress: func(s JobSummary) { progress = append(progress, s) }}
+	out, failures, err := ctx.readOutput(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out, failures, progress
+}
+
+func TestReadOutput(t *testing.T) {
+	out, failures, progress := readFixture(t, "copy.jsonl")
+	expected := &JobSummary{
+		JobID:              "4a6b1e6c-7f1d-4b4c-6c1e-2b3a1d0e9f8a",
+		Status:             StatusCompleted,
+		TotalTransfers:     3,
+		TransfersCompleted: 3,
+		BytesTransferred:   6144,
+		BytesExpected:      6144,
+		PercentComplete:    100,
+	}
+	if !reflect.DeepEqual(out.summary, expected) || len(failures) != 0 {
+		t.Errorf("read %+v %v", out.summary, failures)
+	}
+	if len(progress) != 2 || progress[0].Status != StatusInProgress || progress[0].TransfersCompleted != 1 ||
+		progress[0].PercentComplete != 33.333332 || progress[1].TransfersCompleted != 3 {
+		t.Errorf("reported progress %+v", progress)
+	}
+	if !Finished(out.summary.Status) {
+		t.Errorf("%s is not finished", out.summary.Status)
+	}
+
+	out, failures, _ = readFixture(t, "copy_failed.jsonl")
+	if out.summary.JobID != "9c2e4f10-3b5a-2d4e-7a6f-1c0d2e3f4a5b" || out.summary.Status != StatusCompletedWithErrors ||
+		out.summary.TransfersFailed != 2 || Finished(out.summary.Status) {
+		t.Errorf("read %+v", out.summary)
+	}
+	expectedFailed := []Transfer{
+		{"/tmp/synthea/output/fhir/Observation.ndjson", "https://account.blob.core.windows.net/container/fhir/Observation.ndjson?se=2020-06-02T00%3A00%3A00Z&sig=REDACTED&sp=rw&sr=c&sv=2019-12-12", "Failed", 403},
+		{"/tmp/synthea/output/fhir/Patient.ndjson", "https://account.blob.core.windows.net/container/fhir/Patient.ndjson", "Failed", 503},
+	}
+	if !reflect.DeepEqual(out.summary.FailedTransfers, expectedFailed) {
+		t.Errorf("read failed transfers %+v", out.summary.FailedTransfers)
+	}
+	if len(failures) != 1 || !strings.HasPrefix(failures[0], "failed to perform copy command") {
+		t.Errorf("read errors %q", failures)
+	}
+
+	// a job which ends without a summary keeps the JobID of its Init message, so it can be resumed
+	out, failures, _ = readFixture(t, "copy_interrupted.jsonl")
+	if !reflect.DeepEqual(out.summary, &JobSummary{JobID: "0f1e2d3c-4b5a-6978-8a9b-0c1d2e3f4a5b", Status: StatusInProgress}) {
+		t.Errorf("read %+v", out.summary)
+	}
+	if !reflect.DeepEqual(failures, []string{"context deadline exceeded"}) {
+		t.Errorf("read errors %q", failures)
+	}
+
+	out, failures, _ = readFixture(t, "login.jsonl")
+	if out.summary != nil || out.end != "Login succeeded." || len(failures) != 0 {
+		t.Errorf("read %+v %q", out, failures)
+	}
+}
+
+func TestParseSummary(t *testing.T) {
+	// older azcopy versions encode numbers as numbers, and statuses as their value
+	s, err := parseSummary(`{"JobID":"j1","JobStatus":2,"TotalTransfers":5,"TransfersCompleted":4,"TransfersFailed":0,"TransfersSkipped":1,"TotalBytesTransferred":400,"TotalBytesExpected":500,"PercentComplete":100,"SkippedTransfers":[{"Src":"a","Dst":"b","TransferStatus":2,"ErrorCode":0}]}`, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &JobSummary{
+		JobID:              "j1",
+		Status:             "2",
+		TotalTransfers:     5,
+		TransfersCompleted: 4,
+		TransfersSkipped:   1,
+		BytesTransferred:   400,
+		BytesExpected:      500,
+		PercentComplete:    100,
+		SkippedTransfers:   []Transfer{{Src: "a", Dst: "b", Status: "2"}},
+	}
+	if !reflect.DeepEqual(s, expected) {
+		t.Errorf("parsed %+v", s)
+	}
+	// progress has no JobID but the one of the job
+	s, err = parseSummary(`{"JobStatus":"InProgress","TransfersCompleted":"1"}`, "j2")
+	if err != nil || s.JobID != "j2" || s.TransfersCompleted != 1 {
+		t.Errorf("parsed %+v: %v", s, err)
+	}
+	for _, content := range []string{`Login succeeded.`, `{"JobID":"j1","TotalTransfers":"many"}`, `{"JobIDDetails":[]}`} {
+		if _, err := parseSummary(content, ""); err == nil {
+			t.Errorf("%s: expected an error", content)
+		}
+	}
+}
+
+// fakeAzCopy writes a script replaying a recorded output of testdata and exiting with status, and returns a Context
+// running it. The script writes its arguments to the args file of dir, one per line.
+func fakeAzCopy(t *testing.T, dir string, fixture string, status int) Context {
+	path, err := filepath.Abs(filepath.Join("testdata", fixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	script := filepath.Join(dir, "azcopy")
+	if err := ioutil.WriteFile(script, []byte(fmt.Sprintf("#!/bin/sh\nprintf '%%s\\n' \"$@\" > '%s'\ncat '%s'\nexit %d\n", filepath.Join(dir, "args"), path, status)), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return Context{BinPath: script, Progress: func(JobSummary) {}}
+}
+
+func TestCopy(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake azcopy is a shell script")
+	}
+	dir, err := ioutil.TempDir("", "azcopy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	summary, err := fakeAzCopy(t, dir, "copy.jsonl", 0).Copy(dir, "https://account.blob.core.windows.net/container")
+	if err != nil || summary == nil || summary.JobID != "4a6b1e6c-7f1d-4b4c-6c1e-2b3a1d0e9f8a" || summary.Status != StatusCompleted {
+		t.Errorf("copied %+v: %v", summary, err)
+	}
+
+	// a failed job is returned with its error, so it can be resumed later
+	summary, err = fakeAzCopy(t, dir, "copy_failed.jsonl", 1).Copy(dir, "https://account.blob.core.windows.net/container")
+	if err == nil || !strings.Contains(err.Error(), "azcopy copy failed: exit status 1: failed to perform copy command") {
+		t.Errorf("expected the Error message in the error, got %v", err)
+	}
+	if summary == nil || summary.JobID != "9c2e4f10-3b5a-2d4e-7a6f-1c0d2e3f4a5b" || summary.TransfersFailed != 2 {
+		t.Errorf("copied %+v", summary)
+	}
+
+	summary, err = fakeAzCopy(t, dir, "copy_interrupted.jsonl", 2).Sync(dir, "https://account.blob.core.windows.net/container", SyncOptions{})
+	if err == nil || summary == nil || summary.JobID != "0f1e2d3c-4b5a-6978-8a9b-0c1d2e3f4a5b" {
+		t.Errorf("synced %+v: %v", summary, err)
+	}
+}
@@ -0,0 +1,228 @@
+package blob
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"microsoft.com/divoc/pkg/rest"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Version of the Blob service REST API used by the client
+const Version = "2019-12-12"
+
+// Defaults of the Client settings
+const (
+	DefaultBlockSize   = 8 << 20 // 8 MiB
+	DefaultConcurrency = rest.DefaultConcurrency
+	DefaultMaxRetries  = rest.DefaultMaxRetries
+)
+
+// Properties set on uploaded blobs
+type Properties struct {
+	ContentType     string
+	ContentEncoding string
+}
+
+// Client uploads to, lists and deletes blobs of a single container through the Blob service REST API
+type Client struct {
+	ContainerURL *url.URL // eg: https://<account>.blob.core.windows.net/<container> or http://127.0.0.1:10000/devstoreaccount1/<container>
+	Credential   Credential
+	BlockSize    int64        // files larger than this are uploaded in blocks of this size
+	REST         *rest.Client // sends the requests of the client
+}
+
+// NewClient returns a Client for a container URL with the default settings. concurrency bounds the number of requests
+// in flight, and the blocks held in memory, across all uploads of the client; <= 0 uses DefaultConcurrency.
+func NewClient(containerURL string, credential Credential, concurrency int) (*Client, error) {
+	u, err := url.Parse(strings.TrimSuffix(containerURL, "/"))
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{
+		ContainerURL: u,
+		Credential:   credential,
+		BlockSize:    DefaultBlockSize,
+	}
+	c.REST = rest.NewClient(concurrency, c.prepare)
+	return c, nil
+}
+
+// BlobURL returns the URL of a blob from its slash separated name
+func (c *Client) BlobURL(name string) *url.URL {
+	u := *c.ContainerURL
+	name = strings.TrimPrefix(name, "/")
+	segments := strings.Split(name, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	u.Path = c.ContainerURL.Path + "/" + name
+	u.RawPath = c.ContainerURL.EscapedPath() + "/" + strings.Join(segments, "/")
+	return &u
+}
+
+// UploadFile uploads a local file to the named blob, replacing it if it exists. Files up to BlockSize are uploaded
+// with a single request; larger files are uploaded as blocks in parallel, then committed. Every request carries the
+// MD5 hash of its content, and the blob is given the MD5 hash of the whole file.
+func (c *Client) UploadFile(path string, name string, props Properties) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if info.Size() <= c.BlockSize {
+		return c.REST.ReadAll(f, func(data []byte) error {
+			sum := md5.Sum(data)
+			header := props.header()
+			header.Set("x-ms-blob-type", "BlockBlob")
+			header.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+			return c.do(http.MethodPut, c.BlobURL(name), nil, header, data)
+		})
+	}
+
+	// upload blocks in parallel while hashing the whole file in the order it is read
+	fileHash := md5.New()
+	blocks, err := c.REST.UploadChunks(io.TeeReader(f, fileHash), c.BlockSize, func(i int, block []byte) error {
+		return c.putBlock(name, blockID(i), block)
+	})
+	if err != nil {
+		return err
+	}
+	ids := make([]string, blocks)
+	for i := range ids {
+		ids[i] = blockID(i)
+	}
+	return c.putBlockList(name, ids, fileHash.Sum(nil), props)
+}
+
+// blockID returns the id of the i-th block of a blob. Every id of a blob must have the same length.
+func blockID(i int) string {
+	return base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("block-%08d", i)))
+}
+
+// putBlock uploads a block of a blob
+func (c *Client) putBlock(name string, id string, block []byte) error {
+	sum := md5.Sum(block)
+	header := http.Header{}
+	header.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+	query := url.Values{"comp": {"block"}, "blockid": {id}}
+	return c.do(http.MethodPut, c.BlobURL(name), query, header, block)
+}
+
+// putBlockList commits the uploaded blocks of a blob in order
+func (c *Client) putBlockList(name string, ids []string, fileMD5 []byte, props Properties) error {
+	var body bytes.Buffer
+	body.WriteString(xml.Header + "<BlockList>")
+	for _, id := range ids {
+		body.WriteString("<Latest>" + id + "</Latest>")
+	}
+	body.WriteString("</BlockList>")
+	header := http.Header{}
+	header.Set("x-ms-blob-content-md5", base64.StdEncoding.EncodeToString(fileMD5))
+	if props.ContentType != "" {
+		header.Set("x-ms-blob-content-type", props.ContentType)
+	}
+	if props.ContentEncoding != "" {
+		header.Set("x-ms-blob-content-encoding", props.ContentEncoding)
+	}
+	return c.do(http.MethodPut, c.BlobURL(name), url.Values{"comp": {"blocklist"}}, header, body.Bytes())
+}
+
+// List returns the size of every blob in the container whose name starts with prefix, keyed by name
+func (c *Client) List(prefix string) (map[string]int64, error) {
+	blobs := map[string]int64{}
+	marker := ""
+	for {
+		query := url.Values{"restype": {"container"}, "comp": {"list"}}
+		if prefix != "" {
+			query.Set("prefix", prefix)
+		}
+		if marker != "" {
+			query.Set("marker", marker)
+		}
+		var page struct {
+			Blobs []struct {
+				Name       string `xml:"Name"`
+				Properties struct {
+					ContentLength int64 `xml:"Content-Length"`
+				} `xml:"Properties"`
+			} `xml:"Blobs>Blob"`
+			NextMarker string `xml:"NextMarker"`
+		}
+		resp, err := c.request(http.MethodGet, c.ContainerURL, query, http.Header{}, nil)
+		if err != nil {
+			return nil, err
+		}
+		if err := xml.Unmarshal(resp.Body, &page); err != nil {
+			return nil, fmt.Errorf("failed parsing blob list: %s", err)
+		}
+		for _, blob := range page.Blobs {
+			blobs[blob.Name] = blob.Properties.ContentLength
+		}
+		if page.NextMarker == "" {
+			return blobs, nil
+		}
+		marker = page.NextMarker
+	}
+}
+
+// Delete the named blob. Deleting a blob which does not exist is not an error.
+func (c *Client) Delete(name string) error {
+	err := c.do(http.MethodDelete, c.BlobURL(name), nil, http.Header{}, nil)
+	if statusErr, ok := err.(*StatusError); ok && statusErr.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
+
+// header returns the request headers setting the properties on a blob uploaded with a single request
+func (p Properties) header() http.Header {
+	header := http.Header{}
+	if p.ContentType != "" {
+		header.Set("x-ms-blob-content-type", p.ContentType)
+	}
+	if p.ContentEncoding != "" {
+		header.Set("x-ms-blob-content-encoding", p.ContentEncoding)
+	}
+	return header
+}
+
+// StatusError is an unexpected response from the Blob service
+type StatusError = rest.StatusError
+
+// do sends a request and discards the response
+func (c *Client) do(method string, u *url.URL, query url.Values, header http.Header, body []byte) error {
+	_, err := c.request(method, u, query, header, body)
+	return err
+}
+
+// request sends a request through the REST client of the client
+func (c *Client) request(method string, u *url.URL, query url.Values, header http.Header, body []byte) (*rest.Response, error) {
+	target := *u
+	if query != nil {
+		target.RawQuery = query.Encode()
+	}
+	return c.REST.Do(method, target.String(), header, body)
+}
+
+// prepare dates and authorizes every attempt of a request
+func (c *Client) prepare(req *http.Request, _ []byte) error {
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("x-ms-version", Version)
+	if c.Credential != nil {
+		return c.Credential.Authorize(req)
+	}
+	return nil
+}
@@ -0,0 +1,282 @@
+package blob
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"microsoft.com/divoc/pkg/internal/testutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeService is an in-memory Blob service of a single container, served at /<account>/<container> like Azurite
+type fakeService struct {
+	t         *testing.T
+	lock      sync.Mutex
+	blobs     map[string][]byte
+	types     map[string]string
+	blocks    map[string][]byte // uncommitted blocks by blob name and block id
+	failures  map[string]int    // requests by method and path to fail with a 503 before they succeed
+	requests  int
+	inFlight  int
+	maxFlight int
+}
+
+func newFakeService(t *testing.T) *fakeService {
+	return &fakeService{t: t, blobs: map[string][]byte{}, types: map[string]string{}, blocks: map[string][]byte{}, failures: map[string]int{}}
+}
+
+func (s *fakeService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	s.requests++
+	s.inFlight++
+	if s.inFlight > s.maxFlight {
+		s.maxFlight = s.inFlight
+	}
+	failures := s.failures[r.Method+" "+r.URL.Path]
+	if failures > 0 {
+		s.failures[r.Method+" "+r.URL.Path]--
+	}
+	s.lock.Unlock()
+	defer func() {
+		s.lock.Lock()
+		s.inFlight--
+		s.lock.Unlock()
+	}()
+	if failures > 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	if r.Header.Get("x-ms-version") != Version || r.Header.Get("x-ms-date") == "" {
+		s.t.Errorf("%s %s: missing x-ms-version or x-ms-date", r.Method, r.URL)
+	}
+
+	body, _ := ioutil.ReadAll(r.Body)
+	if md5Header := r.Header.Get("Content-MD5"); md5Header != "" {
+		sum := md5.Sum(body)
+		if md5Header != base64.StdEncoding.EncodeToString(sum[:]) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/devstoreaccount1/container/")
+	query := r.URL.Query()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	switch {
+	case r.Method == http.MethodPut && query.Get("comp") == "block":
+		s.blocks[name+"/"+query.Get("blockid")] = body
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut && query.Get("comp") == "blocklist":
+		var list struct {
+			Latest []string `xml:"Latest"`
+		}
+		if err := xml.Unmarshal(body, &list); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var data []byte
+		for _, id := range list.Latest {
+			block, ok := s.blocks[name+"/"+id]
+			if !ok {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			data = append(data, block...)
+		}
+		sum := md5.Sum(data)
+		if r.Header.Get("x-ms-blob-content-md5") != base64.StdEncoding.EncodeToString(sum[:]) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.blobs[name] = data
+		s.types[name] = r.Header.Get("x-ms-blob-content-type")
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut:
+		if r.Header.Get("x-ms-blob-type") != "BlockBlob" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.blobs[name] = body
+		s.types[name] = r.Header.Get("x-ms-blob-content-type")
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodGet && query.Get("comp") == "list":
+		// pages of 2 blobs, continued from the name in the marker
+		var names []string
+		for n := range s.blobs {
+			if strings.HasPrefix(n, query.Get("prefix")) && n >= query.Get("marker") {
+				names = append(names, n)
+			}
+		}
+		sort.Strings(names)
+		next := ""
+		if len(names) > 2 {
+			next = names[2]
+			names = names[:2]
+		}
+		fmt.Fprint(w, xml.Header+"<EnumerationResults><Blobs>")
+		for _, n := range names {
+			fmt.Fprintf(w, "<Blob><Name>%s</Name><Properties><Content-Length>%d</Content-Length></Properties></Blob>", n, len(s.blobs[n]))
+		}
+		fmt.Fprintf(w, "</Blobs><NextMarker>%s</NextMarker></EnumerationResults>", next)
+	case r.Method == http.MethodDelete:
+		if _, ok := s.blobs[name]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(s.blobs, name)
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func newTestClient(t *testing.T, concurrency int) (*Client, *fakeService, func()) {
+	service := newFakeService(t)
+	server := httptest.NewServer(service)
+	client, err := NewClient(server.URL+"/devstoreaccount1/container", nil, concurrency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, service, server.Close
+}
+
+func TestUploadFile(t *testing.T) {
+	client, service, done := newTestClient(t, 4)
+	defer done()
+	client.BlockSize = 1024
+	dir, err := ioutil.TempDir("", "blob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, size := range []int{0, 10, 1024, 1025, 10*1024 + 3} {
+		name := fmt.Sprintf("fhir/file %d.ndjson", size)
+		path, data := testutil.WriteFile(t, dir, fmt.Sprint(size), size)
+		if err := client.UploadFile(path, name, Properties{ContentType: "application/fhir+ndjson"}); err != nil {
+			t.Fatalf("upload of %d bytes: %s", size, err)
+		}
+		if !bytes.Equal(service.blobs[name], data) {
+			t.Errorf("upload of %d bytes: blob holds %d bytes", size, len(service.blobs[name]))
+		}
+		if service.types[name] != "application/fhir+ndjson" {
+			t.Errorf("upload of %d bytes: content type %q", size, service.types[name])
+		}
+	}
+}
+
+func TestUploadFileBoundsRequestsAcrossFiles(t *testing.T) {
+	client, service, done := newTestClient(t, 3)
+	defer done()
+	client.BlockSize = 100
+	dir, err := ioutil.TempDir("", "blob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		path, _ := testutil.WriteFile(t, dir, fmt.Sprint(i), 5000)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := client.UploadFile(path, fmt.Sprint(i), Properties{}); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if service.maxFlight > 3 {
+		t.Errorf("%d requests in flight, expected at most 3", service.maxFlight)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	client, service, done := newTestClient(t, 1)
+	defer done()
+	dir, err := ioutil.TempDir("", "blob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path, _ := testutil.WriteFile(t, dir, "a", 10)
+
+	service.failures["PUT /devstoreaccount1/container/a"] = 1
+	if err := client.UploadFile(path, "a", Properties{}); err != nil {
+		t.Fatal(err)
+	}
+	if service.requests != 2 {
+		t.Errorf("%d requests, expected 2", service.requests)
+	}
+
+	client.REST.MaxRetries = 0
+	service.failures["PUT /devstoreaccount1/container/a"] = 1
+	err = client.UploadFile(path, "a", Properties{})
+	if statusErr, ok := err.(*StatusError); !ok || statusErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected a 503 StatusError, got %v", err)
+	}
+}
+
+func TestListAndDelete(t *testing.T) {
+	client, service, done := newTestClient(t, 1)
+	defer done()
+	for _, name := range []string{"csv/a.csv", "fhir/a.ndjson", "fhir/b.ndjson", "fhir/c.ndjson", "fhir/d.ndjson"} {
+		service.blobs[name] = []byte(name)
+	}
+
+	blobs, err := client.List("fhir/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blobs) != 4 || blobs["fhir/d.ndjson"] != int64(len("fhir/d.ndjson")) {
+		t.Errorf("unexpected blobs %v", blobs)
+	}
+
+	if err := client.Delete("fhir/a.ndjson"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Delete("fhir/a.ndjson"); err != nil {
+		t.Errorf("deleting a missing blob: %s", err)
+	}
+	if _, ok := service.blobs["fhir/a.ndjson"]; ok {
+		t.Error("blob not deleted")
+	}
+}
+
+func TestSharedKey(t *testing.T) {
+	// the well known account and key of the Azurite emulator
+	key, err := NewSharedKey("devstoreaccount1", "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw==")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest(http.MethodPut, "http://127.0.0.1:10000/devstoreaccount1/container/fhir/a%20b.ndjson?comp=block&blockid=YmxvY2s%3D", strings.NewReader("data"))
+	req.Header.Set("x-ms-version", Version)
+	req.Header.Set("x-ms-date", "Mon, 02 Jan 2006 15:04:05 GMT")
+	req.Header.Set("Content-MD5", "jXd/OF09/siBXSD3SWAm3A==")
+	if err := key.Authorize(req); err != nil {
+		t.Fatal(err)
+	}
+
+	stringToSign := "PUT\n\n\n4\njXd/OF09/siBXSD3SWAm3A==\n\n\n\n\n\n\n\n" +
+		"x-ms-date:Mon, 02 Jan 2006 15:04:05 GMT\nx-ms-version:" + Version + "\n" +
+		"/devstoreaccount1/devstoreaccount1/container/fhir/a%20b.ndjson\nblockid:YmxvY2s=\ncomp:block"
+	mac := hmac.New(sha256.New, key.Key)
+	mac.Write([]byte(stringToSign))
+	expected := "SharedKey devstoreaccount1:" + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	if got := req.Header.Get("Authorization"); got != expected {
+		t.Errorf("Authorization %q, expected %q", got, expected)
+	}
+}
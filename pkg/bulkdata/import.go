@@ -0,0 +1,121 @@
+package bulkdata
+
+import (
+	"encoding/json"
+	"fmt"
+	"microsoft.com/divoc/pkg/fhir"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+)
+
+// Modes of an $import
+const (
+	InitialLoad     = "InitialLoad"     // faster, but only for an empty server
+	IncrementalLoad = "IncrementalLoad" // resources are merged into the existing data of the server
+)
+
+// ImportOutput is the result of importing a file of an $import
+type ImportOutput struct {
+	Type     string `json:"type"`
+	Count    int    `json:"count"`
+	InputURL string `json:"inputUrl"`
+	URL      string `json:"url,omitempty"` // of the NDJSON OperationOutcomes of an error
+}
+
+// ImportResult is the response body of a completed $import: the resources imported from each input, and the
+// resources which failed to import
+type ImportResult struct {
+	TransactionTime string         `json:"transactionTime"`
+	Request         string         `json:"request"`
+	Output          []ImportOutput `json:"output"`
+	Error           []ImportOutput `json:"error"`
+}
+
+// ImportParameters returns the Parameters resource of an $import request with an input for every output of a
+// manifest: https://docs.microsoft.com/azure/healthcare-apis/fhir/import-data
+func ImportParameters(m *Manifest, mode string) fhir.Resource {
+	params := []interface{}{
+		map[string]interface{}{"name": "inputFormat", "valueString": "application/fhir+ndjson"},
+		map[string]interface{}{"name": "mode", "valueString": mode},
+	}
+	for _, output := range m.Output {
+		params = append(params, map[string]interface{}{
+			"name": "input",
+			"part": []interface{}{
+				map[string]interface{}{"name": "type", "valueString": output.Type},
+				map[string]interface{}{"name": "url", "valueUri": output.URL},
+			},
+		})
+	}
+	return fhir.Resource{"resourceType": "Parameters", "parameter": params}
+}
+
+// StartImport requests the asynchronous $import of every output of a manifest. Returns the URL of the status of the
+// import job.
+func StartImport(c *fhir.Client, m *Manifest, mode string) (string, error) {
+	data, err := fhir.Encode(ImportParameters(m, mode))
+	if err != nil {
+		return "", err
+	}
+	header := http.Header{}
+	header.Set("Prefer", "respond-async")
+	resp, err := c.Do(http.MethodPost, "$import", header, data)
+	if err != nil {
+		return "", err
+	}
+	status := resp.Header.Get("Content-Location")
+	if status == "" {
+		return "", fmt.Errorf("$import returned status %d without a Content-Location", resp.StatusCode)
+	}
+	return status, nil
+}
+
+// PollImport checks the status of an import job every interval until it completes, calling progress (if not nil)
+// with the X-Progress header of each in-progress response. A timeout of 0 waits forever.
+func PollImport(c *fhir.Client, statusURL string, interval time.Duration, timeout time.Duration, progress func(string)) (*ImportResult, error) {
+	start := time.Now()
+	for {
+		resp, err := c.Do(http.MethodGet, statusURL, nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("import job failed: %s", err)
+		}
+		if resp.StatusCode == http.StatusOK {
+			var result ImportResult
+			if err := json.Unmarshal(resp.Body, &result); err != nil {
+				return nil, fmt.Errorf("failed parsing import result: %s", err)
+			}
+			return &result, nil
+		}
+		if progress != nil {
+			progress(resp.Header.Get("X-Progress"))
+		}
+		if timeout > 0 && time.Since(start) > timeout {
+			return nil, fmt.Errorf("import job did not complete within %s: %s", timeout, statusURL)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// ImportErrors returns the number of resources of each type which failed to import. Errors are usually listed as
+// OperationOutcomes; their type is then the type of their input.
+func (r *ImportResult) ImportErrors() map[string]int {
+	inputTypes := map[string]string{}
+	for _, output := range r.Output {
+		inputTypes[output.InputURL] = output.Type
+	}
+	errors := map[string]int{}
+	for _, e := range r.Error {
+		t := e.Type
+		if t == "OperationOutcome" || t == "" {
+			if inputType, ok := inputTypes[e.InputURL]; ok {
+				t = inputType
+			} else if u, err := url.Parse(e.InputURL); err == nil {
+				t = fhir.NDJSONType(path.Base(u.Path))
+			}
+		}
+		errors[t] += e.Count
+	}
+	return errors
+}
@@ -0,0 +1,124 @@
+package bulkdata
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"microsoft.com/divoc/pkg/fhir"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ManifestName is the file name of the manifest written to the root of a dataset
+const ManifestName = "manifest.json"
+
+// Output is a file of a bulk data export
+type Output struct {
+	Type  string `json:"type"`
+	URL   string `json:"url"`
+	Count int    `json:"count,omitempty"`
+}
+
+// Manifest is the response body of a completed FHIR Bulk Data $export request:
+// https://hl7.org/fhir/uv/bulkdata/export/index.html#response---complete-status
+type Manifest struct {
+	TransactionTime     string   `json:"transactionTime"`
+	Request             string   `json:"request"`
+	RequiresAccessToken bool     `json:"requiresAccessToken"`
+	Output              []Output `json:"output"`
+	Error               []Output `json:"error"`
+}
+
+// NewManifest lists every NDJSON file in dir (recursively, including gzipped .ndjson.gz files) as an output of a
+// manifest. The URL of each output is baseURL joined with the path of the file relative to dir; the count is its
+// number of resources. Outputs are sorted by type, then URL.
+func NewManifest(dir string, baseURL string, request string, transactionTime time.Time) (*Manifest, error) {
+	m := &Manifest{
+		TransactionTime: transactionTime.UTC().Format(time.RFC3339),
+		Request:         request,
+		Output:          []Output{},
+		Error:           []Output{},
+	}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !IsNDJSON(path) {
+			return nil
+		}
+		count, err := countLines(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		m.Output = append(m.Output, Output{
+			Type:  fhir.NDJSONType(path),
+			URL:   strings.TrimSuffix(baseURL, "/") + "/" + filepath.ToSlash(rel),
+			Count: count,
+		})
+		return nil
+	})
+	sort.SliceStable(m.Output, func(i, j int) bool {
+		if m.Output[i].Type != m.Output[j].Type {
+			return m.Output[i].Type < m.Output[j].Type
+		}
+		return m.Output[i].URL < m.Output[j].URL
+	})
+	return m, err
+}
+
+// Write the manifest to path as indented JSON
+func (m *Manifest) Write(path string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// IsNDJSON returns true if path is an NDJSON file, gzipped or not
+func IsNDJSON(path string) bool {
+	name := strings.ToLower(path)
+	return strings.HasSuffix(name, ".ndjson") || strings.HasSuffix(name, ".ndjson.gz")
+}
+
+// countLines counts the non-empty lines of a file, decompressing it if it is gzipped
+func countLines(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(strings.ToLower(path), ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return 0, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	count := 0
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			count++
+		}
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+	}
+}
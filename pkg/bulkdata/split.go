@@ -0,0 +1,141 @@
+package bulkdata
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// partPattern matches the name of a part written by Split; eg: Observation.001.ndjson
+var partPattern = regexp.MustCompile(`(?i)\.[0-9]{3,}\.ndjson$`)
+
+// Limits cap the size of each NDJSON file. A zero limit is not enforced.
+type Limits struct {
+	MaxBytes     int64 // bytes per file -- a single resource larger than this gets a file of its own
+	MaxResources int   // resources per file
+}
+
+// Split rewrites every NDJSON file in dir (eg: the fhir directory of the Synthea output; subdirectories are not
+// searched) exceeding the limits as numbered parts of at most the limits, replacing `Observation.ndjson` with
+// `Observation.000.ndjson`, `Observation.001.ndjson` and so on. Files within the limits, and parts of an earlier
+// split, are left as is. Returns the number of parts written per split file.
+func Split(dir string, limits Limits) (map[string]int, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	parts := map[string]int{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.EqualFold(filepath.Ext(entry.Name()), ".ndjson") || partPattern.MatchString(entry.Name()) {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		n, err := splitFile(path, limits)
+		if err != nil {
+			return nil, fmt.Errorf("failed splitting %s: %s", path, err)
+		}
+		if n > 1 {
+			parts[path] = n
+		}
+	}
+	return parts, nil
+}
+
+// partPath returns the path of part i of an NDJSON file; eg: Observation.ndjson => Observation.001.ndjson
+func partPath(path string, i int) string {
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s.%03d%s", strings.TrimSuffix(path, ext), i, ext)
+}
+
+// splitFile splits an NDJSON file into parts. Returns the number of parts; if 1, the file is left unchanged.
+func splitFile(path string, limits Limits) (int, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	var part *os.File
+	var w *bufio.Writer
+	var parts []string
+	var size int64
+	var count int
+	// closePart flushes and closes the current part, if any
+	closePart := func() error {
+		if part == nil {
+			return nil
+		}
+		if err := w.Flush(); err != nil {
+			part.Close()
+			return err
+		}
+		err := part.Close()
+		part = nil
+		return err
+	}
+	// remove every part written if the split fails
+	success := false
+	defer func() {
+		if !success {
+			closePart()
+			for _, p := range parts {
+				os.Remove(p)
+			}
+		}
+	}()
+
+	reader := bufio.NewReader(in)
+	for {
+		line, readErr := reader.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF {
+			return 0, readErr
+		}
+		if len(bytes.TrimSpace(line)) > 0 {
+			if line[len(line)-1] != '\n' {
+				line = append(line, '\n')
+			}
+			full := part != nil && ((limits.MaxResources > 0 && count >= limits.MaxResources) ||
+				(limits.MaxBytes > 0 && size+int64(len(line)) > limits.MaxBytes))
+			if part == nil || full {
+				if err := closePart(); err != nil {
+					return 0, err
+				}
+				p := partPath(path, len(parts))
+				if part, err = os.Create(p); err != nil {
+					return 0, err
+				}
+				w = bufio.NewWriter(part)
+				parts = append(parts, p)
+				size, count = 0, 0
+			}
+			if _, err := w.Write(line); err != nil {
+				return 0, err
+			}
+			size += int64(len(line))
+			count++
+		}
+		if readErr == io.EOF {
+			break
+		}
+	}
+	if err := closePart(); err != nil {
+		return 0, err
+	}
+	in.Close()
+
+	success = true
+	if len(parts) <= 1 {
+		// within the limits -- keep the original file
+		for _, p := range parts {
+			os.Remove(p)
+		}
+		return len(parts), nil
+	}
+	return len(parts), os.Remove(path)
+}
@@ -0,0 +1,151 @@
+package bulkdata
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// writeFiles writes files, keyed by slash separated path, under dir
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, data := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readFiles returns the content of every file under dir, keyed by slash separated path
+func readFiles(t *testing.T, dir string) map[string]string {
+	files := map[string]string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		files[filepath.ToSlash(rel)] = string(data)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestSplit(t *testing.T) {
+	observations := "{\"id\":\"o1\"}\n{\"id\":\"o2\"}\n\n{\"id\":\"o3\"}\n{\"id\":\"o4\"}\n{\"id\":\"o5\"}" // blank line, no final newline
+	tests := []struct {
+		name     string
+		limits   Limits
+		expected map[string]string
+	}{
+		{"resources", Limits{MaxResources: 2}, map[string]string{
+			"Observation.000.ndjson": "{\"id\":\"o1\"}\n{\"id\":\"o2\"}\n",
+			"Observation.001.ndjson": "{\"id\":\"o3\"}\n{\"id\":\"o4\"}\n",
+			"Observation.002.ndjson": "{\"id\":\"o5\"}\n",
+		}},
+		{"bytes", Limits{MaxBytes: 30}, map[string]string{
+			"Observation.000.ndjson": "{\"id\":\"o1\"}\n{\"id\":\"o2\"}\n",
+			"Observation.001.ndjson": "{\"id\":\"o3\"}\n{\"id\":\"o4\"}\n",
+			"Observation.002.ndjson": "{\"id\":\"o5\"}\n",
+		}},
+		{"larger resource", Limits{MaxBytes: 5}, map[string]string{
+			"Observation.000.ndjson": "{\"id\":\"o1\"}\n",
+			"Observation.001.ndjson": "{\"id\":\"o2\"}\n",
+			"Observation.002.ndjson": "{\"id\":\"o3\"}\n",
+			"Observation.003.ndjson": "{\"id\":\"o4\"}\n",
+			"Observation.004.ndjson": "{\"id\":\"o5\"}\n",
+		}},
+		{"within limits", Limits{MaxResources: 5}, map[string]string{
+			"Observation.ndjson": observations,
+		}},
+	}
+	for _, test := range tests {
+		dir, err := ioutil.TempDir("", "split")
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeFiles(t, dir, map[string]string{
+			"fhir/Observation.ndjson":          observations,
+			"fhir/Patient.ndjson":              "{\"id\":\"p1\"}\n",
+			"fhir/degraded/Observation.ndjson": observations,
+			"degraded/fhir/Observation.ndjson": observations,
+		})
+		fhirDir := filepath.Join(dir, "fhir")
+
+		// re-running finds nothing left to split
+		for run := 0; run < 2; run++ {
+			parts, err := Split(fhirDir, test.limits)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectedParts := map[string]int{}
+			if run == 0 && len(test.expected) > 1 {
+				expectedParts[filepath.Join(fhirDir, "Observation.ndjson")] = len(test.expected)
+			}
+			if !reflect.DeepEqual(parts, expectedParts) {
+				t.Errorf("%s, run %d: split %v, expected %v", test.name, run, parts, expectedParts)
+			}
+		}
+
+		expected := map[string]string{
+			"fhir/Patient.ndjson":              "{\"id\":\"p1\"}\n",
+			"fhir/degraded/Observation.ndjson": observations,
+			"degraded/fhir/Observation.ndjson": observations,
+		}
+		for name, data := range test.expected {
+			expected["fhir/"+name] = data
+		}
+		if files := readFiles(t, dir); !reflect.DeepEqual(files, expected) {
+			var names []string
+			for name := range files {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			t.Errorf("%s: wrote %s", test.name, strings.Join(names, ", "))
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func TestSplitSkipsParts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "split")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"Observation.000.ndjson":  "{\"id\":\"o1\"}\n{\"id\":\"o2\"}\n{\"id\":\"o3\"}\n",
+		"Observation.1000.NDJSON": "{\"id\":\"o4\"}\n{\"id\":\"o5\"}\n{\"id\":\"o6\"}\n",
+		"Condition.v2.ndjson":     "{\"id\":\"c1\"}\n{\"id\":\"c2\"}\n{\"id\":\"c3\"}\n",
+	}
+	writeFiles(t, dir, files)
+	parts, err := Split(dir, Limits{MaxResources: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 1 || parts[filepath.Join(dir, "Condition.v2.ndjson")] != 2 {
+		t.Errorf("split %v, expected only Condition.v2.ndjson", parts)
+	}
+	got := readFiles(t, dir)
+	for _, name := range []string{"Observation.000.ndjson", "Observation.1000.NDJSON"} {
+		if got[name] != files[name] {
+			t.Errorf("%s was rewritten", name)
+		}
+	}
+	if len(got) != 4 {
+		t.Errorf("wrote %d files, expected the 2 parts of Condition.v2.ndjson and the earlier parts", len(got))
+	}
+}
@@ -0,0 +1,121 @@
+package compress
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Extension appended to the name of compressed files
+const Extension = ".gz"
+
+// ContentEncoding of compressed files
+const ContentEncoding = "gzip"
+
+// ContentTypes of the files that can be compressed, keyed by extension
+var ContentTypes = map[string]string{
+	".ndjson": "application/fhir+ndjson",
+	".json":   "application/fhir+json",
+	".csv":    "text/csv",
+}
+
+// Options of Dir
+type Options struct {
+	Extensions []string // extensions of the files to compress; eg: ".ndjson"
+	Workers    int      // files compressed in parallel -- defaults to the number of CPUs
+}
+
+// Dir gzips every file in dir (recursively) with one of the provided extensions, replacing `name.ext` with
+// `name.ext.gz`. Files are compressed in parallel. Returns the paths of the compressed files, sorted.
+func Dir(dir string, o Options) ([]string, error) {
+	extensions := map[string]bool{}
+	for _, ext := range o.Extensions {
+		extensions[strings.ToLower(ext)] = true
+	}
+	var paths []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && extensions[strings.ToLower(filepath.Ext(path))] {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	workers := o.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	var compressed []string
+	var firstErr error
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range jobs {
+				out, err := File(path)
+				lock.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = err
+				} else if err == nil {
+					compressed = append(compressed, out)
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+	for _, path := range paths {
+		jobs <- path
+	}
+	close(jobs)
+	wg.Wait()
+	sort.Strings(compressed)
+	return compressed, firstErr
+}
+
+// File streams a file through gzip to `path.gz` and removes the original. Returns the path of the compressed file.
+func File(path string) (string, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	// compress to a temporary file so an interrupted run never leaves a truncated .gz behind
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	gz := gzip.NewWriter(tmp)
+	gz.Name = filepath.Base(path)
+	if _, err := io.Copy(gz, in); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := gz.Close(); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	out := path + Extension
+	if err := os.Rename(tmp.Name(), out); err != nil {
+		return "", err
+	}
+	in.Close()
+	return out, os.Remove(path)
+}
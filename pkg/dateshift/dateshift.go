@@ -0,0 +1,209 @@
+package dateshift
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"microsoft.com/divoc/pkg/fhir"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// dateLayout is the layout of the date portion of FHIR date, dateTime and instant values
+const dateLayout = "2006-01-02"
+
+// datePattern matches FHIR date, dateTime and instant values with at least day precision.
+// Partial dates (YYYY, YYYY-MM) are not shifted as they can not be told apart from codes and other numbers.
+var datePattern = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}(T\d{2}:\d{2}(:\d{2}(\.\d+)?)?(Z|[+-]\d{2}:\d{2})?)?$`)
+
+// dateElements are the date, dateTime and instant elements whose names do not end with their type; eg: Period.start,
+// Meta.lastUpdated or Claim.created
+var dateElements = map[string]bool{
+	"start": true, "end": true, "date": true, "authoredOn": true, "recorded": true, "issued": true, "created": true,
+	"started": true, "lastUpdated": true, "timestamp": true, "event": true, "whenPrepared": true,
+	"whenHandedOver": true, "sent": true, "received": true,
+}
+
+// dateElement reports whether an element is a FHIR date, dateTime or instant by its name; eg: birthDate,
+// effectiveDateTime or the start of effectivePeriod. Strings of other elements which look like dates (valueString,
+// identifier values, notes...) are not dates.
+func dateElement(name string) bool {
+	return dateElements[name] || strings.HasSuffix(name, "Date") || strings.HasSuffix(name, "DateTime") ||
+		strings.HasSuffix(name, "Instant")
+}
+
+// dateColumn reports whether a Synthea CSV column holds dates by its header; eg: START, STOP, BIRTHDATE, SERVICEDATE
+func dateColumn(header string) bool {
+	header = strings.ToUpper(header)
+	return header == "START" || header == "STOP" || strings.Contains(header, "DATE")
+}
+
+// Days returns the number of whole days between from and to -- the offset which moves from onto to
+func Days(from time.Time, to time.Time) int {
+	from = time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+	to = time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, time.UTC)
+	return int(to.Sub(from).Hours() / 24)
+}
+
+// Value shifts a FHIR date, dateTime or instant value by the provided number of days.
+// Only the date portion changes; the time of day, precision and timezone offset are kept as-is so every interval and
+// patient age in a dataset shifted by the same offset is unchanged.
+// Returns false if the value is not a date.
+func Value(value string, days int) (string, bool) {
+	if !datePattern.MatchString(value) {
+		return value, false
+	}
+	date, err := time.Parse(dateLayout, value[:len(dateLayout)])
+	if err != nil {
+		return value, false
+	}
+	return date.AddDate(0, 0, days).Format(dateLayout) + value[len(dateLayout):], true
+}
+
+// Resource shifts every date, dateTime, instant and Period element of the resource, at any depth, by the provided days
+func Resource(r fhir.Resource, days int) {
+	walk(map[string]interface{}(r), false, func(s string) string {
+		shifted, _ := Value(s, days)
+		return shifted
+	})
+}
+
+// Dataset shifts every date of a Synthea output directory in place by the provided days -- both the FHIR files and,
+// if present, the CSV files
+func Dataset(dir string, days int) error {
+	files, err := fhir.ListFiles(filepath.Join(dir, fhir.Subdir))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		err := fhir.RewriteFile(file, func(r fhir.Resource) (fhir.Resource, error) {
+			Resource(r, days)
+			return r, nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed shifting dates in %s: %s", file.Path, err)
+		}
+	}
+	csvDir := filepath.Join(dir, "csv")
+	if _, err := os.Stat(csvDir); os.IsNotExist(err) {
+		return nil
+	}
+	return CSVDir(csvDir, days)
+}
+
+// Latest returns the latest date found in the FHIR files of dir.
+// For freshly generated data this is the date Synthea was run.
+func Latest(dir string) (latest time.Time, err error) {
+	err = fhir.ReadDir(dir, func(_ fhir.File, r fhir.Resource) error {
+		walk(map[string]interface{}(r), false, func(s string) string {
+			if datePattern.MatchString(s) {
+				if t, err := time.Parse(dateLayout, s[:len(dateLayout)]); err == nil && t.After(latest) {
+					latest = t
+				}
+			}
+			return s
+		})
+		return nil
+	})
+	if err == nil && latest.IsZero() {
+		err = fmt.Errorf("no dates found in %s", dir)
+	}
+	return latest, err
+}
+
+// CSVDir shifts every date in every CSV file of dir in place by the provided days
+func CSVDir(dir string, days int) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.EqualFold(filepath.Ext(entry.Name()), ".csv") {
+			continue
+		}
+		if err := csvFile(filepath.Join(dir, entry.Name()), days); err != nil {
+			return fmt.Errorf("failed shifting dates in %s: %s", entry.Name(), err)
+		}
+	}
+	return nil
+}
+
+// csvFile shifts every date cell of a CSV file in place
+func csvFile(path string, days int) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	reader := csv.NewReader(in)
+	reader.FieldsPerRecord = -1
+	writer := csv.NewWriter(tmp)
+	var dates []bool
+	for header := true; ; header = false {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		for i := range record {
+			if header {
+				dates = append(dates, dateColumn(record[i]))
+			} else if i < len(dates) && dates[i] {
+				record[i], _ = Value(record[i], days)
+			}
+		}
+		if err := writer.Write(record); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// walk replaces every string of a date element in v with the result of fn; date is whether v is the value of one
+func walk(v interface{}, date bool, fn func(string) string) {
+	switch node := v.(type) {
+	case map[string]interface{}:
+		for key, child := range node {
+			if s, ok := child.(string); ok {
+				if dateElement(key) {
+					node[key] = fn(s)
+				}
+			} else {
+				walk(child, dateElement(key), fn)
+			}
+		}
+	case []interface{}:
+		// elements of a repeating element; eg: Timing.event
+		for i, child := range node {
+			if s, ok := child.(string); ok {
+				if date {
+					node[i] = fn(s)
+				}
+			} else {
+				walk(child, false, fn)
+			}
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package degrade
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"microsoft.com/divoc/pkg/fhir"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Kinds of degradation applied to a dataset
+const (
+	MissingField        = "missing-field"          // an optional field was removed
+	Typo                = "typo"                   // a name or address was misspelled
+	DuplicatePatient    = "duplicate-patient"      // a copy of a patient with slightly different demographics was added
+	CodeSystem          = "code-system"            // a coding system was replaced with an equivalent but inconsistent URI
+	OutOfOrderTimestamp = "out-of-order-timestamp" // timestamps were reordered so an end precedes its start
+)
+
+// ReportName is the name of the report written next to the degraded dataset
+const ReportName = "degradation-report.json"
+
+// Options of a degradation run
+type Options struct {
+	Seed int64   // seed of the random source -- the same seed and input always produce the same output
+	Rate float64 // probability in (0, 1] that each eligible element is degraded
+}
+
+// Change is a single modification made to the dataset
+type Change struct {
+	File         string `json:"file"` // path relative to the output directory
+	ResourceType string `json:"resourceType"`
+	ID           string `json:"id"`
+	Kind         string `json:"kind"`
+	Path         string `json:"path"` // path of the modified element within the resource
+	Old          string `json:"old,omitempty"`
+	New          string `json:"new,omitempty"`
+}
+
+// Report describes every change made by a degradation run
+type Report struct {
+	Seed    int64          `json:"seed"`
+	Rate    float64        `json:"rate"`
+	Counts  map[string]int `json:"counts"` // changes per kind
+	Changes []Change       `json:"changes"`
+}
+
+// degrader holds the state of a single run
+type degrader struct {
+	rng    *rand.Rand
+	rate   float64
+	report *Report
+	file   string // file currently being degraded, relative to the output directory
+}
+
+// Run writes a degraded copy of the Synthea output directory `in` to `out` along with a report of every change
+// (written to `<out>/degradation-report.json`). FHIR files are degraded; CSV files are copied unchanged.
+func Run(in string, out string, o Options) (*Report, error) {
+	if o.Rate <= 0 || o.Rate > 1 {
+		return nil, fmt.Errorf("degradation rate must be in (0, 1]: %v", o.Rate)
+	}
+	d := degrader{
+		rng:    rand.New(rand.NewSource(o.Seed)),
+		rate:   o.Rate,
+		report: &Report{Seed: o.Seed, Rate: o.Rate, Counts: map[string]int{}, Changes: []Change{}},
+	}
+
+	files, err := fhir.ListFiles(filepath.Join(in, fhir.Subdir))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		rel, err := filepath.Rel(in, file.Path)
+		if err != nil {
+			return nil, err
+		}
+		d.file = rel
+		var duplicates []fhir.Resource
+		dst := filepath.Join(out, rel)
+		_, err = fhir.CopyFile(file, dst, func(r fhir.Resource) (fhir.Resource, error) {
+			if dup := d.resource(r); dup != nil {
+				duplicates = append(duplicates, dup)
+			}
+			return r, nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed degrading %s: %s", file.Path, err)
+		}
+		if err := appendResources(fhir.File{Path: dst, Format: file.Format}, duplicates); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := copyCSV(filepath.Join(in, "csv"), filepath.Join(out, "csv")); err != nil {
+		return nil, err
+	}
+	data, err := json.MarshalIndent(d.report, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return d.report, ioutil.WriteFile(filepath.Join(out, ReportName), data, 0644)
+}
+
+// resource degrades r in place. Returns a duplicate of r to add to the dataset, or nil.
+func (d *degrader) resource(r fhir.Resource) (duplicate fhir.Resource) {
+	d.missingFields(r)
+	if r.ResourceType() == "Patient" {
+		d.typos(r)
+		duplicate = d.duplicate(r)
+	}
+	d.codeSystems(r)
+	d.timestamps(r)
+	return duplicate
+}
+
+// chance returns true with the probability of the configured rate
+func (d *degrader) chance() bool {
+	return d.rng.Float64() < d.rate
+}
+
+// record a change to the report
+func (d *degrader) record(r fhir.Resource, kind string, path string, old string, new string) {
+	d.report.Counts[kind]++
+	d.report.Changes = append(d.report.Changes, Change{
+		File:         d.file,
+		ResourceType: r.ResourceType(),
+		ID:           r.ID(),
+		Kind:         kind,
+		Path:         path,
+		Old:          old,
+		New:          new,
+	})
+}
+
+// appendResources adds resources to the end of an already written NDJSON file or Bundle
+func appendResources(file fhir.File, resources []fhir.Resource) error {
+	if len(resources) == 0 {
+		return nil
+	}
+	if file.Format == fhir.FormatNDJSON {
+		f, err := os.OpenFile(file.Path, os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		writer := fhir.NewNDJSONWriter(f)
+		for _, r := range resources {
+			if err := writer.Write(r); err != nil {
+				f.Close()
+				return err
+			}
+		}
+		if err := writer.Flush(); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+
+	bundle, err := fhir.ReadJSONFile(file.Path)
+	if err != nil {
+		return err
+	}
+	for _, r := range resources {
+		fhir.AddEntry(bundle, r, "POST")
+	}
+	return fhir.WriteJSONFile(file.Path, bundle)
+}
+
+// copyCSV copies every file of the CSV directory unchanged, if present
+func copyCSV(in string, out string) error {
+	entries, err := ioutil.ReadDir(in)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(out, 0755); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if err := copyFile(filepath.Join(in, entry.Name()), filepath.Join(out, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyFile streams src to dst
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// sortedKeys returns the keys of m in sorted order so every run visits elements in the same order
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// walkObjects calls fn with every object in v and its path, in a deterministic order
+func walkObjects(v interface{}, path string, fn func(obj map[string]interface{}, path string)) {
+	switch node := v.(type) {
+	case map[string]interface{}:
+		fn(node, path)
+		for _, key := range sortedKeys(node) {
+			walkObjects(node[key], join(path, key), fn)
+		}
+	case []interface{}:
+		for i, child := range node {
+			walkObjects(child, fmt.Sprintf("%s[%d]", path, i), fn)
+		}
+	}
+}
+
+// join appends key to an element path
+func join(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,190 @@
+package degrade
+
+import (
+	"fmt"
+	"microsoft.com/divoc/pkg/fhir"
+	"time"
+)
+
+// optionalFields are the fields which may be removed from each resource type -- none are required by FHIR R4
+var optionalFields = map[string][]string{
+	"Patient":           {"telecom", "maritalStatus", "communication", "multipleBirthBoolean", "extension"},
+	"Encounter":         {"serviceProvider", "participant", "reasonCode", "hospitalization"},
+	"Condition":         {"encounter", "abatementDateTime", "recordedDate", "category"},
+	"Observation":       {"encounter", "issued", "category"},
+	"Procedure":         {"encounter", "reasonReference", "performedPeriod"},
+	"MedicationRequest": {"encounter", "requester", "reasonReference", "dosageInstruction"},
+	"Immunization":      {"encounter", "location"},
+}
+
+// optionalAddressFields are the fields which may be removed from each Patient address
+var optionalAddressFields = []string{"postalCode", "district", "extension"}
+
+// alternateSystems are equivalent but inconsistent identifications of common code systems
+var alternateSystems = map[string][]string{
+	"http://loinc.org":                            {"urn:oid:2.16.840.1.113883.6.1", "LOINC", "http://loinc.org/"},
+	"http://snomed.info/sct":                      {"urn:oid:2.16.840.1.113883.6.96", "SNOMED-CT", "http://snomed.info/sct/"},
+	"http://www.nlm.nih.gov/research/umls/rxnorm": {"urn:oid:2.16.840.1.113883.6.88", "RxNorm"},
+	"http://hl7.org/fhir/sid/cvx":                 {"urn:oid:2.16.840.1.113883.12.292", "CVX"},
+}
+
+// missingFields removes optional fields of r
+func (d *degrader) missingFields(r fhir.Resource) {
+	for _, field := range optionalFields[r.ResourceType()] {
+		if _, ok := r[field]; ok && d.chance() {
+			delete(r, field)
+			d.record(r, MissingField, field, "", "")
+		}
+	}
+	if r.ResourceType() != "Patient" {
+		return
+	}
+	addresses, _ := r["address"].([]interface{})
+	for i, item := range addresses {
+		address, _ := item.(map[string]interface{})
+		for _, field := range optionalAddressFields {
+			if _, ok := address[field]; ok && d.chance() {
+				delete(address, field)
+				d.record(r, MissingField, fmt.Sprintf("address[%d].%s", i, field), "", "")
+			}
+		}
+	}
+}
+
+// typos misspells the names and address lines of a patient
+func (d *degrader) typos(r fhir.Resource) {
+	names, _ := r["name"].([]interface{})
+	for i, item := range names {
+		name, _ := item.(map[string]interface{})
+		d.typoField(r, name, "family", fmt.Sprintf("name[%d].family", i))
+		givens, _ := name["given"].([]interface{})
+		for j := range givens {
+			d.typoItem(r, givens, j, fmt.Sprintf("name[%d].given[%d]", i, j))
+		}
+	}
+	addresses, _ := r["address"].([]interface{})
+	for i, item := range addresses {
+		address, _ := item.(map[string]interface{})
+		d.typoField(r, address, "city", fmt.Sprintf("address[%d].city", i))
+		lines, _ := address["line"].([]interface{})
+		for j := range lines {
+			d.typoItem(r, lines, j, fmt.Sprintf("address[%d].line[%d]", i, j))
+		}
+	}
+}
+
+// typoField misspells the string stored at obj[key]
+func (d *degrader) typoField(r fhir.Resource, obj map[string]interface{}, key string, path string) {
+	if s, ok := obj[key].(string); ok && d.chance() {
+		obj[key] = d.misspell(s)
+		d.record(r, Typo, path, s, obj[key].(string))
+	}
+}
+
+// typoItem misspells the string stored at list[i]
+func (d *degrader) typoItem(r fhir.Resource, list []interface{}, i int, path string) {
+	if s, ok := list[i].(string); ok && d.chance() {
+		list[i] = d.misspell(s)
+		d.record(r, Typo, path, s, list[i].(string))
+	}
+}
+
+// misspell applies a single random keyboard style error to s: a swap, omission, repetition or substitution
+func (d *degrader) misspell(s string) string {
+	runes := []rune(s)
+	if len(runes) < 2 {
+		return s + s
+	}
+	i := d.rng.Intn(len(runes) - 1)
+	switch d.rng.Intn(4) {
+	case 0: // transpose adjacent characters
+		runes[i], runes[i+1] = runes[i+1], runes[i]
+	case 1: // drop a character
+		runes = append(runes[:i], runes[i+1:]...)
+	case 2: // repeat a character
+		runes = append(runes[:i+1], runes[i:]...)
+	default: // substitute a character with a random lowercase letter
+		runes[i] = rune('a' + d.rng.Intn(26))
+	}
+	return string(runes)
+}
+
+// duplicate returns a copy of the patient with a new id and slightly different demographics, or nil
+func (d *degrader) duplicate(patient fhir.Resource) fhir.Resource {
+	if !d.chance() {
+		return nil
+	}
+	data, err := fhir.Encode(patient)
+	if err != nil {
+		return nil
+	}
+	dup, err := fhir.Decode(data)
+	if err != nil {
+		return nil
+	}
+	dup.SetID(d.uuid())
+	delete(dup, "identifier") // a second registration never shares the medical record number
+	d.record(dup, DuplicatePatient, "", patient.ID(), dup.ID())
+
+	// always change at least one demographic so the duplicate is not an exact match
+	d.typos(dup)
+	if birthDate, ok := dup["birthDate"].(string); ok {
+		if t, err := time.Parse("2006-01-02", birthDate); err == nil {
+			// swap day and month when possible, otherwise move by a day
+			shifted := t.AddDate(0, 0, 1)
+			if t.Day() <= 12 && t.Day() != int(t.Month()) {
+				shifted = time.Date(t.Year(), time.Month(t.Day()), int(t.Month()), 0, 0, 0, 0, time.UTC)
+			}
+			dup["birthDate"] = shifted.Format("2006-01-02")
+			d.record(dup, DuplicatePatient, "birthDate", birthDate, dup["birthDate"].(string))
+		}
+	}
+	return dup
+}
+
+// codeSystems replaces the system of codings with an inconsistent equivalent
+func (d *degrader) codeSystems(r fhir.Resource) {
+	walkObjects(map[string]interface{}(r), "", func(obj map[string]interface{}, path string) {
+		system, _ := obj["system"].(string)
+		alternates := alternateSystems[system]
+		if _, isCoding := obj["code"]; !isCoding || len(alternates) == 0 || !d.chance() {
+			return
+		}
+		obj["system"] = alternates[d.rng.Intn(len(alternates))]
+		d.record(r, CodeSystem, join(path, "system"), system, obj["system"].(string))
+	})
+}
+
+// timestamps swaps the start and end of Periods and moves Observation.issued before the observation was made
+func (d *degrader) timestamps(r fhir.Resource) {
+	walkObjects(map[string]interface{}(r), "", func(obj map[string]interface{}, path string) {
+		start, _ := obj["start"].(string)
+		end, _ := obj["end"].(string)
+		if start == "" || end == "" || start == end || !d.chance() {
+			return
+		}
+		obj["start"], obj["end"] = end, start
+		d.record(r, OutOfOrderTimestamp, join(path, "start"), start, end)
+	})
+
+	effective, _ := r["effectiveDateTime"].(string)
+	issued, _ := r["issued"].(string)
+	if effective == "" || issued == "" || !d.chance() {
+		return
+	}
+	t, err := time.Parse(time.RFC3339, effective)
+	if err != nil {
+		return
+	}
+	r["issued"] = t.Add(-time.Duration(1+d.rng.Intn(72)) * time.Hour).Format(time.RFC3339)
+	d.record(r, OutOfOrderTimestamp, "issued", issued, r["issued"].(string))
+}
+
+// uuid returns a random version 4 UUID drawn from the seeded random source
+func (d *degrader) uuid() string {
+	b := make([]byte, 16)
+	d.rng.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
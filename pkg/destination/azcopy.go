@@ -0,0 +1,131 @@
+package destination
+
+import (
+	"errors"
+	"fmt"
+	"microsoft.com/divoc/pkg/azure/auth"
+	"microsoft.com/divoc/pkg/azure/azcopy"
+	"microsoft.com/divoc/pkg/compress"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// AzCopy is an Azure Blob Storage container (or virtual directory) uploaded to with the azcopy binary.
+// The azcopy Context must be logged in, unless a SAS authorizes access to the container.
+type AzCopy struct {
+	Context           azcopy.Context
+	ContainerURL      string               // eg: https://<account>.blob.core.windows.net/<container>
+	SAS               auth.SAS             // added to the URLs azcopy is run with, if set
+	Sync              bool                 // upload with azcopy sync, so only new and modified files are uploaded
+	DeleteDestination string               // with Sync, whether blobs without a matching file are deleted; see azcopy.SyncOptions
+	Jobs              []*azcopy.JobSummary // summaries of the azcopy jobs of the last upload
+}
+
+// NewAzCopy returns an AzCopy destination for a container of a storage account
+func NewAzCopy(ctx azcopy.Context, account string, container string) *AzCopy {
+	return &AzCopy{Context: ctx, ContainerURL: "https://" + account + ".blob.core.windows.net/" + container}
+}
+
+// Upload copies every file under dir to the container. Gzipped files are uploaded with `Content-Encoding: gzip` and
+// the content type of the file they compress, which takes a separate azcopy run per kind of compressed file.
+// With Sync, the container is synced with dir instead; azcopy sync cannot set the headers of gzipped files, so dir
+// must have none.
+func (a *AzCopy) Upload(dir string) error {
+	a.Jobs = nil
+	files, err := Files(dir)
+	if err != nil {
+		return err
+	}
+	compressed := map[string]bool{} // extensions of the compressed files; eg: .ndjson
+	for file := range files {
+		if strings.HasSuffix(file, compress.Extension) {
+			compressed[path.Ext(strings.TrimSuffix(file, compress.Extension))] = true
+		}
+	}
+
+	if a.Sync {
+		if len(compressed) > 0 {
+			return errors.New("gzipped files cannot be uploaded with azcopy sync, which cannot set their Content-Encoding")
+		}
+		job, err := a.Context.Sync(dir, a.SAS.AddTo(a.ContainerURL), azcopy.SyncOptions{DeleteDestination: a.DeleteDestination})
+		a.addJob(job)
+		return err
+	}
+
+	copies := []azcopy.CopyOptions{{}}
+	if len(compressed) > 0 {
+		var extensions []string
+		for ext := range compressed {
+			extensions = append(extensions, ext)
+		}
+		sort.Strings(extensions)
+		copies = []azcopy.CopyOptions{{ExcludePattern: "*" + compress.Extension}}
+		for _, ext := range extensions {
+			copies = append(copies, azcopy.CopyOptions{
+				IncludePattern:  "*" + ext + compress.Extension,
+				ContentType:     compress.ContentTypes[ext],
+				ContentEncoding: compress.ContentEncoding,
+			})
+		}
+	}
+	for _, options := range copies {
+		job, err := a.Context.CopyWithOptions(filepath.Join(dir, "*"), a.SAS.AddTo(a.ContainerURL), options)
+		a.addJob(job)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addJob keeps the summary of an azcopy job, if azcopy reported one
+func (a *AzCopy) addJob(job *azcopy.JobSummary) {
+	if job != nil {
+		a.Jobs = append(a.Jobs, job)
+	}
+}
+
+// Report summarizes the azcopy jobs of the last upload, listing the files which failed to transfer
+func (a *AzCopy) Report() string {
+	if len(a.Jobs) == 0 {
+		return "no azcopy job summary"
+	}
+	var lines []string
+	for _, job := range a.Jobs {
+		lines = append(lines, job.String())
+		for _, t := range job.FailedTransfers {
+			lines = append(lines, fmt.Sprintf("  failed: %s (%d)", t.Src, t.ErrorCode))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// List the blobs in the container
+func (a *AzCopy) List() (map[string]int64, error) {
+	return a.Context.List(a.SAS.AddTo(a.ContainerURL))
+}
+
+// Verify that every file under dir was uploaded to the container
+func (a *AzCopy) Verify(dir string) error {
+	return Verify(a, dir)
+}
+
+// Delete the blobs at the provided paths
+func (a *AzCopy) Delete(paths []string) error {
+	for _, p := range paths {
+		if err := a.Context.Remove(a.SAS.AddTo(a.URL(p))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// URL of the blob at a path
+func (a *AzCopy) URL(p string) string {
+	if p == "" {
+		return a.ContainerURL
+	}
+	return strings.TrimSuffix(a.ContainerURL, "/") + "/" + strings.TrimPrefix(p, "/")
+}
@@ -0,0 +1,58 @@
+package destination
+
+import (
+	"microsoft.com/divoc/pkg/azure/blob"
+)
+
+// Blob is an Azure Blob Storage container uploaded to with the native Blob service client, without the azcopy binary
+type Blob struct {
+	Client  *blob.Client
+	Workers int // files uploaded in parallel
+}
+
+// NewBlob returns a Blob destination uploading through a client
+func NewBlob(client *blob.Client) *Blob {
+	return &Blob{Client: client, Workers: 4}
+}
+
+// Upload every file under dir to the container. Gzipped files are uploaded with `Content-Encoding: gzip` and the
+// content type of the file they compress.
+func (b *Blob) Upload(dir string) error {
+	return uploadFiles(dir, b.Workers, func(file string, p string) error {
+		return b.Client.UploadFile(file, p, blobProperties(p))
+	})
+}
+
+// blobProperties returns the properties of the blob uploaded from a file
+func blobProperties(p string) blob.Properties {
+	contentType, contentEncoding := contentHeaders(p)
+	return blob.Properties{ContentType: contentType, ContentEncoding: contentEncoding}
+}
+
+// List the blobs in the container
+func (b *Blob) List() (map[string]int64, error) {
+	return b.Client.List("")
+}
+
+// Verify that every file under dir was uploaded to the container
+func (b *Blob) Verify(dir string) error {
+	return Verify(b, dir)
+}
+
+// Delete the blobs at the provided paths
+func (b *Blob) Delete(paths []string) error {
+	for _, p := range paths {
+		if err := b.Client.Delete(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// URL of the blob at a path
+func (b *Blob) URL(p string) string {
+	if p == "" {
+		return b.Client.ContainerURL.String()
+	}
+	return b.Client.BlobURL(p).String()
+}
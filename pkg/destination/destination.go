@@ -0,0 +1,127 @@
+package destination
+
+import (
+	"fmt"
+	"microsoft.com/divoc/pkg/compress"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Destination is where a generated dataset is uploaded to. Objects are addressed by their slash separated path
+// relative to the root of the destination, which mirrors the paths of the files relative to the uploaded directory.
+type Destination interface {
+	// Upload every file under dir, keeping its path relative to dir
+	Upload(dir string) error
+	// List the objects at the destination with their size in bytes
+	List() (map[string]int64, error)
+	// Verify that every file under dir exists at the destination with the same size
+	Verify(dir string) error
+	// Delete the objects at the provided paths
+	Delete(paths []string) error
+	// URL of the object at a path; eg: for a Bulk Data manifest
+	URL(path string) string
+}
+
+// Reporter is a Destination which reports on the outcome of its last upload; eg: the resources loaded by type
+type Reporter interface {
+	Report() string
+}
+
+// Files returns the size of every file under dir, keyed by its slash separated path relative to dir
+func Files(dir string) (map[string]int64, error) {
+	files := map[string]int64{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = info.Size()
+		return nil
+	})
+	return files, err
+}
+
+// Verify compares the files under dir with the objects listed at a destination.
+// Returns an error listing every file which is missing or differs in size.
+func Verify(d Destination, dir string) error {
+	local, err := Files(dir)
+	if err != nil {
+		return err
+	}
+	remote, err := d.List()
+	if err != nil {
+		return err
+	}
+	var problems []string
+	for path, size := range local {
+		remoteSize, ok := remote[path]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s: missing", path))
+		} else if remoteSize != size {
+			problems = append(problems, fmt.Sprintf("%s: expected %d bytes, found %d", path, size, remoteSize))
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("%d files failed verification:\n  %s", len(problems), strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// contentHeaders returns the content type and encoding of an uploaded file from its extension. Gzipped files have the
+// content type of the file they compress.
+func contentHeaders(p string) (contentType string, contentEncoding string) {
+	if strings.HasSuffix(p, compress.Extension) {
+		contentEncoding = compress.ContentEncoding
+		p = strings.TrimSuffix(p, compress.Extension)
+	}
+	ext := path.Ext(p)
+	if t, ok := compress.ContentTypes[ext]; ok {
+		return t, contentEncoding
+	}
+	return mime.TypeByExtension(ext), contentEncoding
+}
+
+// uploadFiles calls upload with the path and the slash separated relative path of every file under dir, from a number
+// of parallel workers. Returns the first error.
+func uploadFiles(dir string, workers int, upload func(file string, p string) error) error {
+	files, err := Files(dir)
+	if err != nil {
+		return err
+	}
+	if workers <= 0 {
+		workers = 1
+	}
+	paths := make(chan string)
+	errs := make(chan error, len(files))
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range paths {
+				if err := upload(filepath.Join(dir, filepath.FromSlash(p)), p); err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+	for p := range files {
+		paths <- p
+	}
+	close(paths)
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
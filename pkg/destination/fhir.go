@@ -0,0 +1,315 @@
+package destination
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"microsoft.com/divoc/pkg/fhir"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// loadOrder is the order NDJSON files are loaded in by resource type, so servers which enforce referential integrity
+// already hold the resources others refer to. Types which are not listed are loaded last.
+var loadOrder = []string{"Organization", "Location", "Practitioner", "PractitionerRole", "Medication", "Patient", "Encounter"}
+
+// sharedBundles are the prefixes of the Synthea Bundles of resources that patient Bundles refer to
+var sharedBundles = []string{"hospitalInformation", "practitionerInformation"}
+
+// maxErrors is the number of failure messages kept by a Summary
+const maxErrors = 20
+
+// FHIRServer is the REST API of a FHIR server: Bundles are posted as transactions (or batches) and the resources of
+// NDJSON files are created or updated one by one
+type FHIRServer struct {
+	Client  *fhir.Client
+	Workers int      // Bundles or resources sent in parallel
+	Summary *Summary // of the last upload
+}
+
+// NewFHIRServer returns a FHIRServer destination sending requests through a client, with a worker per request the
+// client sends in parallel
+func NewFHIRServer(client *fhir.Client) *FHIRServer {
+	return &FHIRServer{Client: client, Workers: client.REST.Concurrency()}
+}
+
+// Summary counts the resources loaded into a FHIR server by resource type
+type Summary struct {
+	Succeeded map[string]int
+	Failed    map[string]int
+	Errors    []string // the first failures
+
+	lock sync.Mutex
+}
+
+// add counts a resource of a type, keeping the message of a failure
+func (s *Summary) add(resourceType string, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err == nil {
+		s.Succeeded[resourceType]++
+		return
+	}
+	s.Failed[resourceType]++
+	if len(s.Errors) < maxErrors {
+		s.Errors = append(s.Errors, err.Error())
+	}
+}
+
+// total returns the number of succeeded and failed resources
+func (s *Summary) total() (succeeded int, failed int) {
+	for _, n := range s.Succeeded {
+		succeeded += n
+	}
+	for _, n := range s.Failed {
+		failed += n
+	}
+	return succeeded, failed
+}
+
+// String lists the succeeded and failed resources of each type, followed by the first failures
+func (s *Summary) String() string {
+	types := map[string]bool{}
+	for t := range s.Succeeded {
+		types[t] = true
+	}
+	for t := range s.Failed {
+		types[t] = true
+	}
+	var names []string
+	for t := range types {
+		names = append(names, t)
+	}
+	sort.Strings(names)
+	succeeded, failed := s.total()
+	lines := []string{fmt.Sprintf("%d resources loaded, %d failed", succeeded, failed)}
+	for _, t := range names {
+		lines = append(lines, fmt.Sprintf("  %s: %d loaded, %d failed", t, s.Succeeded[t], s.Failed[t]))
+	}
+	if len(s.Errors) > 0 {
+		lines = append(lines, "First failures:")
+		for _, e := range s.Errors {
+			lines = append(lines, "  "+e)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Report summarizes the resources loaded by the last upload
+func (s *FHIRServer) Report() string {
+	if s.Summary == nil {
+		return "nothing uploaded"
+	}
+	return s.Summary.String()
+}
+
+// fhirDir returns the directory holding the FHIR files of a Synthea output directory, or dir itself if it has none
+func fhirDir(dir string) string {
+	if info, err := os.Stat(filepath.Join(dir, fhir.Subdir)); err == nil && info.IsDir() {
+		return filepath.Join(dir, fhir.Subdir)
+	}
+	return dir
+}
+
+// Upload loads the FHIR files under the fhir directory of dir -- or dir itself if it has none -- in load order:
+// shared Bundles and NDJSON files of the types of loadOrder first, then everything else. Fails if any resource could
+// not be loaded; Summary counts the loaded and failed resources.
+func (s *FHIRServer) Upload(dir string) error {
+	s.Summary = &Summary{Succeeded: map[string]int{}, Failed: map[string]int{}}
+	files, err := fhir.ListFiles(fhirDir(dir))
+	if err != nil {
+		return err
+	}
+	phases := make([][]fhir.File, len(loadOrder)+1)
+	for _, file := range files {
+		phase := len(loadOrder)
+		if file.Format == fhir.FormatNDJSON {
+			for i, t := range loadOrder {
+				if fhir.NDJSONType(file.Path) == t {
+					phase = i
+				}
+			}
+		} else {
+			for _, prefix := range sharedBundles {
+				if strings.HasPrefix(filepath.Base(file.Path), prefix) {
+					phase = 0
+				}
+			}
+		}
+		phases[phase] = append(phases[phase], file)
+	}
+
+	for _, phase := range phases {
+		if err := s.load(phase); err != nil {
+			return err
+		}
+	}
+	succeeded, failed := s.Summary.total()
+	if failed > 0 {
+		return fmt.Errorf("%d of %d resources failed to load into %s", failed, succeeded+failed, s.Client.BaseURL)
+	}
+	return nil
+}
+
+// load sends the Bundles and NDJSON resources of files from parallel workers
+func (s *FHIRServer) load(files []fhir.File) error {
+	jobs := make(chan func())
+	var wg sync.WaitGroup
+	workers := s.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				job()
+			}
+		}()
+	}
+	defer wg.Wait()
+	defer close(jobs)
+
+	for _, file := range files {
+		file := file
+		if file.Format == fhir.FormatBundle {
+			jobs <- func() { s.loadBundle(file) }
+			continue
+		}
+		err := fhir.ReadFile(file, func(r fhir.Resource) error {
+			jobs <- func() { s.save(file, r) }
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed reading %s: %s", file.Path, err)
+		}
+	}
+	return nil
+}
+
+// save creates or updates a single resource
+func (s *FHIRServer) save(file fhir.File, r fhir.Resource) {
+	_, err := s.Client.Save(r)
+	if err != nil {
+		err = fmt.Errorf("%s: %s/%s: %s", filepath.Base(file.Path), r.ResourceType(), r.ID(), err)
+	}
+	s.Summary.add(r.ResourceType(), err)
+}
+
+// loadBundle posts a transaction or batch Bundle and counts the outcome of each entry. The resources of other
+// documents -- eg: collection Bundles -- are saved one by one.
+func (s *FHIRServer) loadBundle(file fhir.File) {
+	name := filepath.Base(file.Path)
+	data, err := ioutil.ReadFile(file.Path)
+	if err != nil {
+		s.Summary.add("Bundle", fmt.Errorf("%s: %s", name, err))
+		return
+	}
+	doc, err := fhir.Decode(data)
+	if err != nil {
+		s.Summary.add("Bundle", fmt.Errorf("%s: %s", name, err))
+		return
+	}
+	if doc.ResourceType() != "Bundle" || (doc["type"] != "transaction" && doc["type"] != "batch") {
+		err := fhir.ReadFile(file, func(r fhir.Resource) error {
+			s.save(file, r)
+			return nil
+		})
+		if err != nil {
+			s.Summary.add(doc.ResourceType(), fmt.Errorf("%s: %s", name, err))
+		}
+		return
+	}
+
+	// response entries match request entries by position, including entries without a resource; eg: a DELETE
+	var requests []fhir.Resource
+	entries, _ := doc["entry"].([]interface{})
+	for _, item := range entries {
+		entry, _ := item.(map[string]interface{})
+		r, _ := entry["resource"].(map[string]interface{})
+		if r == nil {
+			request, _ := entry["request"].(map[string]interface{})
+			url, _ := request["url"].(string)
+			r = map[string]interface{}{"resourceType": strings.SplitN(url, "/", 2)[0]}
+		}
+		requests = append(requests, r)
+	}
+	response, err := s.Client.Bundle(data)
+	if err != nil {
+		// a failed transaction loads none of its entries
+		for _, r := range requests {
+			s.Summary.add(r.ResourceType(), fmt.Errorf("%s: %s", name, err))
+		}
+		return
+	}
+	responses, _ := response["entry"].([]interface{})
+	for i, r := range requests {
+		var entryErr error
+		if i >= len(responses) {
+			entryErr = errors.New("no response entry")
+		} else {
+			entry, _ := responses[i].(map[string]interface{})
+			result, _ := entry["response"].(map[string]interface{})
+			status, _ := result["status"].(string)
+			if !strings.HasPrefix(status, "2") {
+				message := status
+				if outcome, ok := result["outcome"].(map[string]interface{}); ok {
+					if data, err := fhir.Encode(outcome); err == nil {
+						message += ": " + fhir.OutcomeMessage(data)
+					}
+				}
+				entryErr = errors.New(message)
+			}
+		}
+		if entryErr != nil {
+			entryErr = fmt.Errorf("%s: entry %d (%s/%s): %s", name, i, r.ResourceType(), r.ID(), entryErr)
+		}
+		s.Summary.add(r.ResourceType(), entryErr)
+	}
+}
+
+// List is not supported: a FHIR server holds resources, not files
+func (s *FHIRServer) List() (map[string]int64, error) {
+	return nil, errors.New("listing is not supported by FHIR server destinations")
+}
+
+// Verify that the server holds at least as many resources of each type as the FHIR files under dir
+func (s *FHIRServer) Verify(dir string) error {
+	local := map[string]int64{}
+	err := fhir.ReadDir(fhirDir(dir), func(_ fhir.File, r fhir.Resource) error {
+		local[r.ResourceType()]++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	var problems []string
+	for t, count := range local {
+		total, err := s.Client.Count(t)
+		if err != nil {
+			return err
+		}
+		if total < count {
+			problems = append(problems, fmt.Sprintf("%s: expected at least %d resources, found %d", t, count, total))
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("%d resource types failed verification:\n  %s", len(problems), strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// Delete is not supported: a FHIR server holds resources, not files
+func (s *FHIRServer) Delete([]string) error {
+	return errors.New("deleting files is not supported by FHIR server destinations")
+}
+
+// URL of a path relative to the base URL of the server
+func (s *FHIRServer) URL(p string) string {
+	return s.Client.URL(p)
+}
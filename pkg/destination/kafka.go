@@ -0,0 +1,116 @@
+package destination
+
+import (
+	"errors"
+	"fmt"
+	"microsoft.com/divoc/pkg/fhir"
+	"microsoft.com/divoc/pkg/kafka"
+	"sort"
+	"strings"
+)
+
+// ResourceTypeHeader is the message header holding the resource type of a published resource
+const ResourceTypeHeader = "fhir-resource-type"
+
+// Kafka publishes every resource to a Kafka topic per resource type, or to a single topic. Messages are keyed by the
+// id of the patient a resource is about, so the resources of a patient are published to the same partition, in
+// order; resources about no patient are keyed by their own type and id.
+type Kafka struct {
+	Writer      *kafka.Writer
+	Topic       string         // single topic of every resource; if empty, TopicPrefix + resource type
+	TopicPrefix string         // eg: fhir. for fhir.Patient, fhir.Observation...
+	Published   map[string]int // resources published by topic during the last upload
+}
+
+// NewKafka returns a Kafka destination publishing through a writer to a single topic, or to a topic per resource type
+// if topic is empty
+func NewKafka(writer *kafka.Writer, topic string, topicPrefix string) *Kafka {
+	return &Kafka{Writer: writer, Topic: topic, TopicPrefix: topicPrefix}
+}
+
+// Upload publishes every resource of the FHIR files under the fhir directory of dir -- or dir itself if it has none.
+// The resources of Bundles are published one by one.
+func (k *Kafka) Upload(dir string) error {
+	k.Published = map[string]int{}
+	err := fhir.ReadDir(fhirDir(dir), func(_ fhir.File, r fhir.Resource) error {
+		value, err := fhir.Encode(r)
+		if err != nil {
+			return err
+		}
+		topic := k.Topic
+		if topic == "" {
+			topic = k.TopicPrefix + r.ResourceType()
+		}
+		m := kafka.Message{
+			Key:     []byte(messageKey(r)),
+			Value:   value,
+			Headers: []kafka.Header{{Key: ResourceTypeHeader, Value: []byte(r.ResourceType())}},
+		}
+		if err := k.Writer.Write(topic, m); err != nil {
+			return err
+		}
+		k.Published[topic]++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return k.Writer.Flush()
+}
+
+// Report lists the number of resources published to each topic
+func (k *Kafka) Report() string {
+	var topics []string
+	total := 0
+	for topic, n := range k.Published {
+		topics = append(topics, topic)
+		total += n
+	}
+	sort.Strings(topics)
+	lines := []string{fmt.Sprintf("%d resources published", total)}
+	for _, topic := range topics {
+		lines = append(lines, fmt.Sprintf("  %s: %d", topic, k.Published[topic]))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// messageKey returns the id of the patient a resource is about, or its type and id if it is about no patient
+func messageKey(r fhir.Resource) string {
+	if r.ResourceType() == "Patient" {
+		return r.ID()
+	}
+	for _, field := range []string{"patient", "subject", "beneficiary"} {
+		element, _ := r[field].(map[string]interface{})
+		s, _ := element["reference"].(string)
+		if ref, ok := fhir.ParseReference(s); ok && ref.ID != "" && (ref.Type == "" || ref.Type == "Patient") {
+			return ref.ID
+		}
+	}
+	return r.ResourceType() + "/" + r.ID()
+}
+
+// List is not supported: published messages are not files
+func (k *Kafka) List() (map[string]int64, error) {
+	return nil, errors.New("listing is not supported by Kafka destinations")
+}
+
+// Verify is not supported: with acks, the brokers acknowledged every published batch
+func (k *Kafka) Verify(string) error {
+	return errors.New("verifying is not supported by Kafka destinations")
+}
+
+// Delete is not supported: published messages cannot be deleted
+func (k *Kafka) Delete([]string) error {
+	return errors.New("deleting is not supported by Kafka destinations")
+}
+
+// URL of the topic of a resource type; eg: kafka://fhir.Patient. The empty path is every topic; eg: kafka://fhir.*
+func (k *Kafka) URL(p string) string {
+	switch {
+	case k.Topic != "":
+		return "kafka://" + k.Topic
+	case p == "":
+		return "kafka://" + k.TopicPrefix + "*"
+	}
+	return "kafka://" + k.TopicPrefix + p
+}
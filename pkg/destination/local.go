@@ -0,0 +1,86 @@
+package destination
+
+import (
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// Local is a directory on the local filesystem -- eg: a mounted network share
+type Local struct {
+	Root string
+}
+
+// NewLocal returns a Local destination writing to root, creating it if needed
+func NewLocal(root string) (*Local, error) {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(abs, 0755); err != nil {
+		return nil, err
+	}
+	return &Local{Root: abs}, nil
+}
+
+// Upload copies every file under dir to the root, replacing existing files
+func (l *Local) Upload(dir string) error {
+	files, err := Files(dir)
+	if err != nil {
+		return err
+	}
+	for path := range files {
+		if err := copyFile(filepath.Join(dir, filepath.FromSlash(path)), filepath.Join(l.Root, filepath.FromSlash(path))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// List the files under the root
+func (l *Local) List() (map[string]int64, error) {
+	return Files(l.Root)
+}
+
+// Verify that every file under dir was copied to the root
+func (l *Local) Verify(dir string) error {
+	return Verify(l, dir)
+}
+
+// Delete the files at the provided paths
+func (l *Local) Delete(paths []string) error {
+	for _, path := range paths {
+		if err := os.Remove(filepath.Join(l.Root, filepath.FromSlash(path))); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// URL returns the file:// URL of a path
+func (l *Local) URL(path string) string {
+	u := url.URL{Scheme: "file", Path: filepath.ToSlash(filepath.Join(l.Root, filepath.FromSlash(path)))}
+	return u.String()
+}
+
+// copyFile copies src to dst, creating the parent directories of dst
+func copyFile(src string, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,73 @@
+package destination
+
+import (
+	"microsoft.com/divoc/pkg/aws/s3"
+	"strings"
+)
+
+// S3 is a bucket of Amazon S3 or an S3-compatible object storage (eg: MinIO), optionally under a key prefix
+type S3 struct {
+	Client  *s3.Client
+	Prefix  string // eg: datasets/divoc -- without leading or trailing slashes
+	Workers int    // files uploaded in parallel
+}
+
+// NewS3 returns an S3 destination uploading through a client under a key prefix
+func NewS3(client *s3.Client, prefix string) *S3 {
+	return &S3{Client: client, Prefix: strings.Trim(prefix, "/"), Workers: 4}
+}
+
+// key returns the object key of a path
+func (s *S3) key(p string) string {
+	p = strings.TrimPrefix(p, "/")
+	if s.Prefix == "" {
+		return p
+	}
+	return s.Prefix + "/" + p
+}
+
+// Upload every file under dir to the bucket. Gzipped files are uploaded with `Content-Encoding: gzip` and the
+// content type of the file they compress.
+func (s *S3) Upload(dir string) error {
+	return uploadFiles(dir, s.Workers, func(file string, p string) error {
+		contentType, contentEncoding := contentHeaders(p)
+		return s.Client.UploadFile(file, s.key(p), s3.Properties{ContentType: contentType, ContentEncoding: contentEncoding})
+	})
+}
+
+// List the objects under the prefix, keyed by their path relative to the prefix
+func (s *S3) List() (map[string]int64, error) {
+	prefix := ""
+	if s.Prefix != "" {
+		prefix = s.Prefix + "/"
+	}
+	objects, err := s.Client.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]int64, len(objects))
+	for key, size := range objects {
+		files[strings.TrimPrefix(key, prefix)] = size
+	}
+	return files, nil
+}
+
+// Verify that every file under dir was uploaded to the bucket
+func (s *S3) Verify(dir string) error {
+	return Verify(s, dir)
+}
+
+// Delete the objects at the provided paths
+func (s *S3) Delete(paths []string) error {
+	for _, p := range paths {
+		if err := s.Client.Delete(s.key(p)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// URL of the object at a path
+func (s *S3) URL(p string) string {
+	return strings.TrimSuffix(s.Client.ObjectURL(s.key(p)).String(), "/")
+}
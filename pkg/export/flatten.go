@@ -0,0 +1,78 @@
+package export
+
+import (
+	"encoding/json"
+	"microsoft.com/divoc/pkg/fhir"
+	"strconv"
+)
+
+// ResourceColumn is the column holding the complete resource as JSON
+const ResourceColumn = "resource"
+
+// skippedFields are not flattened; they remain available in the resource column
+var skippedFields = map[string]bool{
+	"text":      true, // narrative
+	"contained": true,
+}
+
+// Flatten converts a resource to a flat row of column name => value, where each value is a string, float64 or bool:
+//   - primitives become a column named by their element path joined with '_'; eg: `valueQuantity_value`
+//   - the first element of an array is flattened at the path of the array and every next one at its index; eg:
+//     `code_coding_code` and `code_coding_1_code` are the codes of the first and second coding of code
+//   - references additionally produce `<path>_reference_type` and `<path>_reference_id` columns parsed from the
+//     reference; eg: `subject_reference_id`
+//   - narrative text and contained resources are not flattened
+//   - the complete resource is kept as JSON in the `resource` column
+func Flatten(r fhir.Resource) (map[string]interface{}, error) {
+	row := map[string]interface{}{}
+	for key, value := range r {
+		if !skippedFields[key] {
+			flatten(row, key, key, value)
+		}
+	}
+	raw, err := fhir.Encode(r)
+	if err != nil {
+		return nil, err
+	}
+	row[ResourceColumn] = string(raw)
+	return row, nil
+}
+
+// flatten adds the columns of v at the provided path to row. key is the element name of v.
+func flatten(row map[string]interface{}, path string, key string, v interface{}) {
+	switch node := v.(type) {
+	case map[string]interface{}:
+		for childKey, child := range node {
+			flatten(row, path+"_"+childKey, childKey, child)
+		}
+	case []interface{}:
+		for i, child := range node {
+			if i == 0 {
+				flatten(row, path, key, child)
+			} else {
+				flatten(row, path+"_"+strconv.Itoa(i), key, child)
+			}
+		}
+	case json.Number:
+		if f, err := node.Float64(); err == nil {
+			row[path] = f
+		} else {
+			row[path] = node.String()
+		}
+	case float64, bool:
+		row[path] = node
+	case string:
+		row[path] = node
+		if key != "reference" {
+			return
+		}
+		if ref, ok := fhir.ParseReference(node); ok {
+			if ref.Type != "" {
+				row[path+"_type"] = ref.Type
+			}
+			if ref.ID != "" {
+				row[path+"_id"] = ref.ID
+			}
+		}
+	}
+}
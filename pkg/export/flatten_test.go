@@ -0,0 +1,81 @@
+package export
+
+import (
+	"microsoft.com/divoc/pkg/fhir"
+	"reflect"
+	"testing"
+)
+
+func TestFlatten(t *testing.T) {
+	resource, err := fhir.Decode([]byte(`{
+		"resourceType": "Observation",
+		"id": "obs1",
+		"text": {"status": "generated", "div": "<div>narrative</div>"},
+		"contained": [{"resourceType": "Device", "id": "d1"}],
+		"status": "final",
+		"category": [{"coding": [{"code": "vital-signs"}]}],
+		"code": {"coding": [
+			{"system": "http://loinc.org", "code": "8310-5"},
+			{"system": "http://snomed.info/sct", "code": "386725007"},
+			{"system": "urn:local", "code": "temp"}
+		]},
+		"subject": {"reference": "Patient/p1"},
+		"encounter": {"reference": "urn:uuid:e1"},
+		"performer": [{"reference": "Practitioner/pr1"}, {"reference": "Practitioner?identifier=http://hl7.org/fhir/sid/us-npi|9999"}],
+		"valueQuantity": {"value": 36.6, "unit": "Cel"},
+		"component": [
+			{"code": {"coding": [{"code": "a"}, {"code": "b"}]}, "valueBoolean": true},
+			{"code": {"coding": [{"code": "c"}]}, "valueInteger": 12345678901234567890}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	row, err := Flatten(resource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := fhir.Encode(resource)
+	expected := map[string]interface{}{
+		"resourceType":                 "Observation",
+		"id":                           "obs1",
+		"status":                       "final",
+		"category_coding_code":         "vital-signs",
+		"code_coding_system":           "http://loinc.org", // the first element is flattened at the path of its array
+		"code_coding_code":             "8310-5",
+		"code_coding_1_system":         "http://snomed.info/sct", // every next one at its index
+		"code_coding_1_code":           "386725007",
+		"code_coding_2_system":         "urn:local",
+		"code_coding_2_code":           "temp",
+		"subject_reference":            "Patient/p1",
+		"subject_reference_type":       "Patient",
+		"subject_reference_id":         "p1",
+		"encounter_reference":          "urn:uuid:e1",
+		"encounter_reference_id":       "e1", // urn:uuid references have no type
+		"performer_reference":          "Practitioner/pr1",
+		"performer_reference_type":     "Practitioner",
+		"performer_reference_id":       "pr1",
+		"performer_1_reference":        "Practitioner?identifier=http://hl7.org/fhir/sid/us-npi|9999",
+		"performer_1_reference_type":   "Practitioner", // conditional references have no id
+		"valueQuantity_value":          36.6,
+		"valueQuantity_unit":           "Cel",
+		"component_code_coding_code":   "a", // indexes of nested arrays are kept apart
+		"component_code_coding_1_code": "b",
+		"component_valueBoolean":       true,
+		"component_1_code_coding_code": "c",
+		"component_1_valueInteger":     1.2345678901234567e19,
+		ResourceColumn:                 string(raw),
+	}
+	if !reflect.DeepEqual(row, expected) {
+		for column, value := range row {
+			if !reflect.DeepEqual(value, expected[column]) {
+				t.Errorf("column %s = %#v, expected %#v", column, value, expected[column])
+			}
+		}
+		for column := range expected {
+			if _, ok := row[column]; !ok {
+				t.Errorf("column %s missing", column)
+			}
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package export
+
+import (
+	"fmt"
+	"microsoft.com/divoc/pkg/fhir"
+	"microsoft.com/divoc/pkg/parquet"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// ParquetSubdir is the directory within the Synthea output directory Parquet datasets are written to
+const ParquetSubdir = "parquet"
+
+// Parquet writes the resources of every FHIR file in dir -- Bundles or NDJSON -- to one Parquet dataset per resource
+// type in out: `<out>/<Type>/part-00000.parquet`. Resources are flattened with Flatten. Returns the number of rows
+// written per resource type.
+func Parquet(dir string, out string, codec parquet.Codec) (map[string]int, error) {
+	////////////////////////////////////////////////////////////////////////////////
+	// Pass 1: discover the columns of each resource type
+	////////////////////////////////////////////////////////////////////////////////
+	schemas := map[string]map[string]parquet.Type{}
+	err := fhir.ReadDir(dir, func(_ fhir.File, r fhir.Resource) error {
+		row, err := Flatten(r)
+		if err != nil {
+			return err
+		}
+		schema, ok := schemas[r.ResourceType()]
+		if !ok {
+			schema = map[string]parquet.Type{}
+			schemas[r.ResourceType()] = schema
+		}
+		for name, value := range row {
+			t := columnType(value)
+			if existing, ok := schema[name]; ok && existing != t {
+				t = parquet.String // conflicting types fall back to strings
+			}
+			schema[name] = t
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	////////////////////////////////////////////////////////////////////////////////
+	// Pass 2: write rows
+	////////////////////////////////////////////////////////////////////////////////
+	type dataset struct {
+		file    *os.File
+		writer  *parquet.Writer
+		columns []parquet.Column
+	}
+	datasets := map[string]*dataset{}
+	defer func() {
+		for _, ds := range datasets {
+			ds.file.Close()
+		}
+	}()
+	for t, schema := range schemas {
+		typeDir := filepath.Join(out, t)
+		if err := os.MkdirAll(typeDir, 0755); err != nil {
+			return nil, err
+		}
+		f, err := os.Create(filepath.Join(typeDir, "part-00000.parquet"))
+		if err != nil {
+			return nil, err
+		}
+		ds := &dataset{file: f, columns: columns(schema)}
+		datasets[t] = ds
+		if ds.writer, err = parquet.NewWriter(f, ds.columns, codec); err != nil {
+			return nil, err
+		}
+	}
+
+	counts := map[string]int{}
+	err = fhir.ReadDir(dir, func(_ fhir.File, r fhir.Resource) error {
+		flat, err := Flatten(r)
+		if err != nil {
+			return err
+		}
+		ds := datasets[r.ResourceType()]
+		row := make([]interface{}, len(ds.columns))
+		for i, column := range ds.columns {
+			if v, ok := flat[column.Name]; ok {
+				if column.Type == parquet.String {
+					v = fmt.Sprint(v)
+				}
+				row[i] = v
+			}
+		}
+		counts[r.ResourceType()]++
+		return ds.writer.Write(row)
+	})
+	if err != nil {
+		return nil, err
+	}
+	for t, ds := range datasets {
+		if err := ds.writer.Close(); err != nil {
+			return nil, fmt.Errorf("failed writing %s parquet dataset: %s", t, err)
+		}
+		if err := ds.file.Close(); err != nil {
+			return nil, err
+		}
+	}
+	return counts, nil
+}
+
+// columnType returns the Parquet type of a flattened value
+func columnType(v interface{}) parquet.Type {
+	switch v.(type) {
+	case float64:
+		return parquet.Double
+	case bool:
+		return parquet.Boolean
+	}
+	return parquet.String
+}
+
+// columns orders a schema: id first, resource last and everything else sorted by name
+func columns(schema map[string]parquet.Type) []parquet.Column {
+	var names []string
+	for name := range schema {
+		if name != "id" && name != ResourceColumn {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	names = append(append([]string{"id"}, names...), ResourceColumn)
+	cols := make([]parquet.Column, len(names))
+	for i, name := range names {
+		cols[i] = parquet.Column{Name: name, Type: schema[name]}
+	}
+	return cols
+}
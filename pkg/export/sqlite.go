@@ -0,0 +1,257 @@
+package export
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"microsoft.com/divoc/pkg/fhir"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// keyColumns are the columns of every FHIR resource table, in order; every other element is only available in the
+// resource column, eg: `SELECT json_extract(resource, '$.valueQuantity.value') FROM Observation`
+var keyColumns = []string{"id", "patient_id", "encounter_id", "status", "code_system", "code", "code_display", "start_date", "end_date", ResourceColumn}
+
+// codeFields are the elements checked, in order, for the code of a resource
+var codeFields = []string{"code", "medicationCodeableConcept", "vaccineCode", "type"}
+
+// startFields and endFields are the elements checked, in order, for when a resource started and ended.
+// Fields containing a '.' are elements of a Period.
+var startFields = []string{"effectiveDateTime", "effectivePeriod.start", "onsetDateTime", "period.start", "performedDateTime", "performedPeriod.start", "occurrenceDateTime", "authoredOn", "billablePeriod.start", "recordedDate", "issued", "birthDate"}
+var endFields = []string{"effectivePeriod.end", "abatementDateTime", "period.end", "performedPeriod.end", "billablePeriod.end", "deceasedDateTime"}
+
+// SQLite creates a new SQLite database at dbPath and loads every resource of the FHIR files in the Synthea output
+// directory `in` into a table per resource type, and every Synthea CSV file into a table named after the file
+// (eg: `patients`). Resource tables hold the key columns of each resource plus the complete resource as JSON.
+// Returns the number of rows loaded per table. The SQLite driver needs cgo, so it is left out of builds with
+// CGO_ENABLED=0 or the nosqlite tag; see SQLiteSupported.
+func SQLite(in string, dbPath string) (map[string]int, error) {
+	if !SQLiteSupported {
+		return nil, errors.New("built without SQLite support -- rebuild with cgo enabled and without the nosqlite tag")
+	}
+	if _, err := os.Stat(dbPath); err == nil {
+		return nil, fmt.Errorf("database %s already exists", dbPath)
+	}
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	counts := map[string]int{}
+	inserts := map[string]*sql.Stmt{}
+	defer func() {
+		for _, insert := range inserts {
+			insert.Close()
+		}
+	}()
+	err = fhir.ReadDir(filepath.Join(in, fhir.Subdir), func(_ fhir.File, r fhir.Resource) error {
+		t := r.ResourceType()
+		insert, ok := inserts[t]
+		if !ok {
+			if insert, err = createResourceTable(tx, t); err != nil {
+				return err
+			}
+			inserts[t] = insert
+		}
+		values, err := keyValues(r)
+		if err != nil {
+			return err
+		}
+		if _, err := insert.Exec(values...); err != nil {
+			return fmt.Errorf("failed inserting %s/%s: %s", t, r.ID(), err)
+		}
+		counts[t]++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	csvDir := filepath.Join(in, "csv")
+	entries, err := ioutil.ReadDir(csvDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.EqualFold(filepath.Ext(entry.Name()), ".csv") {
+			continue
+		}
+		table := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+		n, err := loadCSV(tx, table, filepath.Join(csvDir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed loading %s: %s", entry.Name(), err)
+		}
+		counts[table] = n
+	}
+
+	return counts, tx.Commit()
+}
+
+// createResourceTable creates the table and indexes of a resource type and returns its insert statement
+func createResourceTable(tx *sql.Tx, resourceType string) (*sql.Stmt, error) {
+	var defs []string
+	for _, column := range keyColumns {
+		def := quote(column) + " TEXT"
+		if column == "id" {
+			def += " PRIMARY KEY"
+		}
+		defs = append(defs, def)
+	}
+	statements := []string{
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", quote(resourceType), strings.Join(defs, ", ")),
+		fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (patient_id)", quote(resourceType+"_patient_id"), quote(resourceType)),
+		fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (code)", quote(resourceType+"_code"), quote(resourceType)),
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return nil, err
+		}
+	}
+	// shared provider resources may be written more than once; the last copy wins
+	return tx.Prepare(fmt.Sprintf("INSERT OR REPLACE INTO %s VALUES (%s)",
+		quote(resourceType), strings.TrimSuffix(strings.Repeat("?, ", len(keyColumns)), ", ")))
+}
+
+// keyValues returns the values of the key columns of a resource, in keyColumns order
+func keyValues(r fhir.Resource) ([]interface{}, error) {
+	raw, err := fhir.Encode(r)
+	if err != nil {
+		return nil, err
+	}
+	var patientID, encounterID, system, code, display interface{}
+	if r.ResourceType() == "Patient" {
+		patientID = r.ID()
+	}
+	for _, field := range []string{"subject", "patient", "beneficiary"} {
+		if id := referenceID(r[field]); patientID == nil && id != "" {
+			patientID = id
+		}
+	}
+	if id := referenceID(r["encounter"]); id != "" {
+		encounterID = id
+	}
+	for _, field := range codeFields {
+		// Encounter.type and friends are lists of CodeableConcepts
+		concept := r[field]
+		if list, ok := concept.([]interface{}); ok && len(list) > 0 {
+			concept = list[0]
+		}
+		if codings := fhir.CodeableConceptCodings(concept); len(codings) > 0 {
+			system, code, display = codings[0].System, codings[0].Code, codings[0].Display
+			break
+		}
+	}
+	return []interface{}{
+		r.ID(),
+		patientID,
+		encounterID,
+		stringOrNil(r["status"]),
+		system,
+		code,
+		display,
+		firstString(r, startFields),
+		firstString(r, endFields),
+		string(raw),
+	}, nil
+}
+
+// loadCSV loads a CSV file into a new table of TEXT columns named after the header. Returns the number of rows loaded.
+func loadCSV(tx *sql.Tx, table string, path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	reader := csv.NewReader(f)
+	header, err := reader.Read()
+	if err == io.EOF {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var defs []string
+	for _, column := range header {
+		defs = append(defs, quote(column)+" TEXT")
+	}
+	if _, err := tx.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", quote(table), strings.Join(defs, ", "))); err != nil {
+		return 0, err
+	}
+	insert, err := tx.Prepare(fmt.Sprintf("INSERT INTO %s VALUES (%s)",
+		quote(table), strings.TrimSuffix(strings.Repeat("?, ", len(header)), ", ")))
+	if err != nil {
+		return 0, err
+	}
+	defer insert.Close()
+
+	n := 0
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		values := make([]interface{}, len(record))
+		for i, v := range record {
+			if v != "" {
+				values[i] = v
+			}
+		}
+		if _, err := insert.Exec(values...); err != nil {
+			return n, err
+		}
+		n++
+	}
+}
+
+// referenceID returns the id referenced by a decoded Reference, or "" if there is none
+func referenceID(v interface{}) string {
+	obj, _ := v.(map[string]interface{})
+	s, _ := obj["reference"].(string)
+	if ref, ok := fhir.ParseReference(s); ok {
+		return ref.ID
+	}
+	return ""
+}
+
+// firstString returns the first of the fields present on r as a string, or nil
+func firstString(r fhir.Resource, fields []string) interface{} {
+	for _, field := range fields {
+		var v interface{} = map[string]interface{}(r)
+		for _, key := range strings.Split(field, ".") {
+			obj, _ := v.(map[string]interface{})
+			v = obj[key]
+		}
+		if s := stringOrNil(v); s != nil {
+			return s
+		}
+	}
+	return nil
+}
+
+// stringOrNil returns v if it is a non-empty string, else nil
+func stringOrNil(v interface{}) interface{} {
+	if s, ok := v.(string); ok && s != "" {
+		return s
+	}
+	return nil
+}
+
+// quote an SQL identifier
+func quote(identifier string) string {
+	return `"` + strings.Replace(identifier, `"`, `""`, -1) + `"`
+}
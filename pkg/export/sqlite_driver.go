@@ -0,0 +1,11 @@
+//go:build cgo && !nosqlite
+// +build cgo,!nosqlite
+
+package export
+
+import (
+	_ "github.com/mattn/go-sqlite3" // registers the sqlite3 database/sql driver
+)
+
+// SQLiteSupported is whether the sqlite3 database/sql driver is built in
+const SQLiteSupported = true
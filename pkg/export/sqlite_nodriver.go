@@ -0,0 +1,7 @@
+//go:build !cgo || nosqlite
+// +build !cgo nosqlite
+
+package export
+
+// SQLiteSupported is whether the sqlite3 database/sql driver is built in
+const SQLiteSupported = false
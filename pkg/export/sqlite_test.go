@@ -0,0 +1,84 @@
+package export
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSQLite(t *testing.T) {
+	if !SQLiteSupported {
+		t.Skip("built without SQLite support")
+	}
+	dir, err := ioutil.TempDir("", "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dbPath := filepath.Join(dir, "dataset.db")
+
+	counts, err := SQLite(filepath.Join("testdata", "dataset"), dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedCounts := map[string]int{
+		"Patient":      2,
+		"Observation":  2,
+		"Encounter":    1,
+		"Organization": 2, // rows loaded, not distinct ids
+		"patients":     2,
+		"conditions":   1,
+	}
+	if !reflect.DeepEqual(counts, expectedCounts) {
+		t.Errorf("loaded %v, expected %v", counts, expectedCounts)
+	}
+	if _, err := SQLite(filepath.Join("testdata", "dataset"), dbPath); err == nil {
+		t.Error("expected an error for an existing database")
+	}
+
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	tests := []struct {
+		query    string
+		expected []string
+	}{
+		{`SELECT id || ',' || patient_id || ',' || IFNULL(encounter_id, '') || ',' || status || ',' || code_system || ',' || code || ',' || code_display || ',' || start_date || ',' || IFNULL(end_date, '') FROM Observation ORDER BY id`, []string{
+			"obs1,p1,e1,final,http://loinc.org,8310-5,Body temperature,2020-03-01T09:30:00Z,",
+			"obs2,p2,,final,http://loinc.org,8867-4,Heart rate,2020-03-02T08:00:00Z,2020-03-02T08:05:00Z",
+		}},
+		{`SELECT resource FROM Observation WHERE id = 'obs2'`, []string{`{"code":{"coding":[{"code":"8867-4","display":"Heart rate","system":"http://loinc.org"}]},"effectivePeriod":{"end":"2020-03-02T08:05:00Z","start":"2020-03-02T08:00:00Z"},"id":"obs2","resourceType":"Observation","status":"final","subject":{"reference":"urn:uuid:p2"},"valueQuantity":{"unit":"/min","value":92}}`}},
+		{`SELECT patient_id || ',' || code || ',' || start_date || ',' || end_date FROM Encounter`, []string{"p1,185345009,2020-03-01T09:00:00Z,2020-03-01T10:00:00Z"}},
+		{`SELECT id || ',' || patient_id || ',' || start_date || ',' || IFNULL(end_date, '') FROM Patient ORDER BY id`, []string{
+			"p1,p1,1950-01-01,",
+			"p2,p2,1985-07-12,2020-04-02T10:00:00Z",
+		}},
+		{`SELECT resource FROM Organization`, []string{`{"id":"o1","name":"General Hospital, Main Campus","resourceType":"Organization"}`}}, // the last copy wins
+		{`SELECT Id || ',' || IFNULL(DEATHDATE, 'NULL') FROM patients ORDER BY Id`, []string{"p1,NULL", "p2,2020-04-02"}},
+		{`SELECT p.GENDER || ',' || c.DESCRIPTION || ',' || o.code FROM conditions c JOIN patients p ON p.Id = c.PATIENT JOIN Observation o ON o.encounter_id = c.ENCOUNTER`, []string{"F,COVID-19,8310-5"}},
+	}
+	for _, test := range tests {
+		rows, err := db.Query(test.query)
+		if err != nil {
+			t.Errorf("%s: %s", test.query, err)
+			continue
+		}
+		var got []string
+		for rows.Next() {
+			var v string
+			if err := rows.Scan(&v); err != nil {
+				t.Errorf("%s: %s", test.query, err)
+			}
+			got = append(got, v)
+		}
+		rows.Close()
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("%s returned %q, expected %q", test.query, got, test.expected)
+		}
+	}
+}
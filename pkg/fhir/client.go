@@ -0,0 +1,186 @@
+package fhir
+
+import (
+	"encoding/json"
+	"fmt"
+	"microsoft.com/divoc/pkg/rest"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Defaults of the Client settings
+const (
+	DefaultConcurrency = 8
+	DefaultMaxRetries  = rest.DefaultMaxRetries
+)
+
+// ContentType of FHIR JSON requests and responses
+const ContentType = "application/fhir+json"
+
+// Authorizer authorizes requests to a FHIR server; eg: with a bearer token
+type Authorizer interface {
+	Authorize(req *http.Request) error
+}
+
+// BearerToken authorizes requests with a static access token
+type BearerToken string
+
+// Authorize sets the bearer token of the request
+func (t BearerToken) Authorize(req *http.Request) error {
+	req.Header.Set("Authorization", "Bearer "+string(t))
+	return nil
+}
+
+// Client sends requests to the REST API of a FHIR server
+type Client struct {
+	BaseURL    *url.URL // eg: http://localhost:8080/fhir
+	Authorizer Authorizer
+	REST       *rest.Client // sends the requests of the client
+}
+
+// NewClient returns a Client for a FHIR server base URL. authorizer may be nil for servers without authentication.
+// concurrency bounds the number of requests in flight; <= 0 uses DefaultConcurrency.
+func NewClient(baseURL string, authorizer Authorizer, concurrency int) (*Client, error) {
+	u, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("FHIR server URL must be an absolute URL: %s", baseURL)
+	}
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+	c := &Client{BaseURL: u, Authorizer: authorizer}
+	c.REST = rest.NewClient(concurrency, c.prepare)
+	return c, nil
+}
+
+// URL returns the absolute URL of a path relative to the base URL; eg: `Patient/123`. Absolute URLs are returned
+// as-is.
+func (c *Client) URL(path string) string {
+	if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
+		return path
+	}
+	if path == "" {
+		return c.BaseURL.String()
+	}
+	return c.BaseURL.String() + "/" + strings.TrimPrefix(path, "/")
+}
+
+// Response of a FHIR server
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// Resource decodes the body of the response
+func (r *Response) Resource() (Resource, error) {
+	return Decode(r.Body)
+}
+
+// StatusError is an unsuccessful response of a FHIR server
+type StatusError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Body       []byte
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s %s: unexpected status %d: %s", e.Method, e.URL, e.StatusCode, OutcomeMessage(e.Body))
+}
+
+// OutcomeMessage returns the diagnostics of the issues of an OperationOutcome, or the body itself if it is not one
+func OutcomeMessage(body []byte) string {
+	outcome, err := Decode(body)
+	if err != nil || outcome.ResourceType() != "OperationOutcome" {
+		return strings.TrimSpace(string(body))
+	}
+	var messages []string
+	issues, _ := outcome["issue"].([]interface{})
+	for _, item := range issues {
+		issue, _ := item.(map[string]interface{})
+		message, _ := issue["diagnostics"].(string)
+		if message == "" {
+			details, _ := issue["details"].(map[string]interface{})
+			message, _ = details["text"].(string)
+		}
+		if message == "" {
+			message, _ = issue["code"].(string)
+		}
+		messages = append(messages, message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Do sends a request to a path relative to the base URL (or an absolute URL), retrying failures as rest.Client.Do
+// does: POSTs, like transaction Bundles or resources without an id, are only retried when the server throttles them
+// with a Retry-After header, so a request the server committed before failing is never loaded twice. Returns a
+// StatusError for responses other than 2xx.
+func (c *Client) Do(method string, path string, header http.Header, body []byte) (*Response, error) {
+	target := c.URL(path)
+	restResp, err := c.REST.Do(method, target, header, body)
+	if restResp == nil {
+		return nil, err
+	}
+	resp := &Response{StatusCode: restResp.StatusCode, Header: restResp.Header, Body: restResp.Body}
+	if _, ok := err.(*rest.StatusError); ok {
+		err = &StatusError{Method: method, URL: target, StatusCode: resp.StatusCode, Body: resp.Body}
+	}
+	return resp, err
+}
+
+// prepare sets the content negotiation headers and authorizes every attempt of a request
+func (c *Client) prepare(req *http.Request, body []byte) error {
+	if len(body) > 0 && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", ContentType)
+	}
+	if req.Header.Get("Accept") == "" {
+		req.Header.Set("Accept", ContentType)
+	}
+	if c.Authorizer != nil {
+		return c.Authorizer.Authorize(req)
+	}
+	return nil
+}
+
+// Save creates or updates a resource: PUT `Type/id` if the resource has an id, otherwise POST `Type`
+func (c *Client) Save(r Resource) (*Response, error) {
+	data, err := Encode(r)
+	if err != nil {
+		return nil, err
+	}
+	if id := r.ID(); id != "" {
+		return c.Do(http.MethodPut, r.ResourceType()+"/"+url.PathEscape(id), nil, data)
+	}
+	return c.Do(http.MethodPost, r.ResourceType(), nil, data)
+}
+
+// Bundle posts a transaction or batch Bundle to the base URL and returns the response Bundle
+func (c *Client) Bundle(data []byte) (Resource, error) {
+	resp, err := c.Do(http.MethodPost, "", nil, data)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Resource()
+}
+
+// Count returns the total number of resources of a type on the server
+func (c *Client) Count(resourceType string) (int64, error) {
+	resp, err := c.Do(http.MethodGet, resourceType+"?_summary=count", nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	bundle, err := resp.Resource()
+	if err != nil {
+		return 0, err
+	}
+	total, ok := bundle["total"].(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("search of %s returned no total", resourceType)
+	}
+	return total.Int64()
+}
@@ -0,0 +1,364 @@
+package fhir
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Subdir is the directory within the Synthea output directory which FHIR R4 files are written to
+const Subdir = "fhir"
+
+// Format of a FHIR file on disk
+type Format int
+
+const (
+	FormatBundle Format = iota // a single JSON document -- usually a Bundle
+	FormatNDJSON               // newline delimited resources -- Synthea bulk data output
+)
+
+// File is a FHIR file within a dataset
+type File struct {
+	Path   string
+	Format Format
+}
+
+// FormatOf returns the Format of a file based on its extension.
+// Returns false if the file is not a FHIR file.
+func FormatOf(path string) (Format, bool) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".ndjson":
+		return FormatNDJSON, true
+	case ".json":
+		return FormatBundle, true
+	}
+	return 0, false
+}
+
+// ListFiles recursively lists every FHIR file in dir, sorted by path
+func ListFiles(dir string) (files []File, err error) {
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if format, ok := FormatOf(path); ok {
+			files = append(files, File{Path: path, Format: format})
+		}
+		return nil
+	})
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files, err
+}
+
+// NDJSONType returns the resource type an NDJSON file holds based on its name;
+// eg: `Observation.ndjson` => `Observation`
+func NDJSONType(path string) string {
+	base := filepath.Base(path)
+	return base[:strings.Index(base+".", ".")]
+}
+
+// Decode a single JSON resource
+func Decode(data []byte) (Resource, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var r Resource
+	if err := decoder.Decode(&r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Encode a resource to compact JSON without a trailing newline
+func Encode(r Resource) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false) // narrative xhtml must not be escaped
+	if err := encoder.Encode(r); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
+
+// ReadFile calls fn for every resource in the file.
+// For Bundles, fn is called with each entry resource rather than the Bundle itself.
+func ReadFile(file File, fn func(Resource) error) error {
+	if file.Format == FormatNDJSON {
+		return readNDJSON(file.Path, fn)
+	}
+	doc, err := ReadJSONFile(file.Path)
+	if err != nil {
+		return err
+	}
+	if doc.ResourceType() != "Bundle" {
+		return fn(doc)
+	}
+	for _, r := range BundleResources(doc) {
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadDir calls fn for every resource of every FHIR file in dir
+func ReadDir(dir string, fn func(File, Resource) error) error {
+	files, err := ListFiles(dir)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := ReadFile(file, func(r Resource) error { return fn(file, r) }); err != nil {
+			return fmt.Errorf("failed reading %s: %s", file.Path, err)
+		}
+	}
+	return nil
+}
+
+// BundleResources returns the resources of every entry in a Bundle
+func BundleResources(bundle Resource) (resources []Resource) {
+	entries, _ := bundle["entry"].([]interface{})
+	for _, item := range entries {
+		entry, _ := item.(map[string]interface{})
+		if r, ok := entry["resource"].(map[string]interface{}); ok {
+			resources = append(resources, r)
+		}
+	}
+	return resources
+}
+
+// CopyFile streams every resource in src through fn and writes the results to dst in the same format.
+// If fn returns a nil Resource, the resource is dropped. If every resource is dropped, dst is not written and
+// false is returned. src and dst may be the same path; the file is then replaced once fn has seen every resource.
+func CopyFile(src File, dst string, fn func(Resource) (Resource, error)) (written bool, err error) {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return false, err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(dst), "."+filepath.Base(dst))
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp.Name())
+
+	var count int
+	if src.Format == FormatNDJSON {
+		count, err = copyNDJSON(src.Path, tmp, fn)
+	} else {
+		count, err = copyJSON(src.Path, tmp, fn)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil || count == 0 {
+		return false, err
+	}
+	return true, os.Rename(tmp.Name(), dst)
+}
+
+// RewriteFile streams every resource in the file through fn, replacing the file with the results.
+// The file is removed if every resource is dropped.
+func RewriteFile(file File, fn func(Resource) (Resource, error)) error {
+	written, err := CopyFile(file, file.Path, fn)
+	if err != nil {
+		return err
+	}
+	if !written {
+		return os.Remove(file.Path)
+	}
+	return nil
+}
+
+// NDJSONWriter writes resources as newline delimited JSON
+type NDJSONWriter struct {
+	w     *bufio.Writer
+	Count int // number of resources written
+}
+
+// NewNDJSONWriter returns an NDJSONWriter writing to w. Flush must be called once done.
+func NewNDJSONWriter(w io.Writer) *NDJSONWriter {
+	return &NDJSONWriter{w: bufio.NewWriter(w)}
+}
+
+// Write a resource as a single line
+func (nw *NDJSONWriter) Write(r Resource) error {
+	line, err := Encode(r)
+	if err != nil {
+		return err
+	}
+	if _, err := nw.w.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	nw.Count++
+	return nil
+}
+
+// Flush any buffered data to the underlying writer
+func (nw *NDJSONWriter) Flush() error {
+	return nw.w.Flush()
+}
+
+// ReadJSONFile decodes a single JSON document -- usually a Bundle -- from path
+func ReadJSONFile(path string) (Resource, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Decode(data)
+}
+
+// WriteJSONFile writes a single resource (usually a Bundle) to path as indented JSON
+func WriteJSONFile(path string, r Resource) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeJSON(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// NewBundle returns an empty Bundle of the provided type (eg: "transaction", "batch", "collection")
+func NewBundle(bundleType string) Resource {
+	return Resource{
+		"resourceType": "Bundle",
+		"type":         bundleType,
+		"entry":        []interface{}{},
+	}
+}
+
+// AddEntry appends r to the bundle. For transaction and batch Bundles, a request with the provided method
+// (POST or PUT) is added to the entry; PUT requests target the id of the resource.
+func AddEntry(bundle Resource, r Resource, method string) {
+	entry := map[string]interface{}{
+		"fullUrl":  "urn:uuid:" + r.ID(),
+		"resource": map[string]interface{}(r),
+	}
+	if t := bundle["type"]; t == "transaction" || t == "batch" {
+		url := r.ResourceType()
+		if method == "PUT" {
+			url += "/" + r.ID()
+		}
+		entry["request"] = map[string]interface{}{
+			"method": method,
+			"url":    url,
+		}
+	}
+	entries, _ := bundle["entry"].([]interface{})
+	bundle["entry"] = append(entries, entry)
+}
+
+// writeJSON encodes r to w as indented JSON
+func writeJSON(w io.Writer, r Resource) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// readNDJSON calls fn for each line of an NDJSON file
+func readNDJSON(path string, fn func(Resource) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	reader := bufio.NewReader(f)
+	for lineNo := 1; ; lineNo++ {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			r, decodeErr := Decode(line)
+			if decodeErr != nil {
+				return fmt.Errorf("line %d: %s", lineNo, decodeErr)
+			}
+			if err := fn(r); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// copyNDJSON streams the NDJSON file at src through fn into w
+func copyNDJSON(src string, w io.Writer, fn func(Resource) (Resource, error)) (int, error) {
+	writer := NewNDJSONWriter(w)
+	err := readNDJSON(src, func(r Resource) error {
+		out, err := fn(r)
+		if err != nil || out == nil {
+			return err
+		}
+		return writer.Write(out)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return writer.Count, writer.Flush()
+}
+
+// copyJSON passes the JSON document at src -- or each entry if it is a Bundle -- through fn and writes the result to w.
+// Entry fullUrls in the `urn:uuid:<id>` form and PUT request urls (`<Type>/<id>`) are kept in sync with the id of
+// their resource.
+func copyJSON(src string, w io.Writer, fn func(Resource) (Resource, error)) (int, error) {
+	doc, err := ReadJSONFile(src)
+	if err != nil {
+		return 0, err
+	}
+	if doc.ResourceType() != "Bundle" {
+		out, err := fn(doc)
+		if err != nil || out == nil {
+			return 0, err
+		}
+		return 1, writeJSON(w, out)
+	}
+
+	entries, _ := doc["entry"].([]interface{})
+	kept := make([]interface{}, 0, len(entries))
+	for _, item := range entries {
+		entry, _ := item.(map[string]interface{})
+		r, ok := entry["resource"].(map[string]interface{})
+		if !ok {
+			kept = append(kept, item)
+			continue
+		}
+		oldID := Resource(r).ID()
+		out, err := fn(r)
+		if err != nil {
+			return 0, err
+		}
+		if out == nil {
+			continue
+		}
+		if fullURL, _ := entry["fullUrl"].(string); fullURL == "urn:uuid:"+oldID {
+			entry["fullUrl"] = "urn:uuid:" + out.ID()
+		}
+		request, _ := entry["request"].(map[string]interface{})
+		if url, _ := request["url"].(string); url == out.ResourceType()+"/"+oldID {
+			request["url"] = out.ResourceType() + "/" + out.ID()
+		}
+		entry["resource"] = map[string]interface{}(out)
+		kept = append(kept, entry)
+	}
+	if len(kept) == 0 {
+		return 0, nil
+	}
+	doc["entry"] = kept
+	return len(kept), writeJSON(w, doc)
+}
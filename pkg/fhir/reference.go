@@ -0,0 +1,114 @@
+package fhir
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Reference is a parsed FHIR Reference.reference string.
+// Synthea emits three forms which are all supported:
+//   - `urn:uuid:<id>` within transaction Bundles
+//   - `<Type>/<id>` (optionally as an absolute URL) in bulk NDJSON
+//   - `<Type>?identifier=<system>|<value>` conditional references to shared provider resources
+type Reference struct {
+	Type       string     // resource type -- empty if it can not be determined from the reference
+	ID         string     // logical id -- empty for conditional references
+	Identifier Identifier // set for conditional references
+}
+
+// ParseReference parses a reference string.
+// Returns false if the reference is empty, contained (`#id`) or otherwise not understood.
+func ParseReference(ref string) (Reference, bool) {
+	switch {
+	case ref == "" || strings.HasPrefix(ref, "#"):
+		return Reference{}, false
+	case strings.HasPrefix(ref, "urn:uuid:"):
+		return Reference{ID: strings.TrimPrefix(ref, "urn:uuid:")}, true
+	case strings.Contains(ref, "?"):
+		i := strings.Index(ref, "?")
+		query, err := url.ParseQuery(ref[i+1:])
+		if err != nil || query.Get("identifier") == "" {
+			return Reference{}, false
+		}
+		token := ParseToken(query.Get("identifier"))
+		return Reference{
+			Type:       lastSegment(ref[:i]),
+			Identifier: Identifier{System: token.System, Value: token.Code},
+		}, true
+	}
+
+	// <Type>/<id>, possibly prefixed by a server base URL and/or suffixed by _history/<version>
+	segments := strings.Split(strings.TrimSuffix(ref, "/"), "/")
+	if len(segments) >= 4 && segments[len(segments)-2] == "_history" {
+		segments = segments[:len(segments)-2]
+	}
+	if len(segments) < 2 {
+		return Reference{ID: ref}, true
+	}
+	return Reference{Type: segments[len(segments)-2], ID: segments[len(segments)-1]}, true
+}
+
+// String returns the reference in its relative `<Type>/<id>` form, or as a conditional reference
+func (ref Reference) String() string {
+	if ref.ID == "" {
+		return ref.Type + "?identifier=" + ref.Identifier.System + "|" + ref.Identifier.Value
+	}
+	if ref.Type == "" {
+		return "urn:uuid:" + ref.ID
+	}
+	return ref.Type + "/" + ref.ID
+}
+
+// Matches reports whether the reference points at the provided resource
+func (ref Reference) Matches(r Resource) bool {
+	if ref.Type != "" && ref.Type != r.ResourceType() {
+		return false
+	}
+	if ref.ID != "" {
+		return ref.ID == r.ID()
+	}
+	for _, id := range r.Identifiers() {
+		if id == ref.Identifier {
+			return true
+		}
+	}
+	return false
+}
+
+// VisitReferences walks v and calls fn with every Reference.reference string found.
+// The value returned by fn replaces the reference, so fn can be used to rewrite references in place;
+// return the argument unchanged to leave a reference as-is.
+func VisitReferences(v interface{}, fn func(ref string) string) {
+	switch node := v.(type) {
+	case Resource:
+		VisitReferences(map[string]interface{}(node), fn)
+	case map[string]interface{}:
+		for key, child := range node {
+			if s, ok := child.(string); ok && key == "reference" {
+				node[key] = fn(s)
+				continue
+			}
+			VisitReferences(child, fn)
+		}
+	case []interface{}:
+		for _, child := range node {
+			VisitReferences(child, fn)
+		}
+	}
+}
+
+// References returns every parseable reference contained in v
+func References(v interface{}) (refs []Reference) {
+	VisitReferences(v, func(s string) string {
+		if ref, ok := ParseReference(s); ok {
+			refs = append(refs, ref)
+		}
+		return s
+	})
+	return refs
+}
+
+// lastSegment returns the final path segment of s
+func lastSegment(s string) string {
+	return s[strings.LastIndex(s, "/")+1:]
+}
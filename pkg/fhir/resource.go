@@ -0,0 +1,131 @@
+package fhir
+
+import (
+	"strings"
+)
+
+// Resource is a generic FHIR resource as decoded from JSON.
+// Numbers are kept as json.Number so decimal precision survives a round trip.
+type Resource map[string]interface{}
+
+// Identifier is a FHIR Identifier reduced to the fields used for matching
+type Identifier struct {
+	System string
+	Value  string
+}
+
+// Coding is a FHIR Coding reduced to the fields used for matching
+type Coding struct {
+	System  string
+	Code    string
+	Display string
+}
+
+// ResourceType returns the resourceType of the resource
+func (r Resource) ResourceType() string {
+	s, _ := r["resourceType"].(string)
+	return s
+}
+
+// ID returns the logical id of the resource
+func (r Resource) ID() string {
+	s, _ := r["id"].(string)
+	return s
+}
+
+// SetID sets the logical id of the resource
+func (r Resource) SetID(id string) {
+	r["id"] = id
+}
+
+// Identifiers returns every identifier on the resource which has a value
+func (r Resource) Identifiers() (identifiers []Identifier) {
+	list, _ := r["identifier"].([]interface{})
+	for _, item := range list {
+		obj, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		system, _ := obj["system"].(string)
+		value, _ := obj["value"].(string)
+		if value != "" {
+			identifiers = append(identifiers, Identifier{System: system, Value: value})
+		}
+	}
+	return identifiers
+}
+
+// Codings returns the codings of the CodeableConcept stored at the provided key
+func (r Resource) Codings(key string) []Coding {
+	return CodeableConceptCodings(r[key])
+}
+
+// CodeableConceptCodings returns the codings of a decoded CodeableConcept.
+// Returns nil if v is not a CodeableConcept.
+func CodeableConceptCodings(v interface{}) (codings []Coding) {
+	concept, ok := v.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	list, _ := concept["coding"].([]interface{})
+	for _, item := range list {
+		obj, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		system, _ := obj["system"].(string)
+		code, _ := obj["code"].(string)
+		display, _ := obj["display"].(string)
+		codings = append(codings, Coding{System: system, Code: code, Display: display})
+	}
+	return codings
+}
+
+// Token is a FHIR search token in the form `[system]|[code]`, `|[code]` or `[code]`
+type Token struct {
+	System    string
+	Code      string
+	HasSystem bool // true if the token contained a '|' -- an empty System then means "no system"
+}
+
+// ParseToken parses a FHIR search token
+func ParseToken(s string) Token {
+	if i := strings.Index(s, "|"); i >= 0 {
+		return Token{System: s[:i], Code: s[i+1:], HasSystem: true}
+	}
+	return Token{Code: s}
+}
+
+// String returns the token in FHIR search syntax
+func (t Token) String() string {
+	if t.HasSystem {
+		return t.System + "|" + t.Code
+	}
+	return t.Code
+}
+
+// MatchesCoding reports whether the coding satisfies the token
+func (t Token) MatchesCoding(c Coding) bool {
+	if t.HasSystem && t.System != c.System {
+		return false
+	}
+	return t.Code == "" || t.Code == c.Code
+}
+
+// MatchesIdentifier reports whether the identifier satisfies the token
+func (t Token) MatchesIdentifier(id Identifier) bool {
+	if t.HasSystem && t.System != id.System {
+		return false
+	}
+	return t.Code == "" || t.Code == id.Value
+}
+
+// MatchesAny reports whether any of the codings satisfy the token
+func (t Token) MatchesAny(codings []Coding) bool {
+	for _, c := range codings {
+		if t.MatchesCoding(c) {
+			return true
+		}
+	}
+	return false
+}
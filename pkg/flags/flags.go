@@ -0,0 +1,39 @@
+package flags
+
+import (
+	"strconv"
+	"strings"
+)
+
+// StringList is a flag.Value which can be provided multiple times -- each occurrence is appended to the list
+type StringList []string
+
+func (l *StringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *StringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// OptionalInt is a flag.Value for an int which may not be provided
+type OptionalInt struct {
+	Value *int // nil until set
+}
+
+func (o *OptionalInt) String() string {
+	if o.Value == nil {
+		return ""
+	}
+	return strconv.Itoa(*o.Value)
+}
+
+func (o *OptionalInt) Set(value string) error {
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	o.Value = &i
+	return nil
+}
@@ -8,9 +8,10 @@ import (
 )
 
 type CloneOptions struct {
-	Repo  string
-	Dir   string
-	Depth int
+	Repo   string
+	Dir    string
+	Depth  int
+	Branch string // branch or tag to check out -- the default branch if empty
 }
 
 // Clone a git repository based on the provided CloneOptions
@@ -28,6 +29,9 @@ func Clone(options CloneOptions) (err error) {
 	if options.Depth != 0 {
 		cloneCmd.Args = append(cloneCmd.Args, "--depth", fmt.Sprintf("%d", options.Depth))
 	}
+	if options.Branch != "" {
+		cloneCmd.Args = append(cloneCmd.Args, "--branch", options.Branch)
+	}
 
 	// Execute command
 	cloneCmd.Stdout = os.Stdout
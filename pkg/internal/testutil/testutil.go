@@ -0,0 +1,21 @@
+// Package testutil holds helpers shared by the tests of several packages
+package testutil
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// WriteFile writes size bytes of a repeating pattern to a file of dir and returns its path and content
+func WriteFile(t *testing.T, dir string, name string, size int) (string, []byte) {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path, data
+}
@@ -0,0 +1,137 @@
+package kafka
+
+import (
+	"bytes"
+	"compress/gzip"
+	"hash/crc32"
+	"time"
+)
+
+// Compression codecs of record batches
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+)
+
+// codecs are the attribute bits of the compression codecs
+var codecs = map[string]int16{CompressionNone: 0, CompressionGzip: 1}
+
+// Header of a message
+type Header struct {
+	Key   string
+	Value []byte
+}
+
+// Message published to a topic
+type Message struct {
+	Key     []byte // messages with the same key are published to the same partition, in order
+	Value   []byte
+	Headers []Header
+}
+
+// size is an estimate of the bytes a message takes in a record batch
+func (m Message) size() int {
+	n := len(m.Key) + len(m.Value) + 16
+	for _, h := range m.Headers {
+		n += len(h.Key) + len(h.Value) + 4
+	}
+	return n
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// encodeRecordBatch encodes messages as a v2 record batch: https://kafka.apache.org/documentation/#recordbatch
+func encodeRecordBatch(messages []Message, compression string, now time.Time) ([]byte, error) {
+	var records encoder
+	for i, m := range messages {
+		var record encoder
+		record.int8(0)   // attributes
+		record.varint(0) // timestamp delta
+		record.varint(int64(i))
+		record.varintBytes(m.Key)
+		record.varintBytes(m.Value)
+		record.varint(int64(len(m.Headers)))
+		for _, h := range m.Headers {
+			record.varintBytes([]byte(h.Key))
+			record.varintBytes(h.Value)
+		}
+		records.varint(int64(record.Len()))
+		records.Write(record.Bytes())
+	}
+
+	payload := records.Bytes()
+	if compression == CompressionGzip {
+		var compressed bytes.Buffer
+		w := gzip.NewWriter(&compressed)
+		if _, err := w.Write(payload); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		payload = compressed.Bytes()
+	}
+
+	// the CRC covers everything from the attributes to the end of the batch
+	timestamp := now.UnixNano() / int64(time.Millisecond)
+	var body encoder
+	body.int16(codecs[compression])
+	body.int32(int32(len(messages) - 1)) // last offset delta
+	body.int64(timestamp)                // first timestamp
+	body.int64(timestamp)                // max timestamp
+	body.int64(-1)                       // producer id
+	body.int16(-1)                       // producer epoch
+	body.int32(-1)                       // base sequence
+	body.int32(int32(len(messages)))
+	body.Write(payload)
+
+	var batch encoder
+	batch.int64(0)                             // base offset -- assigned by the broker
+	batch.int32(int32(4 + 1 + 4 + body.Len())) // batch length: leader epoch, magic, crc and body
+	batch.int32(-1)                            // partition leader epoch
+	batch.int8(2)                              // magic
+	batch.int32(int32(crc32.Checksum(body.Bytes(), castagnoli)))
+	batch.Write(body.Bytes())
+	return batch.Bytes(), nil
+}
+
+// partition returns the partition of a key among n partitions, matching the default partitioner of the Java client so
+// consumers and other producers agree on where a key lives
+func partition(key []byte, n int) int {
+	return int(murmur2(key)&0x7fffffff) % n
+}
+
+// murmur2 is the hash of the default partitioner of the Java client
+func murmur2(data []byte) uint32 {
+	const (
+		seed = 0x9747b28c
+		m    = 0x5bd1e995
+		r    = 24
+	)
+	length := len(data)
+	h := uint32(seed) ^ uint32(length)
+	for i := 0; i+4 <= length; i += 4 {
+		k := uint32(data[i]) | uint32(data[i+1])<<8 | uint32(data[i+2])<<16 | uint32(data[i+3])<<24
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+	tail := length &^ 3
+	switch length % 4 {
+	case 3:
+		h ^= uint32(data[tail+2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(data[tail+1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(data[tail])
+		h *= m
+	}
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return h
+}
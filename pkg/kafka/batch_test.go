@@ -0,0 +1,117 @@
+package kafka
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/hex"
+	"hash/crc32"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The vectors of the murmur2 test of the Java client: https://github.com/apache/kafka/blob/trunk/clients/src/test/java/org/apache/kafka/common/utils/UtilsTest.java
+func TestMurmur2(t *testing.T) {
+	tests := map[string]int32{
+		"21":                         -973932308,
+		"foobar":                     -790332482,
+		"a-little-bit-long-string":   -985981536,
+		"a-little-bit-longer-string": -1486304829,
+		"lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8": -58897971,
+		"abc": 479470107,
+	}
+	for key, expected := range tests {
+		if got := int32(murmur2([]byte(key))); got != expected {
+			t.Errorf("murmur2(%q) = %d, expected %d", key, got, expected)
+		}
+	}
+}
+
+func TestPartition(t *testing.T) {
+	// the Java client masks the sign bit of the hash rather than taking its absolute value
+	if got := partition([]byte("21"), 10); got != int(-973932308&0x7fffffff)%10 {
+		t.Errorf("partition of a key with a negative hash %d", got)
+	}
+	for _, key := range []string{"", "a", "ab", "abc", "abcd", "patient-1"} {
+		if got := partition([]byte(key), 7); got < 0 || got >= 7 {
+			t.Errorf("partition(%q) = %d out of 7 partitions", key, got)
+		}
+	}
+}
+
+// The CRC32C check value of https://reveng.sourceforge.io/crc-catalogue/17plus.htm#crc.cat.crc-32c
+func TestCastagnoli(t *testing.T) {
+	if got := crc32.Checksum([]byte("123456789"), castagnoli); got != 0xe3069283 {
+		t.Errorf("CRC32C %x", got)
+	}
+}
+
+var batchTime = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+var batchMessages = []Message{
+	{Key: []byte("k"), Value: []byte("v"), Headers: []Header{{Key: "h", Value: []byte("1")}}},
+	{Value: []byte("w")},
+}
+
+func TestEncodeRecordBatch(t *testing.T) {
+	expected := strings.Join([]string{
+		"0000000000000000", // base offset
+		"00000046",         // batch length
+		"ffffffff",         // partition leader epoch
+		"02",               // magic
+		"7651d785",         // CRC32C of the attributes onwards
+		"0000",             // attributes
+		"00000001",         // last offset delta
+		"0000016f5e66e800", // first timestamp
+		"0000016f5e66e800", // max timestamp
+		"ffffffffffffffff", // producer id
+		"ffff",             // producer epoch
+		"ffffffff",         // base sequence
+		"00000002",         // records
+		// length 12, attributes, timestamp delta 0, offset delta 0, key "k", value "v", header h=1
+		"18", "00", "00", "00", "026b", "0276", "02", "0268", "0231",
+		// length 7, attributes, timestamp delta 0, offset delta 1, null key, value "w", no headers
+		"0e", "00", "00", "02", "01", "0277", "00",
+	}, "")
+	batch, err := encodeRecordBatch(batchMessages, CompressionNone, batchTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hex.EncodeToString(batch); got != expected {
+		t.Errorf("record batch\n  %s\nexpected\n  %s", got, expected)
+	}
+}
+
+func TestEncodeRecordBatchGzip(t *testing.T) {
+	plain, err := encodeRecordBatch(batchMessages, CompressionNone, batchTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch, err := encodeRecordBatch(batchMessages, CompressionGzip, batchTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const recordsOffset = 61 // the records follow the 61 bytes of the batch header
+	if attributes := binary.BigEndian.Uint16(batch[21:]); attributes != 1 {
+		t.Errorf("attributes %d, expected the gzip codec", attributes)
+	}
+	if length := binary.BigEndian.Uint32(batch[8:]); int(length) != len(batch)-12 {
+		t.Errorf("batch length %d of a %d bytes batch", length, len(batch))
+	}
+	if crc := binary.BigEndian.Uint32(batch[17:]); crc != crc32.Checksum(batch[21:], castagnoli) {
+		t.Errorf("CRC %x does not match the batch", crc)
+	}
+	r, err := gzip.NewReader(bytes.NewReader(batch[recordsOffset:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(records, plain[recordsOffset:]) {
+		t.Error("compressed records do not match the uncompressed batch")
+	}
+}
@@ -0,0 +1,84 @@
+package kafka
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// conn is a connection to a broker. Requests on a connection are sent one at a time.
+type conn struct {
+	lock          sync.Mutex
+	addr          string
+	nc            net.Conn
+	reader        *bufio.Reader
+	clientID      string
+	correlationID int32
+	timeout       time.Duration
+}
+
+// dial connects to a broker, over TLS if tlsConfig is not nil
+func dial(addr string, tlsConfig *tls.Config, clientID string, timeout time.Duration) (*conn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	var nc net.Conn
+	var err error
+	if tlsConfig != nil {
+		nc, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		nc, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &conn{addr: addr, nc: nc, reader: bufio.NewReader(nc), clientID: clientID, timeout: timeout}, nil
+}
+
+// roundTrip sends a request and returns the body of its response. Produce requests with acks=0 have no response;
+// nil is then returned once the request is sent.
+func (c *conn) roundTrip(apiKey int16, apiVersion int16, body []byte, expectResponse bool) ([]byte, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.correlationID++
+	var req encoder
+	req.int32(0) // size, set below
+	req.int16(apiKey)
+	req.int16(apiVersion)
+	req.int32(c.correlationID)
+	req.nullableString(&c.clientID)
+	req.Write(body)
+	data := req.Bytes()
+	binary.BigEndian.PutUint32(data, uint32(len(data)-4))
+
+	if err := c.nc.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return nil, err
+	}
+	if _, err := c.nc.Write(data); err != nil {
+		return nil, err
+	}
+	if !expectResponse {
+		return nil, nil
+	}
+	var size [4]byte
+	if _, err := io.ReadFull(c.reader, size[:]); err != nil {
+		return nil, err
+	}
+	resp := make([]byte, binary.BigEndian.Uint32(size[:]))
+	if _, err := io.ReadFull(c.reader, resp); err != nil {
+		return nil, err
+	}
+	d := decoder{data: resp}
+	if id := d.int32(); d.err != nil || id != c.correlationID {
+		return nil, fmt.Errorf("kafka response from %s has correlation id %d, expected %d", c.addr, id, c.correlationID)
+	}
+	return d.data, nil
+}
+
+// close the connection
+func (c *conn) close() error {
+	return c.nc.Close()
+}
@@ -0,0 +1,46 @@
+package kafka
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestPublishToBroker publishes to a real cluster, eg: started with
+// docker run -d -p 9092:9092 apache/kafka
+// KAFKA_BROKERS=localhost:9092 go test ./pkg/kafka -run TestPublishToBroker
+// The brokers must auto-create topics.
+func TestPublishToBroker(t *testing.T) {
+	brokers := os.Getenv("KAFKA_BROKERS")
+	if brokers == "" {
+		t.Skip("KAFKA_BROKERS not set")
+	}
+	for _, compression := range []string{CompressionNone, CompressionGzip} {
+		w, err := NewWriter(Config{
+			Brokers:     strings.Split(brokers, ","),
+			Acks:        AcksAll,
+			Compression: compression,
+			BatchBytes:  10000,
+			MaxRetries:  DefaultMaxRetries,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		topic := fmt.Sprintf("divoc-test-%s-%d", compression, time.Now().UnixNano())
+		for i := 0; i < 1000; i++ {
+			m := Message{
+				Key:     []byte(fmt.Sprintf("patient-%d", i%50)),
+				Value:   []byte(fmt.Sprintf(`{"resourceType":"Patient","id":"%d"}`, i)),
+				Headers: []Header{{Key: "resourceType", Value: []byte("Patient")}},
+			}
+			if err := w.Write(topic, m); err != nil {
+				t.Fatalf("%s: %s", compression, err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Errorf("%s: %s", compression, err)
+		}
+	}
+}
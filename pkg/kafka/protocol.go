@@ -0,0 +1,179 @@
+package kafka
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// API keys and versions of the requests sent by the Writer. Non-flexible versions are used so no tagged fields are
+// needed; both are supported from Kafka 0.11 onwards.
+const (
+	apiProduce  = 0
+	apiMetadata = 3
+
+	produceVersion  = 3
+	metadataVersion = 1
+)
+
+// Error is an error code returned by a broker: https://kafka.apache.org/protocol#protocol_error_codes
+type Error int16
+
+// Error codes handled by the Writer
+const (
+	UnknownTopicOrPartition Error = 3
+	LeaderNotAvailable      Error = 5
+	NotLeaderForPartition   Error = 6
+	RequestTimedOut         Error = 7
+	MessageTooLarge         Error = 10
+	NetworkException        Error = 13
+	NotEnoughReplicas       Error = 19
+	NotEnoughReplicasAfter  Error = 20
+)
+
+var errorNames = map[Error]string{
+	-1:                      "UNKNOWN_SERVER_ERROR",
+	2:                       "CORRUPT_MESSAGE",
+	UnknownTopicOrPartition: "UNKNOWN_TOPIC_OR_PARTITION",
+	LeaderNotAvailable:      "LEADER_NOT_AVAILABLE",
+	NotLeaderForPartition:   "NOT_LEADER_OR_FOLLOWER",
+	RequestTimedOut:         "REQUEST_TIMED_OUT",
+	MessageTooLarge:         "MESSAGE_TOO_LARGE",
+	NetworkException:        "NETWORK_EXCEPTION",
+	17:                      "INVALID_TOPIC_EXCEPTION",
+	18:                      "RECORD_LIST_TOO_LARGE",
+	NotEnoughReplicas:       "NOT_ENOUGH_REPLICAS",
+	NotEnoughReplicasAfter:  "NOT_ENOUGH_REPLICAS_AFTER_APPEND",
+	29:                      "TOPIC_AUTHORIZATION_FAILED",
+}
+
+func (e Error) Error() string {
+	if name, ok := errorNames[e]; ok {
+		return fmt.Sprintf("kafka error %d: %s", int16(e), name)
+	}
+	return fmt.Sprintf("kafka error %d", int16(e))
+}
+
+// Retriable reports whether a request failing with the error may succeed if retried, possibly on another broker
+func (e Error) Retriable() bool {
+	switch e {
+	case UnknownTopicOrPartition, LeaderNotAvailable, NotLeaderForPartition, RequestTimedOut, NetworkException, NotEnoughReplicas, NotEnoughReplicasAfter:
+		return true
+	}
+	return false
+}
+
+// encoder writes the big endian primitive types of the Kafka protocol
+type encoder struct {
+	bytes.Buffer
+}
+
+func (e *encoder) int8(v int8)   { e.WriteByte(byte(v)) }
+func (e *encoder) int16(v int16) { e.Write([]byte{byte(v >> 8), byte(v)}) }
+func (e *encoder) int32(v int32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(v))
+	e.Write(b[:])
+}
+func (e *encoder) int64(v int64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(v))
+	e.Write(b[:])
+}
+func (e *encoder) string(s string) {
+	e.int16(int16(len(s)))
+	e.WriteString(s)
+}
+func (e *encoder) nullableString(s *string) {
+	if s == nil {
+		e.int16(-1)
+		return
+	}
+	e.string(*s)
+}
+func (e *encoder) bytes(b []byte) {
+	e.int32(int32(len(b)))
+	e.Write(b)
+}
+func (e *encoder) varint(v int64) {
+	var b [binary.MaxVarintLen64]byte
+	e.Write(b[:binary.PutVarint(b[:], v)])
+}
+func (e *encoder) varintBytes(b []byte) {
+	if b == nil {
+		e.varint(-1)
+		return
+	}
+	e.varint(int64(len(b)))
+	e.Write(b)
+}
+
+// errShortResponse is returned when a response ends before all of its fields are read
+var errShortResponse = errors.New("kafka response is shorter than expected")
+
+// decoder reads the big endian primitive types of the Kafka protocol. The first error is kept and every later read
+// returns zero values, so a response can be decoded without checking each field.
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || len(d.data) < n {
+		d.err = errShortResponse
+		return nil
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *decoder) int8() int8 {
+	if b := d.next(1); b != nil {
+		return int8(b[0])
+	}
+	return 0
+}
+func (d *decoder) int16() int16 {
+	if b := d.next(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+func (d *decoder) int32() int32 {
+	if b := d.next(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+func (d *decoder) int64() int64 {
+	if b := d.next(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+func (d *decoder) string() string {
+	n := d.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(d.next(int(n)))
+}
+
+// arrayLength reads the length of an array; a null array has no elements
+func (d *decoder) arrayLength() int {
+	n := d.int32()
+	if n < 0 || d.err != nil {
+		return 0
+	}
+	if int(n) > len(d.data) {
+		// every element takes at least a byte
+		d.err = errShortResponse
+		return 0
+	}
+	return int(n)
+}
@@ -0,0 +1,450 @@
+package kafka
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"microsoft.com/divoc/pkg/logger"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Acknowledgements required from the brokers before a batch is considered published
+const (
+	AcksNone   = 0  // fire and forget: messages may be lost
+	AcksLeader = 1  // the partition leader has written the batch
+	AcksAll    = -1 // every in-sync replica has written the batch
+)
+
+// Defaults of the Config settings
+const (
+	DefaultClientID   = "divoc"
+	DefaultBatchBytes = 1000000 // just under the default max message size of a broker
+	DefaultMaxRetries = 5
+	DefaultTimeout    = 30 * time.Second
+)
+
+// Config of a Writer
+type Config struct {
+	Brokers     []string // bootstrap brokers; eg: localhost:9092
+	ClientID    string
+	Acks        int           // AcksNone, AcksLeader or AcksAll
+	Compression string        // CompressionNone or CompressionGzip
+	BatchBytes  int           // messages are buffered up to this many bytes before they are published -- keep below the max message size of the brokers
+	MaxRetries  int           // retries of a batch failing with a retriable error -- retried batches may be duplicated
+	Timeout     time.Duration // of each request, and of the acknowledgement of produced batches
+	TLS         *tls.Config   // connect to the brokers over TLS if set
+}
+
+// topicPartition identifies a partition of a topic
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+// Writer publishes messages to Kafka topics. Messages are buffered and published in a batch per partition once
+// BatchBytes are buffered, or on Flush. Messages with the same key go to the same partition and are published in the
+// order they were written, including across retries, since a partition is never sent more than one batch at a time.
+// Writers are safe for concurrent use.
+type Writer struct {
+	config Config
+
+	lock         sync.Mutex
+	brokers      map[int32]string   // address of each broker by node id
+	conns        map[string]*conn   // by broker address
+	connsLock    sync.Mutex         // guards conns, which the produce requests of a flush open in parallel
+	leaders      map[string][]int32 // node id of the leader of each partition of a topic; -1 if unknown
+	pending      map[topicPartition][]Message
+	pendingBytes int
+	roundRobin   int
+}
+
+// NewWriter returns a Writer publishing to a cluster. Zero settings of the config use their default, except Acks and
+// MaxRetries.
+func NewWriter(config Config) (*Writer, error) {
+	if len(config.Brokers) == 0 {
+		return nil, errors.New("at least one kafka broker is required")
+	}
+	if config.ClientID == "" {
+		config.ClientID = DefaultClientID
+	}
+	if config.Compression == "" {
+		config.Compression = CompressionNone
+	}
+	if _, ok := codecs[config.Compression]; !ok {
+		return nil, fmt.Errorf("kafka compression must be %s or %s: %s", CompressionNone, CompressionGzip, config.Compression)
+	}
+	if config.Acks != AcksNone && config.Acks != AcksLeader && config.Acks != AcksAll {
+		return nil, fmt.Errorf("kafka acks must be %d, %d or %d: %d", AcksNone, AcksLeader, AcksAll, config.Acks)
+	}
+	if config.BatchBytes <= 0 {
+		config.BatchBytes = DefaultBatchBytes
+	}
+	if config.MaxRetries < 0 {
+		config.MaxRetries = 0
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+	return &Writer{
+		config:  config,
+		brokers: map[int32]string{},
+		conns:   map[string]*conn{},
+		leaders: map[string][]int32{},
+		pending: map[topicPartition][]Message{},
+	}, nil
+}
+
+// Write buffers a message for a topic, first publishing the buffered messages if the message would take them over
+// BatchBytes. Messages without a key are spread over the partitions of the topic.
+func (w *Writer) Write(topic string, m Message) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	leaders, err := w.topicLeaders(topic)
+	if err != nil {
+		return err
+	}
+	var p int
+	if m.Key != nil {
+		p = partition(m.Key, len(leaders))
+	} else {
+		p = w.roundRobin % len(leaders)
+		w.roundRobin++
+	}
+	// publish first if the message would take the buffer over BatchBytes, so no batch exceeds it
+	if w.pendingBytes > 0 && w.pendingBytes+m.size() > w.config.BatchBytes {
+		if err := w.flush(); err != nil {
+			return err
+		}
+	}
+	tp := topicPartition{topic, int32(p)}
+	w.pending[tp] = append(w.pending[tp], m)
+	w.pendingBytes += m.size()
+	return nil
+}
+
+// Flush publishes every buffered message
+func (w *Writer) Flush() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.flush()
+}
+
+// Close publishes every buffered message, then closes the connections to the brokers
+func (w *Writer) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	err := w.flush()
+	for addr, c := range w.conns {
+		c.close()
+		delete(w.conns, addr)
+	}
+	return err
+}
+
+// produceResult is the outcome of publishing the batch of a partition
+type produceResult struct {
+	err   error
+	retry bool
+}
+
+// flush publishes the pending batches with a produce request per leader broker, retrying the batches of partitions
+// failing with a retriable error
+func (w *Writer) flush() error {
+	backoff := 250 * time.Millisecond
+	for attempt := 0; len(w.pending) > 0; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+			var topics []string
+			seen := map[string]bool{}
+			for tp := range w.pending {
+				if !seen[tp.topic] {
+					seen[tp.topic] = true
+					topics = append(topics, tp.topic)
+				}
+			}
+			if err := w.refreshMetadata(topics); err != nil {
+				logger.Debugf("Failed refreshing kafka metadata: %s", err)
+			}
+		}
+
+		byLeader := map[int32][]topicPartition{}
+		results := map[topicPartition]produceResult{}
+		for tp := range w.pending {
+			leaders := w.leaders[tp.topic]
+			if int(tp.partition) >= len(leaders) || leaders[tp.partition] < 0 {
+				results[tp] = produceResult{LeaderNotAvailable, true}
+				continue
+			}
+			byLeader[leaders[tp.partition]] = append(byLeader[leaders[tp.partition]], tp)
+		}
+		var wg sync.WaitGroup
+		var resultsLock sync.Mutex
+		for leader, tps := range byLeader {
+			wg.Add(1)
+			go func(leader int32, tps []topicPartition) {
+				defer wg.Done()
+				produced := w.produce(leader, tps)
+				resultsLock.Lock()
+				defer resultsLock.Unlock()
+				for tp, result := range produced {
+					results[tp] = result
+				}
+			}(leader, tps)
+		}
+		wg.Wait()
+
+		var failure error
+		for tp, result := range results {
+			if result.err == nil {
+				w.pendingBytes -= batchSize(w.pending[tp])
+				delete(w.pending, tp)
+				continue
+			}
+			err := fmt.Errorf("failed publishing %d messages to %s/%d: %s", len(w.pending[tp]), tp.topic, tp.partition, result.err)
+			if !result.retry || attempt >= w.config.MaxRetries {
+				failure = err
+			} else {
+				logger.Debugf("Retrying: %s", err)
+			}
+		}
+		if failure != nil {
+			return failure
+		}
+	}
+	w.pendingBytes = 0
+	return nil
+}
+
+// batchSize is the size of the buffered messages of a partition
+func batchSize(messages []Message) (n int) {
+	for _, m := range messages {
+		n += m.size()
+	}
+	return n
+}
+
+// produce sends the batches of partitions led by a broker in a single produce request
+func (w *Writer) produce(leader int32, tps []topicPartition) map[topicPartition]produceResult {
+	results := map[topicPartition]produceResult{}
+	failAll := func(err error, retry bool) map[topicPartition]produceResult {
+		for _, tp := range tps {
+			results[tp] = produceResult{err, retry}
+		}
+		return results
+	}
+
+	byTopic := map[string][]topicPartition{}
+	var topics []string
+	for _, tp := range tps {
+		if _, ok := byTopic[tp.topic]; !ok {
+			topics = append(topics, tp.topic)
+		}
+		byTopic[tp.topic] = append(byTopic[tp.topic], tp)
+	}
+	sort.Strings(topics)
+	var req encoder
+	req.nullableString(nil) // transactional id
+	req.int16(int16(w.config.Acks))
+	req.int32(int32(w.config.Timeout / time.Millisecond))
+	req.int32(int32(len(topics)))
+	for _, topic := range topics {
+		req.string(topic)
+		req.int32(int32(len(byTopic[topic])))
+		for _, tp := range byTopic[topic] {
+			batch, err := encodeRecordBatch(w.pending[tp], w.config.Compression, time.Now())
+			if err != nil {
+				return failAll(err, false)
+			}
+			req.int32(tp.partition)
+			req.bytes(batch)
+		}
+	}
+
+	c, err := w.brokerConn(leader)
+	if err != nil {
+		return failAll(err, true)
+	}
+	resp, err := c.roundTrip(apiProduce, produceVersion, req.Bytes(), w.config.Acks != AcksNone)
+	if err != nil {
+		w.dropConn(c)
+		return failAll(err, true)
+	}
+	if w.config.Acks == AcksNone {
+		return failAll(nil, false)
+	}
+
+	d := decoder{data: resp}
+	for i := d.arrayLength(); i > 0; i-- {
+		topic := d.string()
+		for j := d.arrayLength(); j > 0; j-- {
+			p := d.int32()
+			code := Error(d.int16())
+			d.int64() // base offset
+			d.int64() // log append time
+			tp := topicPartition{topic, p}
+			if code == 0 {
+				results[tp] = produceResult{}
+			} else {
+				results[tp] = produceResult{code, code.Retriable()}
+			}
+		}
+	}
+	if d.err != nil {
+		return failAll(d.err, true)
+	}
+	for _, tp := range tps {
+		if _, ok := results[tp]; !ok {
+			results[tp] = produceResult{errors.New("no response for the partition"), true}
+		}
+	}
+	return results
+}
+
+// topicLeaders returns the leaders of the partitions of a topic, requesting the metadata of the topic if unknown.
+// Brokers which auto-create topics may need a moment to elect leaders of a new topic.
+func (w *Writer) topicLeaders(topic string) ([]int32, error) {
+	backoff := 250 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		if leaders, ok := w.leaders[topic]; ok && len(leaders) > 0 {
+			return leaders, nil
+		}
+		err := w.refreshMetadata([]string{topic})
+		if leaders, ok := w.leaders[topic]; ok && len(leaders) > 0 {
+			return leaders, nil
+		}
+		if attempt >= w.config.MaxRetries {
+			if err == nil {
+				err = UnknownTopicOrPartition
+			}
+			return nil, fmt.Errorf("failed finding partitions of kafka topic %s: %s", topic, err)
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// refreshMetadata requests the brokers of the cluster and the partition leaders of topics
+func (w *Writer) refreshMetadata(topics []string) error {
+	var req encoder
+	req.int32(int32(len(topics)))
+	for _, topic := range topics {
+		req.string(topic)
+	}
+	c, err := w.anyConn()
+	if err != nil {
+		return err
+	}
+	resp, err := c.roundTrip(apiMetadata, metadataVersion, req.Bytes(), true)
+	if err != nil {
+		w.dropConn(c)
+		return err
+	}
+
+	d := decoder{data: resp}
+	brokers := map[int32]string{}
+	for i := d.arrayLength(); i > 0; i-- {
+		id := d.int32()
+		host := d.string()
+		port := d.int32()
+		if rack := d.int16(); rack > 0 {
+			d.next(int(rack))
+		}
+		brokers[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	d.int32() // controller id
+	leaders := map[string][]int32{}
+	var topicErr error
+	for i := d.arrayLength(); i > 0; i-- {
+		code := Error(d.int16())
+		topic := d.string()
+		d.int8() // is internal
+		var partitionLeaders []int32
+		for j := d.arrayLength(); j > 0; j-- {
+			d.int16() // error code -- a partition without a leader has a leader id of -1
+			p := d.int32()
+			leader := d.int32()
+			for k := d.arrayLength(); k > 0; k-- {
+				d.int32() // replicas
+			}
+			for k := d.arrayLength(); k > 0; k-- {
+				d.int32() // in-sync replicas
+			}
+			for int(p) >= len(partitionLeaders) {
+				partitionLeaders = append(partitionLeaders, -1)
+			}
+			partitionLeaders[p] = leader
+		}
+		if code != 0 {
+			topicErr = fmt.Errorf("%s: %s", topic, code)
+			continue
+		}
+		leaders[topic] = partitionLeaders
+	}
+	if d.err != nil {
+		return d.err
+	}
+	for id, addr := range brokers {
+		w.brokers[id] = addr
+	}
+	for topic, l := range leaders {
+		w.leaders[topic] = l
+	}
+	return topicErr
+}
+
+// brokerConn returns the connection to a broker, connecting if needed
+func (w *Writer) brokerConn(id int32) (*conn, error) {
+	addr, ok := w.brokers[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown kafka broker %d", id)
+	}
+	return w.connect(addr)
+}
+
+// anyConn returns a connection to any broker: an open connection, or the first bootstrap broker which accepts one
+func (w *Writer) anyConn() (*conn, error) {
+	w.connsLock.Lock()
+	for _, c := range w.conns {
+		w.connsLock.Unlock()
+		return c, nil
+	}
+	w.connsLock.Unlock()
+	var err error
+	for _, addr := range w.config.Brokers {
+		var c *conn
+		if c, err = w.connect(addr); err == nil {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("failed connecting to kafka brokers %v: %s", w.config.Brokers, err)
+}
+
+// connect returns the connection to a broker address, connecting if needed
+func (w *Writer) connect(addr string) (*conn, error) {
+	w.connsLock.Lock()
+	defer w.connsLock.Unlock()
+	if c, ok := w.conns[addr]; ok {
+		return c, nil
+	}
+	c, err := dial(addr, w.config.TLS, w.config.ClientID, w.config.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	w.conns[addr] = c
+	return c, nil
+}
+
+// dropConn closes a failed connection so the next request reconnects
+func (w *Writer) dropConn(c *conn) {
+	w.connsLock.Lock()
+	defer w.connsLock.Unlock()
+	c.close()
+	if w.conns[c.addr] == c {
+		delete(w.conns, c.addr)
+	}
+}
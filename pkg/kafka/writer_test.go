@@ -0,0 +1,461 @@
+package kafka
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeCluster is a cluster of in-memory brokers answering metadata v1 and produce v3 requests
+type fakeCluster struct {
+	t         *testing.T
+	lock      sync.Mutex
+	listeners map[int32]net.Listener // by node id
+	leaders   map[string][]int32     // leader of each partition of a topic
+	records   map[topicPartition][]Message
+	// produceError, if set, returns the error of a partition produced to a broker
+	produceError func(broker int32, tp topicPartition) Error
+	requests     map[int16]int // by API key
+}
+
+func newFakeCluster(t *testing.T, brokers int) *fakeCluster {
+	c := &fakeCluster{
+		t:         t,
+		listeners: map[int32]net.Listener{},
+		leaders:   map[string][]int32{},
+		records:   map[topicPartition][]Message{},
+		requests:  map[int16]int{},
+	}
+	for id := int32(1); id <= int32(brokers); id++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.listeners[id] = l
+		go c.serve(id, l)
+	}
+	return c
+}
+
+func (c *fakeCluster) addr(id int32) string {
+	return c.listeners[id].Addr().String()
+}
+
+func (c *fakeCluster) close() {
+	for _, l := range c.listeners {
+		l.Close()
+	}
+}
+
+func (c *fakeCluster) serve(id int32, l net.Listener) {
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer nc.Close()
+			for {
+				var size [4]byte
+				if _, err := io.ReadFull(nc, size[:]); err != nil {
+					return
+				}
+				req := make([]byte, binary.BigEndian.Uint32(size[:]))
+				if _, err := io.ReadFull(nc, req); err != nil {
+					return
+				}
+				d := decoder{data: req}
+				apiKey, apiVersion, correlationID := d.int16(), d.int16(), d.int32()
+				if clientID := d.string(); clientID != DefaultClientID {
+					c.t.Errorf("client id %q", clientID)
+				}
+				var resp encoder
+				resp.int32(0) // size, set below
+				resp.int32(correlationID)
+				switch {
+				case apiKey == apiMetadata && apiVersion == metadataVersion:
+					c.metadata(&d, &resp)
+				case apiKey == apiProduce && apiVersion == produceVersion:
+					if !c.produce(id, &d, &resp) {
+						continue // acks=0
+					}
+				default:
+					c.t.Errorf("unexpected request %d v%d", apiKey, apiVersion)
+					return
+				}
+				data := resp.Bytes()
+				binary.BigEndian.PutUint32(data, uint32(len(data)-4))
+				if _, err := nc.Write(data); err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+func (c *fakeCluster) metadata(d *decoder, resp *encoder) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.requests[apiMetadata]++
+	var topics []string
+	for i := d.arrayLength(); i > 0; i-- {
+		topics = append(topics, d.string())
+	}
+
+	resp.int32(int32(len(c.listeners)))
+	for id := int32(1); id <= int32(len(c.listeners)); id++ {
+		host, port, _ := net.SplitHostPort(c.addr(id))
+		p, _ := strconv.Atoi(port)
+		resp.int32(id)
+		resp.string(host)
+		resp.int32(int32(p))
+		resp.nullableString(nil) // rack
+	}
+	resp.int32(1) // controller id
+	resp.int32(int32(len(topics)))
+	for _, topic := range topics {
+		leaders, ok := c.leaders[topic]
+		if ok {
+			resp.int16(0)
+		} else {
+			resp.int16(int16(UnknownTopicOrPartition))
+		}
+		resp.string(topic)
+		resp.int8(0) // is internal
+		resp.int32(int32(len(leaders)))
+		for p, leader := range leaders {
+			resp.int16(0)
+			resp.int32(int32(p))
+			resp.int32(leader)
+			resp.int32(1) // replicas
+			resp.int32(leader)
+			resp.int32(1) // in-sync replicas
+			resp.int32(leader)
+		}
+	}
+}
+
+// produce stores the records of a produce request, and returns whether it has a response
+func (c *fakeCluster) produce(broker int32, d *decoder, resp *encoder) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.requests[apiProduce]++
+	d.string() // transactional id
+	acks := d.int16()
+	d.int32() // timeout
+
+	// answer the topics as they are read
+	topics := d.arrayLength()
+	resp.int32(int32(topics))
+	for i := 0; i < topics; i++ {
+		topic := d.string()
+		resp.string(topic)
+		partitions := d.arrayLength()
+		resp.int32(int32(partitions))
+		for j := 0; j < partitions; j++ {
+			tp := topicPartition{topic, d.int32()}
+			batch := d.next(int(d.int32()))
+			code := Error(0)
+			if c.produceError != nil {
+				code = c.produceError(broker, tp)
+			}
+			if code == 0 {
+				if leaders := c.leaders[topic]; int(tp.partition) >= len(leaders) || leaders[tp.partition] != broker {
+					code = NotLeaderForPartition
+				}
+			}
+			if code == 0 {
+				c.records[tp] = append(c.records[tp], decodeRecordBatch(c.t, batch)...)
+			}
+			resp.int32(tp.partition)
+			resp.int16(int16(code))
+			resp.int64(int64(len(c.records[tp]))) // base offset
+			resp.int64(-1)                        // log append time
+		}
+	}
+	resp.int32(0) // throttle time
+	if d.err != nil {
+		c.t.Errorf("malformed produce request: %s", d.err)
+	}
+	return acks != AcksNone
+}
+
+// decodeRecordBatch decodes the messages of an uncompressed v2 record batch, checking its CRC. It runs on the goroutines
+// of the brokers, so it reports errors without stopping the test.
+func decodeRecordBatch(t *testing.T, batch []byte) []Message {
+	d := decoder{data: batch}
+	d.int64() // base offset
+	if length := d.int32(); int(length) != len(d.data) {
+		t.Errorf("batch length %d, expected %d", length, len(d.data))
+	}
+	d.int32() // partition leader epoch
+	if magic := d.int8(); magic != 2 {
+		t.Errorf("magic %d", magic)
+	}
+	if crc := uint32(d.int32()); crc != crc32.Checksum(d.data, castagnoli) {
+		t.Error("CRC does not match the batch")
+	}
+	if attributes := d.int16(); attributes != 0 {
+		t.Errorf("attributes %d, expected an uncompressed batch", attributes)
+		return nil
+	}
+	d.next(4 + 8 + 8 + 8 + 2 + 4) // last offset delta, timestamps, producer id and epoch, base sequence
+	n := d.int32()
+
+	r := bytes.NewReader(d.data)
+	var err error
+	varint := func() int64 {
+		var v int64
+		if err == nil {
+			v, err = binary.ReadVarint(r)
+		}
+		return v
+	}
+	varintBytes := func() []byte {
+		n := varint()
+		if n < 0 {
+			return nil
+		}
+		b := make([]byte, n)
+		if _, readErr := io.ReadFull(r, b); err == nil {
+			err = readErr
+		}
+		return b
+	}
+	var messages []Message
+	for i := int32(0); i < n; i++ {
+		varint()     // length
+		r.ReadByte() // attributes
+		varint()     // timestamp delta
+		if delta := varint(); delta != int64(i) {
+			t.Errorf("record %d has offset delta %d", i, delta)
+		}
+		m := Message{Key: varintBytes(), Value: varintBytes()}
+		for h := varint(); h > 0; h-- {
+			m.Headers = append(m.Headers, Header{Key: string(varintBytes()), Value: varintBytes()})
+		}
+		messages = append(messages, m)
+	}
+	if r.Len() != 0 || d.err != nil || err != nil {
+		t.Errorf("malformed record batch: %v", err)
+	}
+	return messages
+}
+
+// published returns the messages published to a partition
+func (c *fakeCluster) published(topic string, p int32) []Message {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.records[topicPartition{topic, p}]
+}
+
+// count returns the number of requests received with an API key
+func (c *fakeCluster) count(apiKey int16) int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.requests[apiKey]
+}
+
+// setProduceError sets the errors of produced partitions
+func (c *fakeCluster) setProduceError(fn func(broker int32, tp topicPartition) Error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.produceError = fn
+}
+
+func newTestWriter(t *testing.T, cluster *fakeCluster, acks int) *Writer {
+	w, err := NewWriter(Config{Brokers: []string{cluster.addr(1)}, Acks: acks, MaxRetries: 3, Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func TestWriterPublishesToPartitionLeaders(t *testing.T) {
+	cluster := newFakeCluster(t, 2)
+	defer cluster.close()
+	cluster.leaders["fhir.Patient"] = []int32{1, 2, 1}
+	cluster.leaders["fhir.Encounter"] = []int32{2}
+	w := newTestWriter(t, cluster, AcksAll)
+
+	keys := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+	for i, key := range keys {
+		value := []byte(strconv.Itoa(i))
+		if err := w.Write("fhir.Patient", Message{Key: []byte(key), Value: value}); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Write("fhir.Encounter", Message{Value: value, Headers: []Header{{Key: "patient", Value: []byte(key)}}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// keyed messages are published in order to the partition of their key
+	var published []Message
+	for p := int32(0); p < 3; p++ {
+		for _, m := range cluster.published("fhir.Patient", p) {
+			if expected := partition(m.Key, 3); int(p) != expected {
+				t.Errorf("key %s published to partition %d, expected %d", m.Key, p, expected)
+			}
+			published = append(published, m)
+		}
+	}
+	if len(published) != len(keys) {
+		t.Errorf("%d of %d keyed messages published", len(published), len(keys))
+	}
+	encounters := cluster.published("fhir.Encounter", 0)
+	if len(encounters) != len(keys) {
+		t.Fatalf("%d of %d messages published to fhir.Encounter", len(encounters), len(keys))
+	}
+	for i, m := range encounters {
+		if m.Key != nil || string(m.Value) != strconv.Itoa(i) || string(m.Headers[0].Value) != keys[i] {
+			t.Errorf("message %d published as %+v", i, m)
+		}
+	}
+}
+
+func TestWriterFlushesAtBatchBytes(t *testing.T) {
+	cluster := newFakeCluster(t, 1)
+	defer cluster.close()
+	cluster.leaders["t"] = []int32{1}
+	w, err := NewWriter(Config{Brokers: []string{cluster.addr(1)}, Acks: AcksLeader, BatchBytes: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := w.Write("t", Message{Value: make([]byte, 30)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	produced := cluster.count(apiProduce)
+	// 46 bytes a message, so 2 messages a batch
+	if produced != 4 {
+		t.Errorf("%d produce requests before the flush, expected 4", produced)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(cluster.published("t", 0)); n != 10 {
+		t.Errorf("%d of 10 messages published", n)
+	}
+}
+
+func TestWriterRetriesMovedLeader(t *testing.T) {
+	cluster := newFakeCluster(t, 2)
+	defer cluster.close()
+	cluster.leaders["t"] = []int32{1, 1}
+	w := newTestWriter(t, cluster, AcksAll)
+	if err := w.Write("t", Message{Value: []byte("0")}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the leadership of partition 1 moves to broker 2 once the writer knows the leaders
+	cluster.lock.Lock()
+	cluster.leaders["t"] = []int32{1, 2}
+	cluster.lock.Unlock()
+	for i := 1; i < 4; i++ {
+		if err := w.Write("t", Message{Value: []byte(strconv.Itoa(i))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if n := cluster.count(apiMetadata); n != 2 {
+		t.Errorf("%d metadata requests, expected a refresh after NOT_LEADER_OR_FOLLOWER", n)
+	}
+	p0, p1 := cluster.published("t", 0), cluster.published("t", 1)
+	if len(p0) != 2 || len(p1) != 2 || string(p1[0].Value) != "1" || string(p1[1].Value) != "3" {
+		t.Errorf("published %d messages to partition 0 and %d to partition 1, expected 2 each in order", len(p0), len(p1))
+	}
+}
+
+func TestWriterRetriableErrors(t *testing.T) {
+	cluster := newFakeCluster(t, 1)
+	defer cluster.close()
+	cluster.leaders["t"] = []int32{1}
+	failures := 2
+	cluster.setProduceError(func(broker int32, tp topicPartition) Error {
+		if failures > 0 {
+			failures--
+			return NotEnoughReplicas
+		}
+		return 0
+	})
+	w := newTestWriter(t, cluster, AcksAll)
+	if err := w.Write("t", Message{Value: []byte("v")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if n, produced := len(cluster.published("t", 0)), cluster.count(apiProduce); n != 1 || produced != 3 {
+		t.Errorf("published %d messages in %d produce requests, expected 1 in 3", n, produced)
+	}
+
+	// retries are bounded
+	cluster.setProduceError(func(broker int32, tp topicPartition) Error { return NotEnoughReplicas })
+	if err := w.Write("t", Message{Value: []byte("v")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err == nil {
+		t.Error("expected NOT_ENOUGH_REPLICAS once retries are exhausted")
+	}
+}
+
+func TestWriterFailsNonRetriableErrors(t *testing.T) {
+	cluster := newFakeCluster(t, 1)
+	defer cluster.close()
+	cluster.leaders["t"] = []int32{1}
+	cluster.setProduceError(func(broker int32, tp topicPartition) Error { return MessageTooLarge })
+	w := newTestWriter(t, cluster, AcksLeader)
+	if err := w.Write("t", Message{Value: []byte("v")}); err != nil {
+		t.Fatal(err)
+	}
+	err := w.Flush()
+	if produced := cluster.count(apiProduce); err == nil || produced != 1 {
+		t.Errorf("expected MESSAGE_TOO_LARGE without retries, got %v after %d produce requests", err, produced)
+	}
+}
+
+func TestWriterUnknownTopic(t *testing.T) {
+	cluster := newFakeCluster(t, 1)
+	defer cluster.close()
+	w, err := NewWriter(Config{Brokers: []string{cluster.addr(1)}, Acks: AcksLeader})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write("missing", Message{Value: []byte("v")}); err == nil {
+		t.Error("expected an error writing to a missing topic")
+	}
+}
+
+func TestWriterWithoutAcks(t *testing.T) {
+	cluster := newFakeCluster(t, 1)
+	defer cluster.close()
+	cluster.leaders["t"] = []int32{1}
+	w := newTestWriter(t, cluster, AcksNone)
+	for i := 0; i < 3; i++ {
+		if err := w.Write("t", Message{Value: []byte(strconv.Itoa(i))}); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the requests are sent on one connection, in order, so a last metadata request sees every batch
+	if err := w.refreshMetadata([]string{"t"}); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(cluster.published("t", 0)); n != 3 {
+		t.Errorf("%d of 3 messages published", n)
+	}
+}
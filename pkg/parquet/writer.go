@@ -0,0 +1,355 @@
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Type of a column. Every column is optional (nullable).
+type Type int
+
+const (
+	String  Type = iota // BYTE_ARRAY annotated as UTF8
+	Double              // DOUBLE
+	Int64               // INT64
+	Boolean             // BOOLEAN
+)
+
+// Codec compressing the pages of a file
+type Codec int
+
+const (
+	Uncompressed Codec = 0
+	Gzip         Codec = 2
+)
+
+// Column of a flat Parquet schema
+type Column struct {
+	Name string
+	Type Type
+}
+
+// physical types, converted types and encodings of the Parquet format
+const (
+	physicalBoolean    = 0
+	physicalInt64      = 2
+	physicalDouble     = 5
+	physicalByteArray  = 6
+	convertedUTF8      = 0
+	repetitionOptional = 1
+	encodingPlain      = 0
+	encodingRLE        = 3
+	pageTypeData       = 0
+)
+
+// magic bytes opening and closing every Parquet file
+const magic = "PAR1"
+
+// DefaultRowGroupSize is the number of rows buffered into each row group
+const DefaultRowGroupSize = 10000
+
+// Writer writes rows of a flat schema of optional columns to a Parquet file.
+// Each row group holds a single PLAIN encoded data page per column.
+type Writer struct {
+	RowGroupSize int // rows per row group -- defaults to DefaultRowGroupSize
+
+	w         io.Writer
+	offset    int64
+	columns   []Column
+	codec     Codec
+	values    [][]interface{} // buffered values per column -- nil for null
+	buffered  int
+	numRows   int64
+	rowGroups []rowGroup
+}
+
+// rowGroup is the metadata of a written row group
+type rowGroup struct {
+	numRows   int64
+	totalSize int64
+	chunks    []columnChunk
+}
+
+// columnChunk is the metadata of a written column chunk
+type columnChunk struct {
+	offset           int64
+	numValues        int64
+	uncompressedSize int64
+	compressedSize   int64
+}
+
+// NewWriter writes the Parquet header to w and returns a Writer for the provided columns.
+// Close must be called to write the footer.
+func NewWriter(w io.Writer, columns []Column, codec Codec) (*Writer, error) {
+	if _, err := io.WriteString(w, magic); err != nil {
+		return nil, err
+	}
+	return &Writer{
+		RowGroupSize: DefaultRowGroupSize,
+		w:            w,
+		offset:       int64(len(magic)),
+		columns:      columns,
+		codec:        codec,
+		values:       make([][]interface{}, len(columns)),
+	}, nil
+}
+
+// Write a row. row holds one value per column in schema order: a string, float64, int64 or bool matching the column
+// type, or nil for null.
+func (pw *Writer) Write(row []interface{}) error {
+	if len(row) != len(pw.columns) {
+		return fmt.Errorf("row has %d values, schema has %d columns", len(row), len(pw.columns))
+	}
+	for i, v := range row {
+		pw.values[i] = append(pw.values[i], v)
+	}
+	pw.buffered++
+	if pw.buffered >= pw.RowGroupSize {
+		return pw.flush()
+	}
+	return nil
+}
+
+// Close flushes buffered rows and writes the file footer. It does not close the underlying writer.
+func (pw *Writer) Close() error {
+	if err := pw.flush(); err != nil {
+		return err
+	}
+	footer := pw.fileMetaData()
+	if _, err := pw.w.Write(footer); err != nil {
+		return err
+	}
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(len(footer)))
+	if _, err := pw.w.Write(size[:]); err != nil {
+		return err
+	}
+	_, err := io.WriteString(pw.w, magic)
+	return err
+}
+
+// flush writes the buffered rows as a row group
+func (pw *Writer) flush() error {
+	if pw.buffered == 0 {
+		return nil
+	}
+	group := rowGroup{numRows: int64(pw.buffered)}
+	for i, column := range pw.columns {
+		page, err := pw.page(column, pw.values[i])
+		if err != nil {
+			return fmt.Errorf("failed encoding column %s: %s", column.Name, err)
+		}
+		chunk := columnChunk{offset: pw.offset, numValues: int64(pw.buffered)}
+		chunk.uncompressedSize, chunk.compressedSize = page.uncompressedSize, int64(len(page.data))
+		if _, err := pw.w.Write(page.data); err != nil {
+			return err
+		}
+		pw.offset += int64(len(page.data))
+		group.totalSize += chunk.uncompressedSize
+		group.chunks = append(group.chunks, chunk)
+		pw.values[i] = pw.values[i][:0]
+	}
+	pw.rowGroups = append(pw.rowGroups, group)
+	pw.numRows += group.numRows
+	pw.buffered = 0
+	return nil
+}
+
+// encodedPage is a data page with its header
+type encodedPage struct {
+	data             []byte
+	uncompressedSize int64 // size of the header and uncompressed body
+}
+
+// page encodes the values of a column as a single data page
+func (pw *Writer) page(column Column, values []interface{}) (encodedPage, error) {
+	levels := make([]bool, len(values))
+	var body bytes.Buffer
+	var bits []bool
+	for i, v := range values {
+		if v == nil {
+			continue
+		}
+		levels[i] = true
+		switch column.Type {
+		case String:
+			s, ok := v.(string)
+			if !ok {
+				return encodedPage{}, fmt.Errorf("expected string, got %T", v)
+			}
+			binary.Write(&body, binary.LittleEndian, uint32(len(s)))
+			body.WriteString(s)
+		case Double:
+			f, ok := v.(float64)
+			if !ok {
+				return encodedPage{}, fmt.Errorf("expected float64, got %T", v)
+			}
+			binary.Write(&body, binary.LittleEndian, math.Float64bits(f))
+		case Int64:
+			n, ok := v.(int64)
+			if !ok {
+				return encodedPage{}, fmt.Errorf("expected int64, got %T", v)
+			}
+			binary.Write(&body, binary.LittleEndian, n)
+		case Boolean:
+			b, ok := v.(bool)
+			if !ok {
+				return encodedPage{}, fmt.Errorf("expected bool, got %T", v)
+			}
+			bits = append(bits, b)
+		}
+	}
+	if column.Type == Boolean {
+		body.Write(bitPack(bits))
+	}
+
+	// definition levels precede the values, prefixed by their length
+	encodedLevels := rleLevels(levels)
+	var raw bytes.Buffer
+	binary.Write(&raw, binary.LittleEndian, uint32(len(encodedLevels)))
+	raw.Write(encodedLevels)
+	raw.Write(body.Bytes())
+
+	compressed := raw.Bytes()
+	if pw.codec == Gzip {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		if _, err := gz.Write(raw.Bytes()); err != nil {
+			return encodedPage{}, err
+		}
+		if err := gz.Close(); err != nil {
+			return encodedPage{}, err
+		}
+		compressed = buf.Bytes()
+	}
+
+	var header thriftWriter
+	header.beginStruct()
+	header.i32Field(1, pageTypeData)
+	header.i32Field(2, int32(raw.Len()))
+	header.i32Field(3, int32(len(compressed)))
+	header.field(5, tStruct)
+	header.beginStruct()
+	header.i32Field(1, int32(len(values)))
+	header.i32Field(2, encodingPlain)
+	header.i32Field(3, encodingRLE)
+	header.i32Field(4, encodingRLE)
+	header.endStruct()
+	header.endStruct()
+
+	return encodedPage{
+		data:             append(header.buf.Bytes(), compressed...),
+		uncompressedSize: int64(header.buf.Len() + raw.Len()),
+	}, nil
+}
+
+// fileMetaData encodes the file footer
+func (pw *Writer) fileMetaData() []byte {
+	var t thriftWriter
+	t.beginStruct()
+	t.i32Field(1, 1) // version
+
+	// schema: a root element followed by one optional leaf per column
+	t.field(2, tList)
+	t.listHeader(len(pw.columns)+1, tStruct)
+	t.beginStruct()
+	t.stringField(4, "schema")
+	t.i32Field(5, int32(len(pw.columns)))
+	t.endStruct()
+	for _, column := range pw.columns {
+		t.beginStruct()
+		t.i32Field(1, physicalType(column.Type))
+		t.i32Field(3, repetitionOptional)
+		t.stringField(4, column.Name)
+		if column.Type == String {
+			t.i32Field(6, convertedUTF8)
+		}
+		t.endStruct()
+	}
+
+	t.i64Field(3, pw.numRows)
+
+	t.field(4, tList)
+	t.listHeader(len(pw.rowGroups), tStruct)
+	for _, group := range pw.rowGroups {
+		t.beginStruct()
+		t.field(1, tList)
+		t.listHeader(len(group.chunks), tStruct)
+		for i, chunk := range group.chunks {
+			t.beginStruct()
+			t.i64Field(2, chunk.offset)
+			t.field(3, tStruct)
+			t.beginStruct()
+			t.i32Field(1, physicalType(pw.columns[i].Type))
+			t.field(2, tList)
+			t.listHeader(2, tI32)
+			t.varint(encodingPlain)
+			t.varint(encodingRLE)
+			t.field(3, tList)
+			t.listHeader(1, tBinary)
+			t.str(pw.columns[i].Name)
+			t.i32Field(4, int32(pw.codec))
+			t.i64Field(5, chunk.numValues)
+			t.i64Field(6, chunk.uncompressedSize)
+			t.i64Field(7, chunk.compressedSize)
+			t.i64Field(9, chunk.offset)
+			t.endStruct()
+			t.endStruct()
+		}
+		t.i64Field(2, group.totalSize)
+		t.i64Field(3, group.numRows)
+		t.endStruct()
+	}
+
+	t.stringField(6, "divoc")
+	t.endStruct()
+	return t.buf.Bytes()
+}
+
+// physicalType returns the Parquet physical type of a column type
+func physicalType(t Type) int32 {
+	switch t {
+	case Double:
+		return physicalDouble
+	case Int64:
+		return physicalInt64
+	case Boolean:
+		return physicalBoolean
+	}
+	return physicalByteArray
+}
+
+// rleLevels encodes definition levels (max level 1) with the RLE/bit-packing hybrid encoding using only RLE runs
+func rleLevels(levels []bool) []byte {
+	var buf bytes.Buffer
+	var header [binary.MaxVarintLen64]byte
+	for i := 0; i < len(levels); {
+		run := 1
+		for i+run < len(levels) && levels[i+run] == levels[i] {
+			run++
+		}
+		buf.Write(header[:binary.PutUvarint(header[:], uint64(run)<<1)])
+		if levels[i] {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+		i += run
+	}
+	return buf.Bytes()
+}
+
+// bitPack packs booleans LSB first -- the PLAIN encoding of BOOLEAN values
+func bitPack(bits []bool) []byte {
+	packed := make([]byte, (len(bits)+7)/8)
+	for i, b := range bits {
+		if b {
+			packed[i/8] |= 1 << uint(i%8)
+		}
+	}
+	return packed
+}
@@ -0,0 +1,358 @@
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files of testdata")
+
+// thriftReader decodes the Thrift compact protocol into generic values: structs as map[int16]interface{} by field id,
+// lists as []interface{}, integers as int64 and binaries as []byte
+type thriftReader struct {
+	data []byte
+	err  error
+}
+
+func (r *thriftReader) byte() byte {
+	if len(r.data) == 0 {
+		r.err = fmt.Errorf("thrift data ends early")
+		return 0
+	}
+	b := r.data[0]
+	r.data = r.data[1:]
+	return b
+}
+
+func (r *thriftReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.err = fmt.Errorf("invalid varint")
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *thriftReader) varint() int64 {
+	v := r.uvarint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *thriftReader) value(typ byte) interface{} {
+	switch typ {
+	case 1, 2: // booleans are held by the type of their field
+		return typ == 1
+	case 3:
+		return int64(int8(r.byte()))
+	case 4, 5, 6:
+		return r.varint()
+	case tBinary:
+		n := int(r.uvarint())
+		if n > len(r.data) {
+			r.err = fmt.Errorf("thrift binary of %d bytes ends early", n)
+			return nil
+		}
+		b := r.data[:n]
+		r.data = r.data[n:]
+		return b
+	case tList:
+		header := r.byte()
+		size := int(header >> 4)
+		if size == 15 {
+			size = int(r.uvarint())
+		}
+		var list []interface{}
+		for i := 0; i < size && r.err == nil; i++ {
+			list = append(list, r.value(header&0x0f))
+		}
+		return list
+	case tStruct:
+		fields := map[int16]interface{}{}
+		var id int16
+		for r.err == nil {
+			header := r.byte()
+			if header == 0 {
+				break
+			}
+			if delta := int16(header >> 4); delta != 0 {
+				id += delta
+			} else {
+				id = int16(r.varint())
+			}
+			fields[id] = r.value(header & 0x0f)
+		}
+		return fields
+	}
+	r.err = fmt.Errorf("unsupported thrift type %d", typ)
+	return nil
+}
+
+// readFile decodes a Parquet file written by Writer, returning its columns and rows
+func readFile(t *testing.T, data []byte) ([]Column, [][]interface{}) {
+	if string(data[:4]) != magic || string(data[len(data)-4:]) != magic {
+		t.Fatal("missing magic bytes")
+	}
+	footerSize := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	footer := thriftReader{data: data[len(data)-8-footerSize : len(data)-8]}
+	metadata := footer.value(tStruct).(map[int16]interface{})
+	if footer.err != nil || len(footer.data) != 0 {
+		t.Fatalf("invalid footer: %v", footer.err)
+	}
+
+	schema := metadata[2].([]interface{})
+	if root := schema[0].(map[int16]interface{}); int(root[5].(int64)) != len(schema)-1 {
+		t.Fatalf("root of the schema has %d children, expected %d", root[5], len(schema)-1)
+	}
+	var columns []Column
+	for _, element := range schema[1:] {
+		fields := element.(map[int16]interface{})
+		if fields[3].(int64) != repetitionOptional {
+			t.Errorf("column %s is not optional", fields[4])
+		}
+		var typ Type
+		switch fields[1].(int64) {
+		case physicalByteArray:
+			typ = String
+			if converted, ok := fields[6]; !ok || converted.(int64) != convertedUTF8 {
+				t.Errorf("string column %s is not annotated as UTF8", fields[4])
+			}
+		case physicalDouble:
+			typ = Double
+		case physicalInt64:
+			typ = Int64
+		case physicalBoolean:
+			typ = Boolean
+		}
+		columns = append(columns, Column{Name: string(fields[4].([]byte)), Type: typ})
+	}
+
+	var rows [][]interface{}
+	for _, group := range metadata[4].([]interface{}) {
+		group := group.(map[int16]interface{})
+		numRows := int(group[3].(int64))
+		groupRows := make([][]interface{}, numRows)
+		for i := range groupRows {
+			groupRows[i] = make([]interface{}, len(columns))
+		}
+		for c, chunk := range group[1].([]interface{}) {
+			meta := chunk.(map[int16]interface{})[3].(map[int16]interface{})
+			if path := meta[3].([]interface{}); len(path) != 1 || string(path[0].([]byte)) != columns[c].Name {
+				t.Errorf("chunk %d has path %s, expected %s", c, path, columns[c].Name)
+			}
+			offset := int(meta[9].(int64))
+			page := thriftReader{data: data[offset:]}
+			header := page.value(tStruct).(map[int16]interface{})
+			body := page.data[:header[3].(int64)]
+			if int64(len(data)-offset-len(page.data)+len(body)) != meta[7].(int64) {
+				t.Errorf("column %s: chunk size %d does not match its page", columns[c].Name, meta[7])
+			}
+			if Codec(meta[4].(int64)) == Gzip {
+				gz, err := gzip.NewReader(bytes.NewReader(body))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if body, err = ioutil.ReadAll(gz); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if int64(len(body)) != header[2].(int64) {
+				t.Errorf("column %s: uncompressed page size %d, expected %d", columns[c].Name, len(body), header[2])
+			}
+			dataPage := header[5].(map[int16]interface{})
+			if int(dataPage[1].(int64)) != numRows || int(meta[5].(int64)) != numRows {
+				t.Errorf("column %s: %d values in a row group of %d rows", columns[c].Name, dataPage[1], numRows)
+			}
+			values := readPage(t, body, columns[c].Type, numRows)
+			for i, v := range values {
+				groupRows[i][c] = v
+			}
+		}
+		rows = append(rows, groupRows...)
+	}
+	if int(metadata[3].(int64)) != len(rows) {
+		t.Errorf("file holds %d rows, expected %d", len(rows), metadata[3])
+	}
+	return columns, rows
+}
+
+// readPage decodes the definition levels and PLAIN values of a data page
+func readPage(t *testing.T, body []byte, typ Type, n int) []interface{} {
+	levelsSize := binary.LittleEndian.Uint32(body)
+	levels := body[4 : 4+levelsSize]
+	plain := body[4+levelsSize:]
+
+	// the RLE/bit-packing hybrid encoding of levels with a bit width of 1
+	var defined []bool
+	for len(levels) > 0 {
+		header, size := binary.Uvarint(levels)
+		levels = levels[size:]
+		if header&1 == 0 {
+			for i := uint64(0); i < header>>1; i++ {
+				defined = append(defined, levels[0] == 1)
+			}
+			levels = levels[1:]
+		} else {
+			groups := int(header >> 1)
+			for i := 0; i < groups*8; i++ {
+				defined = append(defined, levels[i/8]&(1<<uint(i%8)) != 0)
+			}
+			levels = levels[groups:]
+		}
+	}
+	if len(defined) < n {
+		t.Fatalf("%d definition levels for %d values", len(defined), n)
+	}
+
+	values := make([]interface{}, n)
+	bit := 0
+	for i := 0; i < n; i++ {
+		if !defined[i] {
+			continue
+		}
+		switch typ {
+		case String:
+			size := binary.LittleEndian.Uint32(plain)
+			values[i] = string(plain[4 : 4+size])
+			plain = plain[4+size:]
+		case Double:
+			values[i] = math.Float64frombits(binary.LittleEndian.Uint64(plain))
+			plain = plain[8:]
+		case Int64:
+			values[i] = int64(binary.LittleEndian.Uint64(plain))
+			plain = plain[8:]
+		case Boolean:
+			values[i] = plain[bit/8]&(1<<uint(bit%8)) != 0
+			bit++
+		}
+	}
+	if typ == Boolean {
+		plain = plain[(bit+7)/8:]
+	}
+	if len(plain) != 0 {
+		t.Errorf("%d bytes left after the values of a page", len(plain))
+	}
+	return values
+}
+
+// write writes rows to a Parquet file in memory
+func write(t *testing.T, columns []Column, rows [][]interface{}, codec Codec, rowGroupSize int) []byte {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, columns, codec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.RowGroupSize = rowGroupSize
+	for _, row := range rows {
+		if err := w.Write(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+var goldenColumns = []Column{
+	{Name: "id", Type: String},
+	{Name: "valueQuantity_value", Type: Double},
+	{Name: "count", Type: Int64},
+	{Name: "active", Type: Boolean},
+}
+
+var goldenRows = [][]interface{}{
+	{"p1", 36.6, int64(1), true},
+	{"Zoë", nil, int64(-2), false},
+	{nil, -0.5, nil, nil},
+	{"", math.MaxFloat64, int64(math.MaxInt64), true},
+	{"p5", 0.0, int64(0), nil},
+}
+
+// testdata/golden.parquet was read back with the reader of github.com/xitongsys/parquet-go v1.6.2 when generated, so
+// any change to the bytes the writer produces must be checked against an independent reader again
+func TestGolden(t *testing.T) {
+	data := write(t, goldenColumns, goldenRows, Uncompressed, 2)
+	golden := filepath.Join("testdata", "golden.parquet")
+	if *update {
+		if err := ioutil.WriteFile(golden, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, expected) {
+		t.Error("file differs from testdata/golden.parquet")
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	// more than 14 columns take the long form of a Thrift list header, and long runs of levels a multi-byte header
+	var columns []Column
+	for i := 0; i < 20; i++ {
+		columns = append(columns, Column{Name: fmt.Sprintf("code_coding_%d_code", i), Type: Type(i % 4)})
+	}
+	var rows [][]interface{}
+	for r := 0; r < 1000; r++ {
+		row := make([]interface{}, len(columns))
+		for c, column := range columns {
+			if r%(c+2) == 0 || (c == 1 && r > 300) {
+				continue // null
+			}
+			switch column.Type {
+			case String:
+				row[c] = fmt.Sprintf("value %d of %d", r, c)
+			case Double:
+				row[c] = float64(r) / 3
+			case Int64:
+				row[c] = int64(r * c)
+			case Boolean:
+				row[c] = r%3 == 0
+			}
+		}
+		rows = append(rows, row)
+	}
+	for _, codec := range []Codec{Uncompressed, Gzip} {
+		for _, rowGroupSize := range []int{7, DefaultRowGroupSize} {
+			gotColumns, gotRows := readFile(t, write(t, columns, rows, codec, rowGroupSize))
+			if !reflect.DeepEqual(gotColumns, columns) {
+				t.Errorf("codec %d: read columns %v", codec, gotColumns)
+			}
+			if !reflect.DeepEqual(gotRows, rows) {
+				t.Errorf("codec %d, row groups of %d: rows differ", codec, rowGroupSize)
+			}
+		}
+	}
+
+	gotColumns, gotRows := readFile(t, write(t, goldenColumns, goldenRows, Gzip, 2))
+	if !reflect.DeepEqual(gotColumns, goldenColumns) || !reflect.DeepEqual(gotRows, goldenRows) {
+		t.Errorf("read %v %v", gotColumns, gotRows)
+	}
+}
+
+func TestWriteRejectsMismatchedRows(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, goldenColumns, Uncompressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write([]interface{}{"p1"}); err == nil {
+		t.Error("expected an error for a row missing values")
+	}
+	if err := w.Write([]interface{}{"p1", "36.6", nil, nil}); err != nil {
+		t.Fatal(err) // values are checked when their row group is encoded
+	}
+	if err := w.Close(); err == nil {
+		t.Error("expected an error for a string in a DOUBLE column")
+	}
+}
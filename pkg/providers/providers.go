@@ -0,0 +1,239 @@
+package providers
+
+import (
+	"fmt"
+	"microsoft.com/divoc/pkg/fhir"
+	"microsoft.com/divoc/pkg/logger"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// SharedTypes are the resource types Synthea writes to its hospital and practitioner information Bundles and
+// which patients reference rather than own
+var SharedTypes = map[string]bool{
+	"Organization": true,
+	"Practitioner": true,
+	"Location":     true,
+}
+
+// BundleName is the name of the provider Bundle written for Bundle datasets
+const BundleName = "providerInformation.json"
+
+// Summary of a completed normalization
+type Summary struct {
+	Resources  map[string]int // canonical resources written per resource type
+	Duplicates map[string]int // duplicate resources merged away per resource type
+	References int            // references rewritten to a canonical id
+}
+
+// Normalize merges the shared Organization, Practitioner and Location resources of every FHIR directory in dirs.
+// Resources sharing an identifier (or id) are merged into a single canonical resource -- the one with the lowest id
+// -- which receives the union of their identifiers. Every reference to a merged resource is rewritten to the
+// canonical id and the shared resources are removed from their original files. The canonical resources are written to
+// the directory `out` as a single provider Bundle, or as one NDJSON file per resource type if the inputs are NDJSON.
+func Normalize(out string, dirs ...string) (summary Summary, err error) {
+	summary = Summary{Resources: map[string]int{}, Duplicates: map[string]int{}}
+
+	////////////////////////////////////////////////////////////////////////////////
+	// Collect shared resources
+	////////////////////////////////////////////////////////////////////////////////
+	var shared []fhir.Resource
+	format := fhir.FormatBundle
+	for _, dir := range dirs {
+		err := fhir.ReadDir(dir, func(file fhir.File, r fhir.Resource) error {
+			if file.Format == fhir.FormatNDJSON {
+				format = fhir.FormatNDJSON
+			}
+			if SharedTypes[r.ResourceType()] {
+				shared = append(shared, r)
+			}
+			return nil
+		})
+		if err != nil {
+			return summary, err
+		}
+	}
+	canonical, aliases := merge(shared)
+	for t, n := range countByType(shared) {
+		summary.Duplicates[t] = n - countByType(canonical)[t]
+	}
+	logger.Infof("Merged %d shared resources into %d canonical resources", len(shared), len(canonical))
+
+	////////////////////////////////////////////////////////////////////////////////
+	// Rewrite references and remove shared resources from the dataset
+	////////////////////////////////////////////////////////////////////////////////
+	for _, dir := range dirs {
+		files, err := fhir.ListFiles(dir)
+		if err != nil {
+			return summary, err
+		}
+		for _, file := range files {
+			err := fhir.RewriteFile(file, func(r fhir.Resource) (fhir.Resource, error) {
+				if SharedTypes[r.ResourceType()] {
+					return nil, nil
+				}
+				summary.References += rewriteReferences(r, aliases)
+				return r, nil
+			})
+			if err != nil {
+				return summary, fmt.Errorf("failed rewriting %s: %s", file.Path, err)
+			}
+		}
+	}
+
+	////////////////////////////////////////////////////////////////////////////////
+	// Write canonical resources
+	////////////////////////////////////////////////////////////////////////////////
+	for _, r := range canonical {
+		summary.References += rewriteReferences(r, aliases) // eg: Location.managingOrganization
+		summary.Resources[r.ResourceType()]++
+	}
+	if format == fhir.FormatNDJSON {
+		return summary, writeNDJSON(out, canonical)
+	}
+	bundle := fhir.NewBundle("batch")
+	for _, r := range canonical {
+		// PUT to the canonical id so reloading the provider Bundle never duplicates providers
+		fhir.AddEntry(bundle, r, "PUT")
+	}
+	return summary, fhir.WriteJSONFile(filepath.Join(out, BundleName), bundle)
+}
+
+// merge groups resources of the same type which share an id or identifier.
+// Returns the canonical resource of each group, sorted by type and id, and a map of every merged away
+// `<Type>/<id>` to the id of its canonical resource.
+func merge(resources []fhir.Resource) (canonical []fhir.Resource, aliases map[string]string) {
+	// union-find over resource indexes; resources sharing a key are unioned
+	parent := make([]int, len(resources))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	seen := map[string]int{}
+	for i, r := range resources {
+		keys := []string{r.ResourceType() + "/" + r.ID()}
+		for _, id := range r.Identifiers() {
+			keys = append(keys, r.ResourceType()+"?"+id.System+"|"+id.Value)
+		}
+		for _, k := range keys {
+			if j, ok := seen[k]; ok {
+				parent[find(i)] = find(j)
+			} else {
+				seen[k] = i
+			}
+		}
+	}
+
+	groups := map[int][]fhir.Resource{}
+	for i, r := range resources {
+		groups[find(i)] = append(groups[find(i)], r)
+	}
+	aliases = map[string]string{}
+	for _, group := range groups {
+		sort.Slice(group, func(i, j int) bool { return group[i].ID() < group[j].ID() })
+		winner := group[0]
+		known := map[fhir.Identifier]bool{}
+		for _, id := range winner.Identifiers() {
+			known[id] = true
+		}
+		for _, r := range group[1:] {
+			if r.ID() != winner.ID() {
+				aliases[r.ResourceType()+"/"+r.ID()] = winner.ID()
+			}
+			// carry over identifiers of the duplicate so conditional references to it still resolve
+			for _, id := range r.Identifiers() {
+				if !known[id] {
+					known[id] = true
+					identifiers, _ := winner["identifier"].([]interface{})
+					winner["identifier"] = append(identifiers, map[string]interface{}{"system": id.System, "value": id.Value})
+				}
+			}
+		}
+		canonical = append(canonical, winner)
+	}
+	sort.Slice(canonical, func(i, j int) bool {
+		if canonical[i].ResourceType() != canonical[j].ResourceType() {
+			return canonical[i].ResourceType() < canonical[j].ResourceType()
+		}
+		return canonical[i].ID() < canonical[j].ID()
+	})
+	return canonical, aliases
+}
+
+// rewriteReferences points every reference to a merged away resource at its canonical id, keeping the form of the
+// reference. References without a type (`urn:uuid:<id>`) are rewritten if the id belongs to a merged away resource of
+// any shared type. Returns the number of references rewritten.
+func rewriteReferences(r fhir.Resource, aliases map[string]string) (rewritten int) {
+	fhir.VisitReferences(r, func(s string) string {
+		ref, ok := fhir.ParseReference(s)
+		if !ok || ref.ID == "" {
+			return s
+		}
+		types := []string{ref.Type}
+		if ref.Type == "" {
+			types = nil
+			for t := range SharedTypes {
+				types = append(types, t)
+			}
+		}
+		for _, t := range types {
+			if id, ok := aliases[t+"/"+ref.ID]; ok {
+				rewritten++
+				if ref.Type == "" {
+					return "urn:uuid:" + id
+				}
+				return t + "/" + id
+			}
+		}
+		return s
+	})
+	return rewritten
+}
+
+// writeNDJSON writes the resources to one `<Type>.ndjson` file per resource type in dir
+func writeNDJSON(dir string, resources []fhir.Resource) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	byType := map[string][]fhir.Resource{}
+	for _, r := range resources {
+		byType[r.ResourceType()] = append(byType[r.ResourceType()], r)
+	}
+	for t, list := range byType {
+		f, err := os.Create(filepath.Join(dir, t+".ndjson"))
+		if err != nil {
+			return err
+		}
+		writer := fhir.NewNDJSONWriter(f)
+		for _, r := range list {
+			if err := writer.Write(r); err != nil {
+				f.Close()
+				return err
+			}
+		}
+		if err := writer.Flush(); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// countByType returns the number of resources of each resource type
+func countByType(resources []fhir.Resource) map[string]int {
+	counts := map[string]int{}
+	for _, r := range resources {
+		counts[r.ResourceType()]++
+	}
+	return counts
+}
@@ -0,0 +1,72 @@
+package rest
+
+import (
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// ReadAll reads r whole and calls fn with its content, holding one chunk of the memory budget of the client until fn
+// returns; eg: to upload a small file with a single request
+func (c *Client) ReadAll(r io.Reader, fn func(data []byte) error) error {
+	c.chunks <- struct{}{}
+	defer func() { <-c.chunks }()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return fn(data)
+}
+
+// UploadChunks reads r in chunks of size bytes and calls upload with the index and content of every chunk from
+// parallel goroutines; eg: the blocks of a blob. Each chunk holds one chunk of the memory budget of the client, shared
+// by every upload, until its upload returns. Stops reading at the first failure. Returns the number of chunks read,
+// and the first error once every started upload returned.
+func (c *Client) UploadChunks(r io.Reader, size int64, upload func(i int, chunk []byte) error) (int, error) {
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	var uploadErr error
+	failed := func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return uploadErr != nil
+	}
+
+	chunks := 0
+	for {
+		c.chunks <- struct{}{}
+		// failed uploads release their chunk after recording their error
+		if failed() {
+			<-c.chunks
+			break
+		}
+		chunk := make([]byte, size)
+		n, err := io.ReadFull(r, chunk)
+		if n == 0 {
+			<-c.chunks
+		} else {
+			wg.Add(1)
+			go func(i int, chunk []byte) {
+				defer wg.Done()
+				defer func() { <-c.chunks }()
+				if err := upload(i, chunk); err != nil {
+					lock.Lock()
+					if uploadErr == nil {
+						uploadErr = err
+					}
+					lock.Unlock()
+				}
+			}(chunks, chunk[:n])
+			chunks++
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			wg.Wait()
+			return chunks, err
+		}
+	}
+	wg.Wait()
+	return chunks, uploadErr
+}
@@ -0,0 +1,170 @@
+package rest
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"microsoft.com/divoc/pkg/logger"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Defaults of the Client settings
+const (
+	DefaultConcurrency = 16
+	DefaultMaxRetries  = 5
+)
+
+// Client sends requests to a REST API. It bounds the requests in flight and the chunks of uploads held in memory, and
+// retries failed requests.
+type Client struct {
+	HTTPClient *http.Client
+	MaxRetries int // retries of a failed request
+	// Prepare completes every attempt of a request before it is sent; eg: dates its headers and signs it
+	Prepare func(req *http.Request, body []byte) error
+
+	sem    chan struct{} // bounds the number of requests in flight
+	chunks chan struct{} // bounds the chunks held in memory
+}
+
+// NewClient returns a Client with the default settings. concurrency bounds the requests in flight, and the chunks held
+// in memory, across every request of the client; <= 0 uses DefaultConcurrency.
+func NewClient(concurrency int, prepare func(req *http.Request, body []byte) error) *Client {
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+	return &Client{
+		HTTPClient: http.DefaultClient,
+		MaxRetries: DefaultMaxRetries,
+		Prepare:    prepare,
+		sem:        make(chan struct{}, concurrency),
+		chunks:     make(chan struct{}, concurrency),
+	}
+}
+
+// Response of a REST API
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// StatusError is a response with a status other than 2xx
+type StatusError struct {
+	Method     string
+	URL        string // without its query, which may hold a secret
+	StatusCode int
+	Body       []byte
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s %s: unexpected status %d: %s", e.Method, e.URL, e.StatusCode, e.Body)
+}
+
+// Concurrency returns the number of requests the client sends in parallel
+func (c *Client) Concurrency() int {
+	return cap(c.sem)
+}
+
+// Do sends a request, retrying failures with exponential backoff, or after the delay of a Retry-After header.
+// Requests with an idempotent method (GET, HEAD, PUT, DELETE, OPTIONS) are retried after network errors, throttling
+// and server errors. Other requests may have taken effect on the server despite failing, so they are only retried when
+// the server asks for it: a 429 or 503 response with a Retry-After header. Returns a StatusError, along with the
+// response, for responses other than 2xx.
+func (c *Client) Do(method string, target string, header http.Header, body []byte) (*Response, error) {
+	return c.do(method, target, header, body, idempotentMethods[method])
+}
+
+// DoIdempotent sends a request which is safe to send twice whatever its method, retrying it like an idempotent one;
+// eg: completing an S3 multipart upload
+func (c *Client) DoIdempotent(method string, target string, header http.Header, body []byte) (*Response, error) {
+	return c.do(method, target, header, body, true)
+}
+
+// idempotentMethods are the methods of requests which have the same effect however many times they are sent
+var idempotentMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPut:     true,
+	http.MethodDelete:  true,
+	http.MethodOptions: true,
+}
+
+// do sends a request, retrying it under the rules of Do
+func (c *Client) do(method string, target string, header http.Header, body []byte, idempotent bool) (*Response, error) {
+	c.sem <- struct{}{}
+	defer func() { <-c.sem }()
+
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		resp, retry, err := c.send(method, target, header, body, idempotent)
+		if err == nil || !retry || attempt >= c.MaxRetries {
+			return resp, err
+		}
+		delay := backoff
+		if resp != nil {
+			if seconds, parseErr := strconv.Atoi(resp.Header.Get("Retry-After")); parseErr == nil && seconds > 0 {
+				delay = time.Duration(seconds) * time.Second
+			}
+		}
+		logger.Debugf("Retrying %s %s in %s: %s", method, withoutQuery(target), delay, err)
+		time.Sleep(delay)
+		backoff *= 2
+	}
+}
+
+// send sends a request once. Returns whether a failed request may be retried, with the response if there was one.
+func (c *Client) send(method string, target string, header http.Header, body []byte, idempotent bool) (*Response, bool, error) {
+	req, err := http.NewRequest(method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, false, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.ContentLength = int64(len(body))
+	if len(body) == 0 {
+		req.Body = nil
+	}
+	if c.Prepare != nil {
+		if err := c.Prepare(req, body); err != nil {
+			return nil, false, err
+		}
+	}
+	httpResp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		if urlErr, ok := err.(*url.Error); ok {
+			urlErr.URL = withoutQuery(urlErr.URL) // eg: a SAS added by Prepare
+		}
+		return nil, idempotent, err
+	}
+	defer httpResp.Body.Close()
+	respBody, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, idempotent, err
+	}
+	resp := &Response{StatusCode: httpResp.StatusCode, Header: httpResp.Header, Body: respBody}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var retry bool
+		if idempotent {
+			retry = resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode >= 500
+		} else {
+			throttled := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable
+			retry = throttled && resp.Header.Get("Retry-After") != ""
+		}
+		return resp, retry, &StatusError{Method: method, URL: withoutQuery(target), StatusCode: resp.StatusCode, Body: respBody}
+	}
+	return resp, false, nil
+}
+
+// withoutQuery returns a URL without its query
+func withoutQuery(target string) string {
+	u, err := url.Parse(target)
+	if err != nil {
+		return target
+	}
+	u.RawQuery = ""
+	return u.String()
+}
@@ -0,0 +1,210 @@
+package rest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDoRetries(t *testing.T) {
+	var lock sync.Mutex
+	responses := []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		if r.Header.Get("X-Prepared") != "yes" {
+			t.Error("request not prepared")
+		}
+		status := responses[0]
+		responses = responses[1:]
+		if status == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "1")
+		}
+		w.WriteHeader(status)
+		fmt.Fprint(w, "body")
+	}))
+	defer server.Close()
+
+	client := NewClient(1, func(req *http.Request, body []byte) error {
+		req.Header.Set("X-Prepared", "yes")
+		return nil
+	})
+	start := time.Now()
+	resp, err := client.Do(http.MethodGet, server.URL+"/a?sig=secret", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || string(resp.Body) != "body" {
+		t.Errorf("unexpected response %d %s", resp.StatusCode, resp.Body)
+	}
+	// 1s of backoff, then 1s of Retry-After
+	if elapsed := time.Since(start); elapsed < 2*time.Second {
+		t.Errorf("retried after %s", elapsed)
+	}
+}
+
+func TestDoStatusError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "missing")
+	}))
+	defer server.Close()
+
+	client := NewClient(1, nil)
+	resp, err := client.Do(http.MethodGet, server.URL+"/a?sig=secret", nil, nil)
+	statusErr, ok := err.(*StatusError)
+	if !ok || statusErr.StatusCode != http.StatusNotFound || resp == nil || resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected a 404 StatusError and response, got %v", err)
+	}
+	if strings.Contains(err.Error(), "secret") {
+		t.Errorf("error holds the query: %s", err)
+	}
+
+	client.MaxRetries = 0
+	_, err = client.Do(http.MethodGet, "http://127.0.0.1:1/a?sig=secret", nil, nil)
+	if err == nil || strings.Contains(err.Error(), "secret") {
+		t.Errorf("expected a network error without the query, got %v", err)
+	}
+}
+
+func TestUploadChunks(t *testing.T) {
+	client := NewClient(3, nil)
+	data := make([]byte, 1050)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	var lock sync.Mutex
+	held, maxHeld := 0, 0
+	var wg sync.WaitGroup
+	results := make([][]byte, 4)
+	for file := 0; file < 4; file++ {
+		results[file] = make([]byte, len(data))
+		wg.Add(1)
+		go func(file int) {
+			defer wg.Done()
+			chunks, err := client.UploadChunks(bytes.NewReader(data), 100, func(i int, chunk []byte) error {
+				lock.Lock()
+				held++
+				if held > maxHeld {
+					maxHeld = held
+				}
+				lock.Unlock()
+				time.Sleep(time.Millisecond)
+				copy(results[file][i*100:], chunk)
+				lock.Lock()
+				held--
+				lock.Unlock()
+				return nil
+			})
+			if err != nil || chunks != 11 {
+				t.Errorf("file %d: %d chunks, %v", file, chunks, err)
+			}
+		}(file)
+	}
+	wg.Wait()
+	if maxHeld > 3 {
+		t.Errorf("%d chunks held at once across uploads, expected at most 3", maxHeld)
+	}
+	for file, result := range results {
+		if !bytes.Equal(result, data) {
+			t.Errorf("file %d: chunks do not match", file)
+		}
+	}
+}
+
+func TestUploadChunksStopsAtFirstError(t *testing.T) {
+	client := NewClient(1, nil)
+	failure := errors.New("failed")
+	uploaded := 0
+	_, err := client.UploadChunks(bytes.NewReader(make([]byte, 1000)), 100, func(i int, chunk []byte) error {
+		uploaded++
+		if i == 2 {
+			return failure
+		}
+		return nil
+	})
+	if err != failure {
+		t.Errorf("expected the upload error, got %v", err)
+	}
+	if uploaded != 3 {
+		t.Errorf("%d chunks uploaded after the failure of the third", uploaded)
+	}
+}
+
+func TestDoRetriesPOSTOnlyWhenThrottled(t *testing.T) {
+	var lock sync.Mutex
+	var requests int
+	var responses []func(w http.ResponseWriter)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		requests++
+		respond := responses[0]
+		responses = responses[1:]
+		respond(w)
+	}))
+	defer server.Close()
+	status := func(status int, retryAfter string) func(w http.ResponseWriter) {
+		return func(w http.ResponseWriter) {
+			if retryAfter != "" {
+				w.Header().Set("Retry-After", retryAfter)
+			}
+			w.WriteHeader(status)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		responses []func(w http.ResponseWriter)
+		requests  int
+	}{
+		{"server error", []func(w http.ResponseWriter){status(http.StatusInternalServerError, "")}, 1},
+		{"unavailable without Retry-After", []func(w http.ResponseWriter){status(http.StatusServiceUnavailable, "")}, 1},
+		{"throttled without Retry-After", []func(w http.ResponseWriter){status(http.StatusTooManyRequests, "")}, 1},
+		{"unavailable with Retry-After", []func(w http.ResponseWriter){status(http.StatusServiceUnavailable, "1"), status(http.StatusOK, "")}, 2},
+		{"throttled with Retry-After", []func(w http.ResponseWriter){status(http.StatusTooManyRequests, "1"), status(http.StatusOK, "")}, 2},
+	}
+	client := NewClient(1, nil)
+	for _, test := range tests {
+		requests, responses = 0, test.responses
+		client.Do(http.MethodPost, server.URL, nil, []byte("{}"))
+		if requests != test.requests {
+			t.Errorf("%s: POST sent %d times, expected %d", test.name, requests, test.requests)
+		}
+	}
+
+	// a POST marked idempotent is retried like a PUT
+	requests, responses = 0, []func(w http.ResponseWriter){status(http.StatusInternalServerError, ""), status(http.StatusOK, "")}
+	if _, err := client.DoIdempotent(http.MethodPost, server.URL, nil, nil); err != nil || requests != 2 {
+		t.Errorf("idempotent POST sent %d times: %v", requests, err)
+	}
+}
+
+func TestDoRetriesOnlyIdempotentNetworkErrors(t *testing.T) {
+	var requests int
+	client := NewClient(1, nil)
+	client.MaxRetries = 1
+	client.HTTPClient = &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		requests++
+		return nil, errors.New("connection reset")
+	})}
+	for method, expected := range map[string]int{http.MethodPut: 2, http.MethodPost: 1} {
+		requests = 0
+		if _, err := client.Do(method, "http://example.com/a", nil, nil); err == nil || requests != expected {
+			t.Errorf("%s sent %d times after network errors, expected %d: %v", method, requests, expected, err)
+		}
+	}
+}
+
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
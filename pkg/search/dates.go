@@ -0,0 +1,115 @@
+package search
+
+import (
+	"strings"
+	"time"
+)
+
+// dateRange is the span of time covered by a date, dateTime, instant or Period: [start, end).
+// A zero start or end is unbounded.
+type dateRange struct {
+	start time.Time
+	end   time.Time
+}
+
+// date layouts by precision, with the duration each covers
+var dateLayouts = []struct {
+	layout string
+	length int
+	add    func(time.Time) time.Time
+}{
+	{"2006", 4, func(t time.Time) time.Time { return t.AddDate(1, 0, 0) }},
+	{"2006-01", 7, func(t time.Time) time.Time { return t.AddDate(0, 1, 0) }},
+	{"2006-01-02", 10, func(t time.Time) time.Time { return t.AddDate(0, 0, 1) }},
+	{"2006-01-02T15:04", 16, func(t time.Time) time.Time { return t.Add(time.Minute) }},
+}
+
+// zonelessLayout is the layout of a dateTime without a time zone, to the second or finer
+const zonelessLayout = "2006-01-02T15:04:05.999999999"
+
+// datePrefixes are the supported comparison prefixes of date values
+var datePrefixes = []string{"eq", "ne", "gt", "lt", "ge", "le", "sa", "eb"}
+
+// datePrefix splits the comparison prefix from a date value. The prefix defaults to eq.
+func datePrefix(s string) (string, string) {
+	for _, prefix := range datePrefixes {
+		if strings.HasPrefix(s, prefix) {
+			return prefix, s[len(prefix):]
+		}
+	}
+	return "eq", s
+}
+
+// parseDate returns the range covered by a date, dateTime or instant at its precision; eg: 2020-03 covers March 2020.
+// Dates and dateTimes without a time zone are taken as UTC.
+func parseDate(s string) (dateRange, bool) {
+	for _, l := range dateLayouts {
+		if len(s) == l.length {
+			t, err := time.Parse(l.layout, s)
+			if err != nil {
+				return dateRange{}, false
+			}
+			return dateRange{t, l.add(t)}, true
+		}
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		if t, err = time.Parse(zonelessLayout, s); err != nil {
+			return dateRange{}, false
+		}
+	}
+	precision := time.Second
+	if strings.Contains(s, ".") {
+		precision = time.Millisecond
+	}
+	return dateRange{t, t.Add(precision)}, true
+}
+
+// elementRange returns the range of a date, dateTime, instant or Period element
+func elementRange(element interface{}) (dateRange, bool) {
+	switch node := element.(type) {
+	case string:
+		return parseDate(node)
+	case map[string]interface{}:
+		var period dateRange
+		start, _ := node["start"].(string)
+		end, _ := node["end"].(string)
+		if start == "" && end == "" {
+			return period, false
+		}
+		if r, ok := parseDate(start); ok {
+			period.start = r.start
+		}
+		if r, ok := parseDate(end); ok {
+			period.end = r.end
+		}
+		return period, true
+	}
+	return dateRange{}, false
+}
+
+// compareDates reports whether the target range satisfies a prefixed search range
+func compareDates(prefix string, search dateRange, target dateRange) bool {
+	// target bounds with unbounded ends treated as infinitely early or late
+	startsBefore := func(t time.Time) bool { return target.start.IsZero() || target.start.Before(t) }
+	endsAfter := func(t time.Time) bool { return target.end.IsZero() || target.end.After(t) }
+	eq := !target.start.IsZero() && !target.end.IsZero() &&
+		!target.start.Before(search.start) && !target.end.After(search.end)
+	switch prefix {
+	case "ne":
+		return !eq
+	case "gt":
+		return endsAfter(search.end)
+	case "lt":
+		return startsBefore(search.start)
+	case "ge":
+		return eq || endsAfter(search.end)
+	case "le":
+		return eq || startsBefore(search.start)
+	case "sa":
+		return !target.start.IsZero() && !target.start.Before(search.end)
+	case "eb":
+		return !target.end.IsZero() && !target.end.After(search.start)
+	}
+	return eq
+}
@@ -0,0 +1,87 @@
+package search
+
+import (
+	"testing"
+	"time"
+)
+
+func utc(year int, month time.Month, day, hour, min, sec, nsec int) time.Time {
+	return time.Date(year, month, day, hour, min, sec, nsec, time.UTC)
+}
+
+func TestParseDate(t *testing.T) {
+	tests := []struct {
+		value string
+		start time.Time
+		end   time.Time
+	}{
+		{"2020", utc(2020, 1, 1, 0, 0, 0, 0), utc(2021, 1, 1, 0, 0, 0, 0)},
+		{"2020-02", utc(2020, 2, 1, 0, 0, 0, 0), utc(2020, 3, 1, 0, 0, 0, 0)},
+		{"2020-02-28", utc(2020, 2, 28, 0, 0, 0, 0), utc(2020, 2, 29, 0, 0, 0, 0)},
+		{"2020-01-01T10:00", utc(2020, 1, 1, 10, 0, 0, 0), utc(2020, 1, 1, 10, 1, 0, 0)},
+		{"2020-01-01T10:00:00", utc(2020, 1, 1, 10, 0, 0, 0), utc(2020, 1, 1, 10, 0, 1, 0)},
+		{"2020-01-01T10:00:00.250", utc(2020, 1, 1, 10, 0, 0, 250e6), utc(2020, 1, 1, 10, 0, 0, 251e6)},
+		{"2020-01-01T10:00:00Z", utc(2020, 1, 1, 10, 0, 0, 0), utc(2020, 1, 1, 10, 0, 1, 0)},
+		{"2020-01-01T10:00:00+02:00", utc(2020, 1, 1, 8, 0, 0, 0), utc(2020, 1, 1, 8, 0, 1, 0)},
+		{"2020-01-01T10:00:00.5-05:00", utc(2020, 1, 1, 15, 0, 0, 500e6), utc(2020, 1, 1, 15, 0, 0, 501e6)},
+	}
+	for _, test := range tests {
+		r, ok := parseDate(test.value)
+		if !ok {
+			t.Errorf("%s: not parsed", test.value)
+			continue
+		}
+		if !r.start.Equal(test.start) || !r.end.Equal(test.end) {
+			t.Errorf("%s: covers [%s, %s), expected [%s, %s)", test.value, r.start, r.end, test.start, test.end)
+		}
+	}
+	for _, value := range []string{"", "20", "2020-13", "2020-02-30", "2020-01-01T25:00", "2020-01-01 10:00:00", "yesterday"} {
+		if _, ok := parseDate(value); ok {
+			t.Errorf("%q: parsed", value)
+		}
+	}
+}
+
+func TestCompareDates(t *testing.T) {
+	march, _ := parseDate("2020-03")
+	tests := []struct {
+		name   string
+		target interface{} // a dateTime or a Period
+		// whether eq, ne, gt, lt, ge, le, sa and eb match March 2020
+		expected [8]bool
+	}{
+		{"within", "2020-03-15T10:00:00Z", [8]bool{true, false, false, false, true, true, false, false}},
+		{"whole range", "2020-03", [8]bool{true, false, false, false, true, true, false, false}},
+		{"before", "2020-02-29T23:59:59Z", [8]bool{false, true, false, true, false, true, false, true}},
+		{"after", "2020-04-01", [8]bool{false, true, true, false, true, false, true, false}},
+		{"containing", "2020", [8]bool{false, true, true, true, true, true, false, false}},
+		{"overlapping start", map[string]interface{}{"start": "2020-02-20", "end": "2020-03-10"}, [8]bool{false, true, false, true, false, true, false, false}},
+		{"overlapping end", map[string]interface{}{"start": "2020-03-20", "end": "2020-04-10"}, [8]bool{false, true, true, false, true, false, false, false}},
+		{"period within", map[string]interface{}{"start": "2020-03-02T08:00:00Z", "end": "2020-03-02T09:00:00Z"}, [8]bool{true, false, false, false, true, true, false, false}},
+		{"period before", map[string]interface{}{"start": "2020-01-01", "end": "2020-02-01"}, [8]bool{false, true, false, true, false, true, false, true}},
+		{"period after", map[string]interface{}{"start": "2020-04-01", "end": "2020-05-01"}, [8]bool{false, true, true, false, true, false, true, false}},
+		{"open end, starting within", map[string]interface{}{"start": "2020-03-10"}, [8]bool{false, true, true, false, true, false, false, false}},
+		{"open end, starting after", map[string]interface{}{"start": "2020-04-10"}, [8]bool{false, true, true, false, true, false, true, false}},
+		{"open end, starting before", map[string]interface{}{"start": "2020-01-10"}, [8]bool{false, true, true, true, true, true, false, false}},
+		{"open start, ending within", map[string]interface{}{"end": "2020-03-10"}, [8]bool{false, true, false, true, false, true, false, false}},
+		{"open start, ending before", map[string]interface{}{"end": "2020-02-10"}, [8]bool{false, true, false, true, false, true, false, true}},
+		{"open start, ending after", map[string]interface{}{"end": "2020-04-10"}, [8]bool{false, true, true, true, true, true, false, false}},
+	}
+	prefixes := [8]string{"eq", "ne", "gt", "lt", "ge", "le", "sa", "eb"}
+	for _, test := range tests {
+		target, ok := elementRange(test.target)
+		if !ok {
+			t.Errorf("%s: no range", test.name)
+			continue
+		}
+		for i, prefix := range prefixes {
+			if got := compareDates(prefix, march, target); got != test.expected[i] {
+				t.Errorf("%s: %s2020-03 = %v, expected %v", test.name, prefix, got, test.expected[i])
+			}
+		}
+	}
+
+	if _, ok := elementRange(map[string]interface{}{}); ok {
+		t.Error("a Period without start or end has a range")
+	}
+}
@@ -0,0 +1,202 @@
+package search
+
+// Type of a search parameter
+type Type int
+
+const (
+	Token Type = iota
+	Reference
+	Date
+	String
+)
+
+// Definition of a search parameter: its type and the element paths it searches. Paths are dot separated element
+// names; arrays along a path are searched element by element.
+type Definition struct {
+	Type  Type
+	Paths []string
+}
+
+// common parameters of every resource type
+var common = map[string]Definition{
+	"_id":          {Token, []string{"id"}},
+	"_lastUpdated": {Date, []string{"meta.lastUpdated"}},
+}
+
+// clinical parameters shared by most resources about a patient
+var (
+	patient   = Definition{Reference, []string{"subject", "patient", "beneficiary"}}
+	subject   = Definition{Reference, []string{"subject", "patient"}}
+	encounter = Definition{Reference, []string{"encounter", "context"}}
+	status    = Definition{Token, []string{"status"}}
+	category  = Definition{Token, []string{"category"}}
+)
+
+// Parameters holds the supported search parameters of each resource type, keyed by type then parameter name.
+// Parameters of the "" type are supported by every resource type that has no definition of its own.
+var Parameters = map[string]map[string]Definition{
+	"": {
+		"patient":    patient,
+		"subject":    subject,
+		"encounter":  encounter,
+		"status":     status,
+		"code":       {Token, []string{"code"}},
+		"identifier": {Token, []string{"identifier"}},
+	},
+	"AllergyIntolerance": {
+		"patient":         patient,
+		"code":            {Token, []string{"code"}},
+		"clinical-status": {Token, []string{"clinicalStatus"}},
+		"category":        {Token, []string{"category"}},
+		"date":            {Date, []string{"recordedDate"}},
+	},
+	"CarePlan": {
+		"patient":   patient,
+		"subject":   subject,
+		"encounter": encounter,
+		"status":    status,
+		"category":  category,
+		"date":      {Date, []string{"period"}},
+	},
+	"CareTeam": {
+		"patient":   patient,
+		"subject":   subject,
+		"encounter": encounter,
+		"status":    status,
+		"date":      {Date, []string{"period"}},
+	},
+	"Claim": {
+		"patient":  patient,
+		"status":   status,
+		"use":      {Token, []string{"use"}},
+		"created":  {Date, []string{"created"}},
+		"provider": {Reference, []string{"provider"}},
+	},
+	"Condition": {
+		"patient":         patient,
+		"subject":         subject,
+		"encounter":       encounter,
+		"code":            {Token, []string{"code"}},
+		"category":        category,
+		"clinical-status": {Token, []string{"clinicalStatus"}},
+		"onset-date":      {Date, []string{"onsetDateTime", "onsetPeriod"}},
+		"abatement-date":  {Date, []string{"abatementDateTime", "abatementPeriod"}},
+		"recorded-date":   {Date, []string{"recordedDate"}},
+	},
+	"DiagnosticReport": {
+		"patient":   patient,
+		"subject":   subject,
+		"encounter": encounter,
+		"status":    status,
+		"code":      {Token, []string{"code"}},
+		"category":  category,
+		"date":      {Date, []string{"effectiveDateTime", "effectivePeriod"}},
+		"issued":    {Date, []string{"issued"}},
+		"result":    {Reference, []string{"result"}},
+	},
+	"Encounter": {
+		"patient":          patient,
+		"subject":          subject,
+		"status":           status,
+		"class":            {Token, []string{"class"}},
+		"type":             {Token, []string{"type"}},
+		"date":             {Date, []string{"period"}},
+		"reason-code":      {Token, []string{"reasonCode"}},
+		"participant":      {Reference, []string{"participant.individual"}},
+		"service-provider": {Reference, []string{"serviceProvider"}},
+		"location":         {Reference, []string{"location.location"}},
+	},
+	"ExplanationOfBenefit": {
+		"patient":  patient,
+		"status":   status,
+		"created":  {Date, []string{"created"}},
+		"claim":    {Reference, []string{"claim"}},
+		"provider": {Reference, []string{"provider"}},
+	},
+	"Immunization": {
+		"patient":      patient,
+		"encounter":    encounter,
+		"status":       status,
+		"vaccine-code": {Token, []string{"vaccineCode"}},
+		"date":         {Date, []string{"occurrenceDateTime"}},
+	},
+	"Location": {
+		"name":          {String, []string{"name"}},
+		"identifier":    {Token, []string{"identifier"}},
+		"address":       {String, []string{"address"}},
+		"address-city":  {String, []string{"address.city"}},
+		"address-state": {String, []string{"address.state"}},
+		"organization":  {Reference, []string{"managingOrganization"}},
+	},
+	"MedicationRequest": {
+		"patient":    patient,
+		"subject":    subject,
+		"encounter":  encounter,
+		"status":     status,
+		"intent":     {Token, []string{"intent"}},
+		"code":       {Token, []string{"medicationCodeableConcept"}},
+		"authoredon": {Date, []string{"authoredOn"}},
+		"requester":  {Reference, []string{"requester"}},
+	},
+	"Observation": {
+		"patient":        patient,
+		"subject":        subject,
+		"encounter":      encounter,
+		"status":         status,
+		"code":           {Token, []string{"code"}},
+		"component-code": {Token, []string{"component.code"}},
+		"category":       category,
+		"date":           {Date, []string{"effectiveDateTime", "effectivePeriod", "effectiveInstant"}},
+		"value-concept":  {Token, []string{"valueCodeableConcept"}},
+		"value-string":   {String, []string{"valueString"}},
+	},
+	"Organization": {
+		"name":          {String, []string{"name"}},
+		"identifier":    {Token, []string{"identifier"}},
+		"type":          {Token, []string{"type"}},
+		"address":       {String, []string{"address"}},
+		"address-city":  {String, []string{"address.city"}},
+		"address-state": {String, []string{"address.state"}},
+	},
+	"Patient": {
+		"identifier":    {Token, []string{"identifier"}},
+		"gender":        {Token, []string{"gender"}},
+		"birthdate":     {Date, []string{"birthDate"}},
+		"death-date":    {Date, []string{"deceasedDateTime"}},
+		"name":          {String, []string{"name"}},
+		"family":        {String, []string{"name.family"}},
+		"given":         {String, []string{"name.given"}},
+		"address":       {String, []string{"address"}},
+		"address-city":  {String, []string{"address.city"}},
+		"address-state": {String, []string{"address.state"}},
+		"language":      {Token, []string{"communication.language"}},
+	},
+	"Practitioner": {
+		"identifier": {Token, []string{"identifier"}},
+		"name":       {String, []string{"name"}},
+		"family":     {String, []string{"name.family"}},
+		"given":      {String, []string{"name.given"}},
+		"gender":     {Token, []string{"gender"}},
+	},
+	"Procedure": {
+		"patient":   patient,
+		"subject":   subject,
+		"encounter": encounter,
+		"status":    status,
+		"code":      {Token, []string{"code"}},
+		"date":      {Date, []string{"performedDateTime", "performedPeriod"}},
+	},
+}
+
+// lookup returns the definition of a search parameter of a resource type
+func lookup(resourceType string, name string) (Definition, bool) {
+	if def, ok := common[name]; ok {
+		return def, true
+	}
+	params, ok := Parameters[resourceType]
+	if !ok {
+		params = Parameters[""]
+	}
+	def, ok := params[name]
+	return def, ok
+}
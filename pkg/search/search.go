@@ -0,0 +1,324 @@
+package search
+
+import (
+	"errors"
+	"fmt"
+	"microsoft.com/divoc/pkg/fhir"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Query is a parsed FHIR search; eg: `Observation?code=http://loinc.org|8867-4&subject=Patient/123`.
+// Parameters are ANDed together and comma separated values of a parameter are ORed.
+type Query struct {
+	Type   string
+	Count  int // maximum number of matches (_count) -- 0 for no limit
+	params []param
+}
+
+// param is a parsed search parameter
+type param struct {
+	name     string
+	modifier string
+	def      Definition
+	values   []value
+}
+
+// value is a parsed search parameter value -- only the field of the parameter type is set
+type value struct {
+	raw    string
+	token  fhir.Token
+	ref    fhir.Reference
+	prefix string
+	date   dateRange
+}
+
+// Parse a FHIR search in the form `<Type>[?<name>[:modifier]=<value>[,<value>...][&...]]`.
+// Supported modifiers are `missing` for every parameter type, `not` for tokens, `exact` and `contains` for strings and
+// a resource type for references (eg: `subject:Patient=123`).
+func Parse(s string) (*Query, error) {
+	resourceType, rawQuery := s, ""
+	if i := strings.Index(s, "?"); i >= 0 {
+		resourceType, rawQuery = s[:i], s[i+1:]
+	}
+	resourceType = strings.TrimPrefix(resourceType, "/")
+	if resourceType == "" || strings.ToUpper(resourceType[:1]) != resourceType[:1] {
+		return nil, fmt.Errorf("search %q must start with a resource type; eg: Observation?code=8867-4", s)
+	}
+	q := &Query{Type: resourceType}
+	for _, pair := range strings.Split(rawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+		i := strings.Index(pair, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("search parameter %q has no value", pair)
+		}
+		// not url.ParseQuery: '+' is part of timezone offsets, not an encoded space
+		name, err := url.PathUnescape(pair[:i])
+		if err != nil {
+			return nil, err
+		}
+		rawValue, err := url.PathUnescape(pair[i+1:])
+		if err != nil {
+			return nil, err
+		}
+		if name == "_count" {
+			if q.Count, err = strconv.Atoi(rawValue); err != nil || q.Count < 0 {
+				return nil, fmt.Errorf("_count must be a positive number: %s", rawValue)
+			}
+			continue
+		}
+		p, err := parseParam(resourceType, name, rawValue)
+		if err != nil {
+			return nil, err
+		}
+		q.params = append(q.params, p)
+	}
+	return q, nil
+}
+
+// parseParam parses a single search parameter
+func parseParam(resourceType string, name string, rawValue string) (param, error) {
+	p := param{name: name}
+	if i := strings.Index(name, ":"); i >= 0 {
+		p.name, p.modifier = name[:i], name[i+1:]
+	}
+	def, ok := lookup(resourceType, p.name)
+	if !ok {
+		return p, fmt.Errorf("unsupported search parameter %s for %s", p.name, resourceType)
+	}
+	p.def = def
+	switch {
+	case p.modifier == "" || p.modifier == "missing":
+	case def.Type == Token && p.modifier == "not":
+	case def.Type == String && (p.modifier == "exact" || p.modifier == "contains"):
+	case def.Type == Reference && strings.ToUpper(p.modifier[:1]) == p.modifier[:1]:
+	default:
+		return p, fmt.Errorf("unsupported modifier :%s of search parameter %s", p.modifier, p.name)
+	}
+
+	for _, raw := range strings.Split(rawValue, ",") {
+		v := value{raw: raw}
+		if p.modifier == "missing" {
+			if raw != "true" && raw != "false" {
+				return p, fmt.Errorf("%s:missing must be true or false", p.name)
+			}
+			p.values = append(p.values, v)
+			continue
+		}
+		switch def.Type {
+		case Token:
+			v.token = fhir.ParseToken(raw)
+		case Reference:
+			ref, ok := fhir.ParseReference(raw)
+			if !ok || ref.ID == "" {
+				return p, fmt.Errorf("invalid reference %q of search parameter %s", raw, p.name)
+			}
+			if p.modifier != "" {
+				ref.Type = p.modifier
+			}
+			v.ref = ref
+		case Date:
+			v.prefix, raw = datePrefix(raw)
+			date, ok := parseDate(raw)
+			if !ok {
+				return p, fmt.Errorf("invalid date %q of search parameter %s", raw, p.name)
+			}
+			v.date = date
+		case String:
+			v.raw = strings.ToLower(raw)
+		}
+		p.values = append(p.values, v)
+	}
+	return p, nil
+}
+
+// Matches reports whether the resource satisfies the query
+func (q *Query) Matches(r fhir.Resource) bool {
+	if r.ResourceType() != q.Type {
+		return false
+	}
+	for _, p := range q.params {
+		if !p.matches(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// matches reports whether any value of the parameter is satisfied by the resource
+func (p param) matches(r fhir.Resource) bool {
+	var elements []interface{}
+	for _, path := range p.def.Paths {
+		elements = append(elements, collect(map[string]interface{}(r), strings.Split(path, "."))...)
+	}
+	if p.modifier == "not" {
+		// the resource must match none of the values -- eg: code:not=a,b excludes both codes
+		for _, v := range p.values {
+			if p.matchesAnyElement(v, elements) {
+				return false
+			}
+		}
+		return true
+	}
+	for _, v := range p.values {
+		if p.modifier == "missing" {
+			if (len(elements) == 0) == (v.raw == "true") {
+				return true
+			}
+			continue
+		}
+		if p.matchesAnyElement(v, elements) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesAnyElement reports whether any of the elements satisfies a value of the parameter
+func (p param) matchesAnyElement(v value, elements []interface{}) bool {
+	for _, element := range elements {
+		if p.matchesElement(v, element) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesElement reports whether an element satisfies a value of the parameter
+func (p param) matchesElement(v value, element interface{}) bool {
+	switch p.def.Type {
+	case Token:
+		return matchesToken(v.token, element)
+	case Reference:
+		obj, _ := element.(map[string]interface{})
+		s, _ := obj["reference"].(string)
+		ref, ok := fhir.ParseReference(s)
+		return ok && ref.ID == v.ref.ID && (ref.Type == "" || v.ref.Type == "" || ref.Type == v.ref.Type)
+	case Date:
+		target, ok := elementRange(element)
+		return ok && compareDates(v.prefix, v.date, target)
+	case String:
+		for _, s := range texts(element) {
+			s = strings.ToLower(s)
+			switch p.modifier {
+			case "exact":
+				if s == v.raw {
+					return true
+				}
+			case "contains":
+				if strings.Contains(s, v.raw) {
+					return true
+				}
+			default:
+				if strings.HasPrefix(s, v.raw) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// matchesToken reports whether a code, boolean, Coding, CodeableConcept or Identifier satisfies a token
+func matchesToken(t fhir.Token, element interface{}) bool {
+	switch node := element.(type) {
+	case string:
+		return (!t.HasSystem || t.System == "") && t.Code == node
+	case bool:
+		return !t.HasSystem && t.Code == strconv.FormatBool(node)
+	case map[string]interface{}:
+		if _, ok := node["coding"]; ok {
+			return t.MatchesAny(fhir.CodeableConceptCodings(node))
+		}
+		system, _ := node["system"].(string)
+		if value, ok := node["value"].(string); ok {
+			return t.MatchesIdentifier(fhir.Identifier{System: system, Value: value})
+		}
+		code, _ := node["code"].(string)
+		return t.MatchesCoding(fhir.Coding{System: system, Code: code})
+	}
+	return false
+}
+
+// collect returns the values at path within v, searching arrays element by element
+func collect(v interface{}, path []string) []interface{} {
+	if list, ok := v.([]interface{}); ok {
+		var values []interface{}
+		for _, item := range list {
+			values = append(values, collect(item, path)...)
+		}
+		return values
+	}
+	if len(path) == 0 {
+		if v == nil {
+			return nil
+		}
+		return []interface{}{v}
+	}
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	return collect(obj[path[0]], path[1:])
+}
+
+// texts returns every string within v -- eg: the family, given, prefix and text of a HumanName
+func texts(v interface{}) (values []string) {
+	switch node := v.(type) {
+	case string:
+		values = append(values, node)
+	case map[string]interface{}:
+		for key, child := range node {
+			if key != "extension" && key != "period" && key != "use" {
+				values = append(values, texts(child)...)
+			}
+		}
+	case []interface{}:
+		for _, child := range node {
+			values = append(values, texts(child)...)
+		}
+	}
+	return values
+}
+
+// errDone stops reading a dataset once _count matches are found
+var errDone = errors.New("done")
+
+// Dir evaluates the query over every FHIR file in dir (Bundles and NDJSON) and calls fn with each match, in file
+// order. NDJSON files of other resource types are skipped. Stops after Count matches if set. Returns the number of
+// matches.
+func Dir(dir string, q *Query, fn func(fhir.Resource) error) (int, error) {
+	files, err := fhir.ListFiles(dir)
+	if err != nil {
+		return 0, err
+	}
+	matches := 0
+	for _, file := range files {
+		if file.Format == fhir.FormatNDJSON && fhir.NDJSONType(file.Path) != q.Type {
+			continue
+		}
+		err := fhir.ReadFile(file, func(r fhir.Resource) error {
+			if !q.Matches(r) {
+				return nil
+			}
+			matches++
+			if err := fn(r); err != nil {
+				return err
+			}
+			if q.Count > 0 && matches >= q.Count {
+				return errDone
+			}
+			return nil
+		})
+		if err == errDone {
+			return matches, nil
+		}
+		if err != nil {
+			return matches, fmt.Errorf("failed reading %s: %s", file.Path, err)
+		}
+	}
+	return matches, nil
+}
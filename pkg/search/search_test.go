@@ -0,0 +1,150 @@
+package search
+
+import (
+	"microsoft.com/divoc/pkg/fhir"
+	"reflect"
+	"testing"
+)
+
+// resources are matched by the tests of Query.Matches, keyed by id
+var resources = map[string]string{
+	"obs1": `{"resourceType":"Observation","id":"obs1","status":"final",
+		"code":{"coding":[{"system":"http://loinc.org","code":"8310-5"},{"system":"http://snomed.info/sct","code":"386725007"}]},
+		"subject":{"reference":"Patient/p1"},"encounter":{"reference":"urn:uuid:e1"},
+		"effectiveDateTime":"2020-03-01T10:00:00+01:00","valueString":"Slightly Elevated"}`,
+	"obs2": `{"resourceType":"Observation","id":"obs2","status":"amended",
+		"code":{"coding":[{"system":"http://loinc.org","code":"8867-4"}]},
+		"subject":{"reference":"Patient/p2"},
+		"effectivePeriod":{"start":"2020-03-05T08:00:00Z"}}`,
+	"obs3": `{"resourceType":"Observation","id":"obs3","status":"final",
+		"category":[{"coding":[{"code":"laboratory"}]},{"coding":[{"code":"vital-signs"}]}],
+		"code":{"coding":[{"system":"urn:local","code":"8310-5"}]},
+		"subject":{"reference":"Group/p1"},
+		"effectivePeriod":{"start":"2020-01-01","end":"2020-02-15"},"valueString":"normal"}`,
+}
+
+func TestMatches(t *testing.T) {
+	var decoded []fhir.Resource
+	for _, id := range []string{"obs1", "obs2", "obs3"} {
+		r, err := fhir.Decode([]byte(resources[id]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded = append(decoded, r)
+	}
+	tests := []struct {
+		search   string
+		expected []string
+	}{
+		{"Observation", []string{"obs1", "obs2", "obs3"}},
+		{"Patient", nil},
+
+		// tokens
+		{"Observation?code=8310-5", []string{"obs1", "obs3"}},
+		{"Observation?code=http://loinc.org|8310-5", []string{"obs1"}},
+		{"Observation?code=http://loinc.org|", []string{"obs1", "obs2"}},
+		{"Observation?code=|8310-5", nil},
+		{"Observation?code=386725007", []string{"obs1"}}, // any coding of the concept
+		{"Observation?status=final", []string{"obs1", "obs3"}},
+		{"Observation?_id=obs2", []string{"obs2"}},
+		{"Observation?category=vital-signs", []string{"obs3"}}, // any concept of the list
+
+		// OR and AND
+		{"Observation?code=8867-4,386725007", []string{"obs1", "obs2"}},
+		{"Observation?status=final&code=http://loinc.org|8310-5", []string{"obs1"}},
+		{"Observation?status=final&status=amended", nil},
+		{"Observation?status=final,amended&subject=Patient/p2", []string{"obs2"}},
+
+		// modifiers
+		{"Observation?status:not=final", []string{"obs2"}},
+		{"Observation?status:not=final,amended", nil},
+		{"Observation?category:not=laboratory", []string{"obs1", "obs2"}}, // including resources without the element
+		{"Observation?category:missing=true", []string{"obs1", "obs2"}},
+		{"Observation?category:missing=false", []string{"obs3"}},
+		{"Observation?value-string:missing=true,false", []string{"obs1", "obs2", "obs3"}},
+		{"Observation?value-string=slightly", []string{"obs1"}}, // starts with, ignoring case
+		{"Observation?value-string=elevated", nil},
+		{"Observation?value-string:contains=ELEVATED", []string{"obs1"}},
+		{"Observation?value-string:exact=slightly", nil},
+		{"Observation?value-string:exact=Slightly%20Elevated", []string{"obs1"}},
+		{"Observation?value-string=norm,slight", []string{"obs1", "obs3"}},
+
+		// references
+		{"Observation?subject=Patient/p1", []string{"obs1"}},
+		{"Observation?subject=p1", []string{"obs1", "obs3"}},
+		{"Observation?subject:Patient=p1", []string{"obs1"}},
+		{"Observation?subject:Group=p1", []string{"obs3"}},
+		{"Observation?patient=p2", []string{"obs2"}},
+		{"Observation?encounter=Encounter/e1", []string{"obs1"}}, // urn:uuid references have no type
+
+		// dates over dateTimes and Periods with open ends
+		{"Observation?date=2020-03-01", []string{"obs1"}},
+		{"Observation?date=2020-03-01T09:00:00Z", []string{"obs1"}},
+		{"Observation?date=2020-03-01T09:00:00", []string{"obs1"}}, // UTC
+		{"Observation?date=2020-03-01T10:00", nil},
+		{"Observation?date=2020-03-01T10:00:00%2B01:00", []string{"obs1"}},
+		{"Observation?date=2020-03-01T10:00:00+01:00", []string{"obs1"}}, // '+' is not a space
+		{"Observation?date=2020-03", []string{"obs1"}},
+		{"Observation?date=ne2020-03", []string{"obs2", "obs3"}},
+		{"Observation?date=gt2020-03", []string{"obs2"}},
+		{"Observation?date=ge2020-03", []string{"obs1", "obs2"}},
+		{"Observation?date=lt2020-03", []string{"obs3"}},
+		{"Observation?date=le2020-03", []string{"obs1", "obs3"}},
+		{"Observation?date=sa2020-02", []string{"obs1", "obs2"}},
+		{"Observation?date=eb2020-03", []string{"obs3"}},
+		{"Observation?date=ge2020-02&date=lt2020-03-02", []string{"obs1"}}, // obs3 ends before February ends
+		{"Observation?date=2020-01,2020-03-01", []string{"obs1"}},
+		{"Observation?date=2020-03-05", nil}, // obs2 does not end
+		{"Observation?date=ge2020-03-05", []string{"obs2"}},
+		{"Observation?_lastUpdated:missing=true", []string{"obs1", "obs2", "obs3"}},
+	}
+	for _, test := range tests {
+		q, err := Parse(test.search)
+		if err != nil {
+			t.Errorf("%s: %s", test.search, err)
+			continue
+		}
+		var matched []string
+		for _, r := range decoded {
+			if q.Matches(r) {
+				matched = append(matched, r.ID())
+			}
+		}
+		if !reflect.DeepEqual(matched, test.expected) {
+			t.Errorf("%s matched %v, expected %v", test.search, matched, test.expected)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	q, err := Parse("/Observation?_count=10&code=8310-5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Type != "Observation" || q.Count != 10 || len(q.params) != 1 {
+		t.Errorf("parsed %+v", q)
+	}
+	for _, search := range []string{
+		"",
+		"?code=8310-5",
+		"observation?code=8310-5",
+		"Observation?code",
+		"Observation?_count=-1",
+		"Observation?unknown=1",
+		"Observation?code:exact=8310-5",
+		"Observation?status:contains=fin",
+		"Observation?value-string:not=normal",
+		"Observation?subject:patient=p1",
+		"Observation?code:missing=yes",
+		"Observation?subject=",
+		"Observation?subject=Patient?name=x",
+		"Observation?date=2020-3",
+		"Observation?date=gt",
+		"Observation?date=2020-03-01T10:00:00+01",
+		"Observation?code=%zz",
+	} {
+		if _, err := Parse(search); err == nil {
+			t.Errorf("%q: expected an error", search)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package subset
+
+import (
+	"encoding/csv"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// patientColumns are the Synthea CSV columns which hold a patient id, in order of preference
+var patientColumns = []string{"PATIENT", "PATIENTID"}
+
+// filterCSVDir copies every Synthea CSV file in `in` to `out`, keeping only the rows of the provided patients.
+// Files without a patient column (organizations.csv, providers.csv, payers.csv, ...) are copied as-is.
+func filterCSVDir(in string, out string, patients map[string]bool) error {
+	if err := os.MkdirAll(out, 0755); err != nil {
+		return err
+	}
+	entries, err := ioutil.ReadDir(in)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.EqualFold(filepath.Ext(entry.Name()), ".csv") {
+			continue
+		}
+		if err := filterCSV(filepath.Join(in, entry.Name()), filepath.Join(out, entry.Name()), patients); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// filterCSV copies the CSV file at src to dst, keeping only the rows of the provided patients
+func filterCSV(src string, dst string, patients map[string]bool) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	reader := csv.NewReader(in)
+	reader.FieldsPerRecord = -1
+	writer := csv.NewWriter(out)
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+
+	// patients.csv is keyed by Id; every other patient file references the patient by column
+	column := -1
+	for i, name := range header {
+		if strings.EqualFold(filepath.Base(src), "patients.csv") && name == "Id" {
+			column = i
+		}
+		for _, patientColumn := range patientColumns {
+			if column < 0 && name == patientColumn {
+				column = i
+			}
+		}
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if column >= 0 && (column >= len(record) || !patients[record[column]]) {
+			continue
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,360 @@
+package subset
+
+import (
+	"fmt"
+	"microsoft.com/divoc/pkg/fhir"
+	"microsoft.com/divoc/pkg/logger"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Criteria a patient must satisfy to be part of a subset.
+// Each list is satisfied if the patient has any one of its entries; every non-empty list must be satisfied.
+type Criteria struct {
+	Conditions   []fhir.Token // Condition.code
+	Observations []fhir.Token // Observation.code
+	Medications  []fhir.Token // Medication{Request,Statement,Administration}.medicationCodeableConcept, or the code of the Medication of their medicationReference
+	Procedures   []fhir.Token // Procedure.code
+	Genders      []string     // Patient.gender
+	MinAge       *int         // minimum age in years -- nil for no minimum
+	MaxAge       *int         // maximum age in years -- nil for no maximum
+	Now          time.Time    // time ages are calculated at -- defaults to time.Now()
+}
+
+// codes returns the non-empty code lists of the criteria
+func (c Criteria) codes() map[criterion][]fhir.Token {
+	codes := map[criterion][]fhir.Token{}
+	for crit, tokens := range map[criterion][]fhir.Token{
+		byCondition:   c.Conditions,
+		byObservation: c.Observations,
+		byMedication:  c.Medications,
+		byProcedure:   c.Procedures,
+	} {
+		if len(tokens) > 0 {
+			codes[crit] = tokens
+		}
+	}
+	return codes
+}
+
+// Summary of a completed subset
+type Summary struct {
+	Patients  int            // patients selected
+	Resources map[string]int // resources written per resource type
+}
+
+// criterion identifies one of the code lists in Criteria
+type criterion int
+
+const (
+	byCondition criterion = iota
+	byObservation
+	byMedication
+	byProcedure
+)
+
+// Run selects the patients in the Synthea output directory `in` which satisfy c and writes a self-consistent
+// dataset to `out` containing those patients, every resource belonging to them, and every resource those
+// reference -- including the shared Organization, Practitioner and Location resources.
+// CSV output, if present, is filtered to the same patients.
+func Run(in string, out string, c Criteria) (summary Summary, err error) {
+	if c.Now.IsZero() {
+		c.Now = time.Now()
+	}
+	fhirIn := filepath.Join(in, fhir.Subdir)
+
+	////////////////////////////////////////////////////////////////////////////////
+	// Pass 1: select patients
+	////////////////////////////////////////////////////////////////////////////////
+	patients, known, err := selectPatients(fhirIn, c)
+	if err != nil {
+		return summary, err
+	}
+	summary.Patients = len(patients)
+	logger.Infof("Selected %d patients", len(patients))
+
+	////////////////////////////////////////////////////////////////////////////////
+	// Pass 2: collect everything referenced by the selected patients' resources
+	////////////////////////////////////////////////////////////////////////////////
+	// resources which do not belong to any patient (providers, medications, ...) are candidates for inclusion
+	// when referenced; they are few enough to hold in memory
+	var unowned []fhir.Resource
+	var wanted []fhir.Reference
+	err = fhir.ReadDir(fhirIn, func(_ fhir.File, r fhir.Resource) error {
+		owner, owned := patientOf(r, known)
+		if !owned {
+			unowned = append(unowned, r)
+		} else if patients[owner] {
+			wanted = append(wanted, fhir.References(r)...)
+		}
+		return nil
+	})
+	if err != nil {
+		return summary, err
+	}
+	included := closure(unowned, wanted)
+
+	////////////////////////////////////////////////////////////////////////////////
+	// Pass 3: write the subset
+	////////////////////////////////////////////////////////////////////////////////
+	summary.Resources = map[string]int{}
+	files, err := fhir.ListFiles(fhirIn)
+	if err != nil {
+		return summary, err
+	}
+	for _, file := range files {
+		rel, err := filepath.Rel(in, file.Path)
+		if err != nil {
+			return summary, err
+		}
+		_, err = fhir.CopyFile(file, filepath.Join(out, rel), func(r fhir.Resource) (fhir.Resource, error) {
+			owner, owned := patientOf(r, known)
+			if (owned && patients[owner]) || (!owned && included[key(r)]) {
+				summary.Resources[r.ResourceType()]++
+				return r, nil
+			}
+			return nil, nil
+		})
+		if err != nil {
+			return summary, fmt.Errorf("failed writing subset of %s: %s", file.Path, err)
+		}
+	}
+
+	csvIn := filepath.Join(in, "csv")
+	if _, err := os.Stat(csvIn); err == nil {
+		if err := filterCSVDir(csvIn, filepath.Join(out, "csv"), patients); err != nil {
+			return summary, err
+		}
+	}
+
+	return summary, nil
+}
+
+// selectPatients returns the ids of every patient satisfying c along with the ids of every patient in the dataset
+func selectPatients(dir string, c Criteria) (selected map[string]bool, known map[string]bool, err error) {
+	demographics := map[string]bool{}          // patient id => satisfies gender and age
+	matched := map[criterion]map[string]bool{} // criterion => patient ids with a matching code
+	match := func(crit criterion, owner string) {
+		if matched[crit] == nil {
+			matched[crit] = map[string]bool{}
+		}
+		matched[crit][owner] = true
+	}
+	// medication resources referencing a Medication are matched once every Medication is read, since the Medication
+	// may come later in the dataset
+	var medications []fhir.Resource
+	medicationRefs := map[string][]fhir.Reference{} // patient id => references to Medications
+	err = fhir.ReadDir(dir, func(_ fhir.File, r fhir.Resource) error {
+		switch r.ResourceType() {
+		case "Patient":
+			demographics[r.ID()] = matchesDemographics(r, c)
+			return nil
+		case "Medication":
+			if matchesAny(r.Codings("code"), c.Medications) {
+				medications = append(medications, r)
+			}
+			return nil
+		}
+		owner, owned := patientOf(r, nil)
+		if !owned {
+			return nil
+		}
+		for crit, tokens := range c.codes() {
+			if matchesCodes(r, crit, tokens) {
+				match(crit, owner)
+			}
+		}
+		if ref, ok := medicationReference(r); ok && len(c.Medications) > 0 {
+			medicationRefs[owner] = append(medicationRefs[owner], ref)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	for owner, refs := range medicationRefs {
+		for _, ref := range refs {
+			for _, medication := range medications {
+				if ref.Matches(medication) {
+					match(byMedication, owner)
+				}
+			}
+		}
+	}
+
+	selected = map[string]bool{}
+	known = map[string]bool{}
+	for id, ok := range demographics {
+		known[id] = true
+		for crit := range c.codes() {
+			ok = ok && matched[crit][id]
+		}
+		if ok {
+			selected[id] = true
+		}
+	}
+	return selected, known, nil
+}
+
+// matchesDemographics reports whether the patient satisfies the gender and age criteria
+func matchesDemographics(patient fhir.Resource, c Criteria) bool {
+	if len(c.Genders) > 0 {
+		gender, _ := patient["gender"].(string)
+		found := false
+		for _, g := range c.Genders {
+			found = found || strings.EqualFold(g, gender)
+		}
+		if !found {
+			return false
+		}
+	}
+	if c.MinAge == nil && c.MaxAge == nil {
+		return true
+	}
+	birthDate, _ := patient["birthDate"].(string)
+	born, err := time.Parse("2006-01-02", birthDate)
+	if err != nil {
+		return false
+	}
+	// deceased patients do not age past their date of death
+	at := c.Now
+	if deceased, ok := patient["deceasedDateTime"].(string); ok {
+		if died, err := time.Parse(time.RFC3339, deceased); err == nil && died.Before(at) {
+			at = died
+		}
+	}
+	age := at.Year() - born.Year()
+	if at.Month() < born.Month() || (at.Month() == born.Month() && at.Day() < born.Day()) {
+		age--
+	}
+	return (c.MinAge == nil || age >= *c.MinAge) && (c.MaxAge == nil || age <= *c.MaxAge)
+}
+
+// matchesCodes reports whether the resource is of the kind checked by crit and has a code satisfying any token.
+// Medication resources referencing a Medication of the dataset are matched separately.
+func matchesCodes(r fhir.Resource, crit criterion, tokens []fhir.Token) bool {
+	var codings []fhir.Coding
+	switch t := r.ResourceType(); {
+	case crit == byCondition && t == "Condition":
+		codings = r.Codings("code")
+	case crit == byObservation && t == "Observation":
+		codings = r.Codings("code")
+	case crit == byProcedure && t == "Procedure":
+		codings = r.Codings("code")
+	case crit == byMedication && isMedicationUse(t):
+		codings = r.Codings("medicationCodeableConcept")
+		if contained, ok := containedMedication(r); ok {
+			codings = append(codings, contained.Codings("code")...)
+		}
+	}
+	return matchesAny(codings, tokens)
+}
+
+// matchesAny reports whether any token is satisfied by the codings
+func matchesAny(codings []fhir.Coding, tokens []fhir.Token) bool {
+	for _, token := range tokens {
+		if token.MatchesAny(codings) {
+			return true
+		}
+	}
+	return false
+}
+
+// isMedicationUse reports whether resources of a type record the use of a medication; eg: MedicationRequest
+func isMedicationUse(resourceType string) bool {
+	return strings.HasPrefix(resourceType, "Medication") && resourceType != "Medication"
+}
+
+// medicationReference returns the reference of a medication resource to a Medication of the dataset
+func medicationReference(r fhir.Resource) (fhir.Reference, bool) {
+	if !isMedicationUse(r.ResourceType()) {
+		return fhir.Reference{}, false
+	}
+	obj, _ := r["medicationReference"].(map[string]interface{})
+	s, _ := obj["reference"].(string)
+	ref, ok := fhir.ParseReference(s)
+	if ok && ref.Type != "" && ref.Type != "Medication" {
+		return fhir.Reference{}, false
+	}
+	return ref, ok
+}
+
+// containedMedication returns the contained Medication the medicationReference of a resource points at, if any
+func containedMedication(r fhir.Resource) (fhir.Resource, bool) {
+	obj, _ := r["medicationReference"].(map[string]interface{})
+	s, _ := obj["reference"].(string)
+	if !strings.HasPrefix(s, "#") {
+		return nil, false
+	}
+	contained, _ := r["contained"].([]interface{})
+	for _, item := range contained {
+		if m, ok := item.(map[string]interface{}); ok && fhir.Resource(m).ID() == s[1:] {
+			return m, true
+		}
+	}
+	return nil, false
+}
+
+// patientOf returns the id of the patient a resource belongs to.
+// Patients belong to themselves; other resources belong to the Patient in their subject, patient or beneficiary,
+// else to the first reference to a Patient or to one of the known patient ids.
+// Returns false if the resource does not belong to any patient.
+func patientOf(r fhir.Resource, known map[string]bool) (string, bool) {
+	if r.ResourceType() == "Patient" {
+		return r.ID(), true
+	}
+	for _, field := range []string{"subject", "patient", "beneficiary"} {
+		if obj, ok := r[field].(map[string]interface{}); ok {
+			if s, _ := obj["reference"].(string); s != "" {
+				if ref, ok := fhir.ParseReference(s); ok && (ref.Type == "" || ref.Type == "Patient") {
+					return ref.ID, true
+				}
+			}
+		}
+	}
+	// fall back to any other Patient reference -- eg: Provenance.target
+	for _, ref := range fhir.References(r) {
+		if ref.ID != "" && (ref.Type == "Patient" || (ref.Type == "" && known[ref.ID])) {
+			return ref.ID, true
+		}
+	}
+	return "", false
+}
+
+// closure returns the keys of every candidate transitively referenced by the wanted references
+func closure(candidates []fhir.Resource, wanted []fhir.Reference) map[string]bool {
+	byID := map[string][]fhir.Resource{}
+	byIdentifier := map[fhir.Identifier][]fhir.Resource{}
+	for _, r := range candidates {
+		byID[r.ID()] = append(byID[r.ID()], r)
+		for _, id := range r.Identifiers() {
+			byIdentifier[id] = append(byIdentifier[id], r)
+		}
+	}
+
+	included := map[string]bool{}
+	for len(wanted) > 0 {
+		var next []fhir.Reference
+		for _, ref := range wanted {
+			matches := byID[ref.ID]
+			if ref.ID == "" {
+				matches = byIdentifier[ref.Identifier]
+			}
+			for _, r := range matches {
+				if !included[key(r)] && ref.Matches(r) {
+					included[key(r)] = true
+					next = append(next, fhir.References(r)...)
+				}
+			}
+		}
+		wanted = next
+	}
+	return included
+}
+
+// key uniquely identifies a resource within a dataset
+func key(r fhir.Resource) string {
+	return r.ResourceType() + "/" + r.ID()
+}
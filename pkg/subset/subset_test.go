@@ -0,0 +1,143 @@
+package subset
+
+import (
+	"io/ioutil"
+	"microsoft.com/divoc/pkg/fhir"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// now is the time ages of the fixtures are calculated at
+var now = time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+
+// runSubset subsets a dataset of testdata and returns the keys of the resources written, by resource type
+func runSubset(t *testing.T, dataset string, c Criteria) (string, map[string][]string) {
+	out, err := ioutil.TempDir("", "subset")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Now = now
+	summary, err := Run(filepath.Join("testdata", dataset), out, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	written := map[string][]string{}
+	err = fhir.ReadDir(filepath.Join(out, fhir.Subdir), func(_ fhir.File, r fhir.Resource) error {
+		written[r.ResourceType()] = append(written[r.ResourceType()], r.ID())
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for resourceType, ids := range written {
+		sort.Strings(ids)
+		if summary.Resources[resourceType] != len(ids) {
+			t.Errorf("summary counts %d %s resources, %d written", summary.Resources[resourceType], resourceType, len(ids))
+		}
+	}
+	if summary.Patients != len(written["Patient"]) {
+		t.Errorf("summary counts %d patients, %d written", summary.Patients, len(written["Patient"]))
+	}
+	return out, written
+}
+
+func TestRunSelectsPatients(t *testing.T) {
+	minAge := 65
+	maxAge := 69
+	tests := []struct {
+		name     string
+		criteria Criteria
+		patients []string
+	}{
+		{"everyone", Criteria{}, []string{"p1", "p2", "p3"}},
+		{"condition", Criteria{Conditions: []fhir.Token{fhir.ParseToken("840539006")}}, []string{"p1", "p3"}},
+		{"condition with system", Criteria{Conditions: []fhir.Token{fhir.ParseToken("http://snomed.info/sct|840539006")}}, []string{"p1", "p3"}},
+		{"condition of another system", Criteria{Conditions: []fhir.Token{fhir.ParseToken("http://hl7.org/fhir/sid/icd-10|840539006")}}, nil},
+		{"any condition code", Criteria{Conditions: []fhir.Token{fhir.ParseToken("44054006"), fhir.ParseToken("840539006")}}, []string{"p1", "p2", "p3"}},
+		{"every list", Criteria{
+			Conditions:   []fhir.Token{fhir.ParseToken("840539006")},
+			Observations: []fhir.Token{fhir.ParseToken("http://loinc.org|8310-5")},
+		}, []string{"p1"}},
+		{"procedure", Criteria{Procedures: []fhir.Token{fhir.ParseToken("73761001")}}, []string{"p2"}},
+		{"code only matches its resource type", Criteria{Observations: []fhir.Token{fhir.ParseToken("73761001")}}, nil},
+		{"medicationCodeableConcept", Criteria{Medications: []fhir.Token{fhir.ParseToken("860975")}}, []string{"p2"}},
+		{"medicationReference", Criteria{Medications: []fhir.Token{fhir.ParseToken("2123111")}}, []string{"p1"}},
+		{"contained medication", Criteria{Medications: []fhir.Token{fhir.ParseToken("308136")}}, []string{"p3"}},
+		{"gender", Criteria{Genders: []string{"Male"}}, []string{"p2"}},
+		{"minimum age", Criteria{MinAge: &minAge}, []string{"p1"}}, // p3 died aged 60
+		{"maximum age", Criteria{MaxAge: &maxAge}, []string{"p2", "p3"}},
+		{"gender and condition", Criteria{Genders: []string{"female"}, Conditions: []fhir.Token{fhir.ParseToken("840539006")}, MinAge: &minAge}, []string{"p1"}},
+	}
+	for _, test := range tests {
+		out, written := runSubset(t, "ndjson", test.criteria)
+		os.RemoveAll(out)
+		if !reflect.DeepEqual(written["Patient"], test.patients) {
+			t.Errorf("%s: selected %v, expected %v", test.name, written["Patient"], test.patients)
+		}
+	}
+}
+
+func TestRunWritesReferencedResources(t *testing.T) {
+	out, written := runSubset(t, "ndjson", Criteria{Medications: []fhir.Token{fhir.ParseToken("2123111")}})
+	defer os.RemoveAll(out)
+	expected := map[string][]string{
+		"Patient":           {"p1"},
+		"Encounter":         {"e1"},
+		"Condition":         {"c1"},
+		"Observation":       {"obs1"},
+		"MedicationRequest": {"mr1"},
+		"Medication":        {"m1"},             // by id, but not the unreferenced m2 of the same code
+		"Organization":      {"o1", "o3", "o4"}, // o3 through Location/l1, o4 through Medication/m1
+		"Practitioner":      {"pr1"},            // by identifier
+		"Location":          {"l1"},
+	}
+	if !reflect.DeepEqual(written, expected) {
+		t.Errorf("wrote %v, expected %v", written, expected)
+	}
+
+	// CSV files are filtered to the selected patients; files without a patient column are copied as-is
+	for file, rows := range map[string]string{
+		"patients.csv":      "Id,BIRTHDATE,DEATHDATE,GENDER\np1,1950-01-01,,F\n",
+		"conditions.csv":    "START,STOP,PATIENT,ENCOUNTER,CODE,DESCRIPTION\n2020-03-01,,p1,e1,840539006,COVID-19\n",
+		"organizations.csv": "Id,NAME\no1,General Hospital\no2,Community Clinic\n",
+	} {
+		data, err := ioutil.ReadFile(filepath.Join(out, "csv", file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != rows {
+			t.Errorf("%s holds\n%s\nexpected\n%s", file, data, rows)
+		}
+	}
+}
+
+func TestRunBundles(t *testing.T) {
+	out, written := runSubset(t, "bundle", Criteria{Medications: []fhir.Token{fhir.ParseToken("2123111")}})
+	defer os.RemoveAll(out)
+	expected := map[string][]string{
+		"Patient":           {"p4"},
+		"Encounter":         {"e4"},
+		"MedicationRequest": {"mr4"},
+		"Medication":        {"m4"}, // by urn:uuid
+		"Organization":      {"o1"}, // by conditional reference
+	}
+	if !reflect.DeepEqual(written, expected) {
+		t.Errorf("wrote %v, expected %v", written, expected)
+	}
+	files, err := fhir.ListFiles(filepath.Join(out, fhir.Subdir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, file := range files {
+		names = append(names, filepath.Base(file.Path))
+	}
+	if strings.Join(names, ",") != "Dana_p4.json,hospitalInformation1.json" {
+		t.Errorf("wrote %v, expected the Bundle of the selected patient and the shared providers", names)
+	}
+}
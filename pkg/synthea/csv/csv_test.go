@@ -0,0 +1,121 @@
+package csv
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testdata/<version> holds rows of each file as written by that Synthea release
+
+func TestValidateSamples(t *testing.T) {
+	for version, schemas := range Versions {
+		dir := filepath.Join("testdata", version)
+		for file := range schemas {
+			if _, err := os.Stat(filepath.Join(dir, file)); err != nil {
+				t.Errorf("Synthea %s: no sample of %s", version, file)
+			}
+		}
+		issues, err := Validate(dir, version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, issue := range issues {
+			t.Errorf("Synthea %s: %s", version, issue)
+		}
+	}
+}
+
+func TestReadSamples(t *testing.T) {
+	dir := filepath.Join("testdata", DefaultVersion)
+	records := map[string][]interface{}{}
+	for file := range Versions[DefaultVersion] {
+		err := ReadFile(filepath.Join(dir, file), func(record interface{}) error {
+			records[file] = append(records[file], record)
+			return nil
+		})
+		if err != nil {
+			t.Errorf("%s: %s", file, err)
+		}
+		if len(records[file]) == 0 {
+			t.Errorf("%s: no rows read", file)
+		}
+	}
+
+	patient := records["patients.csv"][1].(*Patient)
+	if patient.ID != "034e9e3b-2def-4559-bb2a-7850888ae060" || patient.Maiden != "Hauck852" || patient.Zip != "" ||
+		!patient.BirthDate.Equal(time.Date(1983, 11, 14, 0, 0, 0, 0, time.UTC)) ||
+		!patient.DeathDate.Equal(time.Date(2020, 4, 2, 0, 0, 0, 0, time.UTC)) ||
+		patient.Lat != 42.36069560186902 || patient.HealthcareCoverage != 3204.49 {
+		t.Errorf("read patient %+v", patient)
+	}
+	if alive := records["patients.csv"][0].(*Patient); !alive.DeathDate.IsZero() || alive.First != "José Eduardo181" {
+		t.Errorf("read patient %+v", alive)
+	}
+	encounter := records["encounters.csv"][0].(*Encounter)
+	if !encounter.Start.Equal(time.Date(2020, 3, 2, 1, 53, 4, 0, time.UTC)) || encounter.ReasonCode != "840539006" {
+		t.Errorf("read encounter %+v", encounter)
+	}
+	medication := records["medications.csv"][1].(*Medication)
+	if !medication.Stop.IsZero() || medication.Dispenses != 105 || medication.TotalCost != 27666.45 {
+		t.Errorf("read medication %+v", medication)
+	}
+	observation := records["observations.csv"][2].(*Observation)
+	if observation.Encounter != "" || observation.Value != "Never smoker" || observation.Type != "text" {
+		t.Errorf("read observation %+v", observation)
+	}
+	supply := records["supplies.csv"][0].(*Supply)
+	if !supply.Date.Equal(time.Date(2020, 3, 5, 0, 0, 0, 0, time.UTC)) || supply.Quantity != 24 {
+		t.Errorf("read supply %+v", supply)
+	}
+}
+
+func TestValidateReportsIssues(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		// the layout of later Synthea releases adds FIPS and INCOME
+		"patients.csv": "Id,BIRTHDATE,DEATHDATE,SSN,DRIVERS,PASSPORT,PREFIX,FIRST,LAST,SUFFIX,MAIDEN,MARITAL,RACE,ETHNICITY,GENDER,BIRTHPLACE,ADDRESS,CITY,STATE,COUNTY,FIPS,ZIP,LAT,LON,HEALTHCARE_EXPENSES,HEALTHCARE_COVERAGE,INCOME\n" +
+			"1d604da9-9a81-4ba9-80c2-de3375d59b40,1989-05-25,,999-76-6866,,,,José,Gómez,,,,white,hispanic,M,Marigot,427 Balistreri Way,Chicopee,Massachusetts,Hampden County,25013,01013,42.2,-72.5,271227.08,1334.88,49476\n",
+		"conditions.csv": "START,STOP,PATIENT,ENCOUNTER,CODE,DESCRIPTION\n" +
+			"2020-03-02T01:53:04Z,,1d604da9-9a81-4ba9-80c2-de3375d59b40,,840539006,COVID-19\n" +
+			"2020-03-03T01:53:04Z,,p1,0ea4ef5e-2f4e-4ad0-8bb4-0e5a3ff0a6d6,840539006,COVID-19\n" +
+			"2020-03-04T01:53:04Z,,1d604da9-9a81-4ba9-80c2-de3375d59b40,0ea4ef5e-2f4e-4ad0-8bb4-0e5a3ff0a6d6,840539006\n",
+		"claims.csv": "Id\n",
+	}
+	for file, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, file), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	issues, err := Validate(dir, DefaultVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, issue := range issues {
+		got = append(got, issue.String())
+	}
+	expected := []string{
+		"claims.csv: unexpected file for Synthea 2.6",
+		`conditions.csv:2: column START: expected date: "2020-03-02T01:53:04Z" (2 rows, first shown)`,
+		"conditions.csv:2: column ENCOUNTER: empty required value",
+		`conditions.csv:3: column PATIENT: expected uuid: "p1"`,
+		"conditions.csv:4: expected 6 fields, got 5",
+		"patients.csv: column FIPS: unexpected column",
+		"patients.csv: column INCOME: unexpected column",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("reported\n%s\nexpected\n%s", strings.Join(got, "\n"), strings.Join(expected, "\n"))
+	}
+	if _, err := Validate(dir, "3.0"); err == nil {
+		t.Error("expected an error for an unknown version")
+	}
+}
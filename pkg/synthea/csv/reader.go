@@ -0,0 +1,147 @@
+package csv
+
+import (
+	stdcsv "encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// ParseError is a value that could not be decoded into the field of a record
+type ParseError struct {
+	File   string
+	Line   int
+	Column string
+	Value  string
+	Err    error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s:%d: column %s: cannot parse %q: %s", e.File, e.Line, e.Column, e.Value, e.Err)
+}
+
+// Reader decodes the rows of a Synthea CSV file into typed records. Columns are matched to record fields by name, so
+// reordered columns are decoded; columns missing from the file leave their field at the zero value.
+type Reader struct {
+	file   string
+	r      *stdcsv.Reader
+	header map[string]int
+	line   int
+}
+
+// NewReader reads the header of a CSV file. file is the name used in errors.
+func NewReader(r io.Reader, file string) (*Reader, error) {
+	reader := stdcsv.NewReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed reading header of %s: %s", file, err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[name] = i
+	}
+	return &Reader{file: file, r: reader, header: columns, line: 1}, nil
+}
+
+// Decode the next row into record, a pointer to one of the record structs of this package (or any struct with
+// `csv` tagged string, time.Time, int64 or float64 fields). Returns io.EOF when there are no more rows.
+func (r *Reader) Decode(record interface{}) error {
+	v := reflect.ValueOf(record)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("record must be a pointer to a struct, got %T", record)
+	}
+	row, err := r.r.Read()
+	if err != nil {
+		return err
+	}
+	r.line++
+	v = v.Elem()
+	for i := 0; i < v.NumField(); i++ {
+		name := v.Type().Field(i).Tag.Get("csv")
+		j, ok := r.header[name]
+		if name == "" || !ok {
+			continue
+		}
+		if err := setField(v.Field(i), row[j]); err != nil {
+			return &ParseError{File: r.file, Line: r.line, Column: name, Value: row[j], Err: err}
+		}
+	}
+	return nil
+}
+
+// setField decodes a CSV value into a record field
+func setField(field reflect.Value, value string) error {
+	switch field.Interface().(type) {
+	case string:
+		field.SetString(value)
+		return nil
+	case time.Time:
+		if value == "" {
+			field.Set(reflect.ValueOf(time.Time{}))
+			return nil
+		}
+		layout := DateTimeLayout
+		if len(value) == len(DateLayout) {
+			layout = DateLayout
+		}
+		t, err := time.Parse(layout, value)
+		if err != nil {
+			return err
+		}
+		field.Set(reflect.ValueOf(t))
+		return nil
+	case int64:
+		var n int64
+		if value != "" {
+			var err error
+			if n, err = strconv.ParseInt(value, 10, 64); err != nil {
+				return err
+			}
+		}
+		field.SetInt(n)
+		return nil
+	case float64:
+		var f float64
+		if value != "" {
+			var err error
+			if f, err = strconv.ParseFloat(value, 64); err != nil {
+				return err
+			}
+		}
+		field.SetFloat(f)
+		return nil
+	}
+	return fmt.Errorf("unsupported field type %s", field.Type())
+}
+
+// ReadFile decodes every row of a Synthea CSV file into its typed record (see NewRecord) and calls fn with each
+func ReadFile(path string, fn func(record interface{}) error) error {
+	file := filepath.Base(path)
+	if NewRecord(file) == nil {
+		return fmt.Errorf("unknown Synthea CSV file %s", file)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	reader, err := NewReader(f, file)
+	if err != nil {
+		return err
+	}
+	for {
+		record := NewRecord(file)
+		if err := reader.Decode(record); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+}
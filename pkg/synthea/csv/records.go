@@ -0,0 +1,269 @@
+package csv
+
+import (
+	"time"
+)
+
+// Typed records of each Synthea CSV file. Fields are tagged with their column name; empty Date and DateTime values
+// decode to the zero time and empty numbers to 0.
+
+// Allergy is a row of allergies.csv
+type Allergy struct {
+	Start       time.Time `csv:"START"`
+	Stop        time.Time `csv:"STOP"`
+	Patient     string    `csv:"PATIENT"`
+	Encounter   string    `csv:"ENCOUNTER"`
+	Code        string    `csv:"CODE"`
+	Description string    `csv:"DESCRIPTION"`
+}
+
+// CarePlan is a row of careplans.csv
+type CarePlan struct {
+	ID                string    `csv:"Id"`
+	Start             time.Time `csv:"START"`
+	Stop              time.Time `csv:"STOP"`
+	Patient           string    `csv:"PATIENT"`
+	Encounter         string    `csv:"ENCOUNTER"`
+	Code              string    `csv:"CODE"`
+	Description       string    `csv:"DESCRIPTION"`
+	ReasonCode        string    `csv:"REASONCODE"`
+	ReasonDescription string    `csv:"REASONDESCRIPTION"`
+}
+
+// Condition is a row of conditions.csv
+type Condition struct {
+	Start       time.Time `csv:"START"`
+	Stop        time.Time `csv:"STOP"`
+	Patient     string    `csv:"PATIENT"`
+	Encounter   string    `csv:"ENCOUNTER"`
+	Code        string    `csv:"CODE"`
+	Description string    `csv:"DESCRIPTION"`
+}
+
+// Device is a row of devices.csv
+type Device struct {
+	Start       time.Time `csv:"START"`
+	Stop        time.Time `csv:"STOP"`
+	Patient     string    `csv:"PATIENT"`
+	Encounter   string    `csv:"ENCOUNTER"`
+	Code        string    `csv:"CODE"`
+	Description string    `csv:"DESCRIPTION"`
+	UDI         string    `csv:"UDI"`
+}
+
+// Encounter is a row of encounters.csv
+type Encounter struct {
+	ID                string    `csv:"Id"`
+	Start             time.Time `csv:"START"`
+	Stop              time.Time `csv:"STOP"`
+	Patient           string    `csv:"PATIENT"`
+	Organization      string    `csv:"ORGANIZATION"`
+	Provider          string    `csv:"PROVIDER"`
+	Payer             string    `csv:"PAYER"`
+	EncounterClass    string    `csv:"ENCOUNTERCLASS"`
+	Code              string    `csv:"CODE"`
+	Description       string    `csv:"DESCRIPTION"`
+	BaseEncounterCost float64   `csv:"BASE_ENCOUNTER_COST"`
+	TotalClaimCost    float64   `csv:"TOTAL_CLAIM_COST"`
+	PayerCoverage     float64   `csv:"PAYER_COVERAGE"`
+	ReasonCode        string    `csv:"REASONCODE"`
+	ReasonDescription string    `csv:"REASONDESCRIPTION"`
+}
+
+// ImagingStudy is a row of imaging_studies.csv
+type ImagingStudy struct {
+	ID                  string    `csv:"Id"`
+	Date                time.Time `csv:"DATE"`
+	Patient             string    `csv:"PATIENT"`
+	Encounter           string    `csv:"ENCOUNTER"`
+	BodySiteCode        string    `csv:"BODYSITE_CODE"`
+	BodySiteDescription string    `csv:"BODYSITE_DESCRIPTION"`
+	ModalityCode        string    `csv:"MODALITY_CODE"`
+	ModalityDescription string    `csv:"MODALITY_DESCRIPTION"`
+	SOPCode             string    `csv:"SOP_CODE"`
+	SOPDescription      string    `csv:"SOP_DESCRIPTION"`
+}
+
+// Immunization is a row of immunizations.csv
+type Immunization struct {
+	Date        time.Time `csv:"DATE"`
+	Patient     string    `csv:"PATIENT"`
+	Encounter   string    `csv:"ENCOUNTER"`
+	Code        string    `csv:"CODE"`
+	Description string    `csv:"DESCRIPTION"`
+	BaseCost    float64   `csv:"BASE_COST"`
+}
+
+// Medication is a row of medications.csv
+type Medication struct {
+	Start             time.Time `csv:"START"`
+	Stop              time.Time `csv:"STOP"`
+	Patient           string    `csv:"PATIENT"`
+	Payer             string    `csv:"PAYER"`
+	Encounter         string    `csv:"ENCOUNTER"`
+	Code              string    `csv:"CODE"`
+	Description       string    `csv:"DESCRIPTION"`
+	BaseCost          float64   `csv:"BASE_COST"`
+	PayerCoverage     float64   `csv:"PAYER_COVERAGE"`
+	Dispenses         int64     `csv:"DISPENSES"`
+	TotalCost         float64   `csv:"TOTALCOST"`
+	ReasonCode        string    `csv:"REASONCODE"`
+	ReasonDescription string    `csv:"REASONDESCRIPTION"`
+}
+
+// Observation is a row of observations.csv. Value is kept as text as it is numeric or text depending on Type.
+type Observation struct {
+	Date        time.Time `csv:"DATE"`
+	Patient     string    `csv:"PATIENT"`
+	Encounter   string    `csv:"ENCOUNTER"`
+	Code        string    `csv:"CODE"`
+	Description string    `csv:"DESCRIPTION"`
+	Value       string    `csv:"VALUE"`
+	Units       string    `csv:"UNITS"`
+	Type        string    `csv:"TYPE"`
+}
+
+// Organization is a row of organizations.csv
+type Organization struct {
+	ID          string  `csv:"Id"`
+	Name        string  `csv:"NAME"`
+	Address     string  `csv:"ADDRESS"`
+	City        string  `csv:"CITY"`
+	State       string  `csv:"STATE"`
+	Zip         string  `csv:"ZIP"`
+	Lat         float64 `csv:"LAT"`
+	Lon         float64 `csv:"LON"`
+	Phone       string  `csv:"PHONE"`
+	Revenue     float64 `csv:"REVENUE"`
+	Utilization int64   `csv:"UTILIZATION"`
+}
+
+// Patient is a row of patients.csv
+type Patient struct {
+	ID                 string    `csv:"Id"`
+	BirthDate          time.Time `csv:"BIRTHDATE"`
+	DeathDate          time.Time `csv:"DEATHDATE"`
+	SSN                string    `csv:"SSN"`
+	Drivers            string    `csv:"DRIVERS"`
+	Passport           string    `csv:"PASSPORT"`
+	Prefix             string    `csv:"PREFIX"`
+	First              string    `csv:"FIRST"`
+	Last               string    `csv:"LAST"`
+	Suffix             string    `csv:"SUFFIX"`
+	Maiden             string    `csv:"MAIDEN"`
+	Marital            string    `csv:"MARITAL"`
+	Race               string    `csv:"RACE"`
+	Ethnicity          string    `csv:"ETHNICITY"`
+	Gender             string    `csv:"GENDER"`
+	BirthPlace         string    `csv:"BIRTHPLACE"`
+	Address            string    `csv:"ADDRESS"`
+	City               string    `csv:"CITY"`
+	State              string    `csv:"STATE"`
+	County             string    `csv:"COUNTY"`
+	Zip                string    `csv:"ZIP"`
+	Lat                float64   `csv:"LAT"`
+	Lon                float64   `csv:"LON"`
+	HealthcareExpenses float64   `csv:"HEALTHCARE_EXPENSES"`
+	HealthcareCoverage float64   `csv:"HEALTHCARE_COVERAGE"`
+}
+
+// PayerTransition is a row of payer_transitions.csv
+type PayerTransition struct {
+	Patient   string `csv:"PATIENT"`
+	StartYear int64  `csv:"START_YEAR"`
+	EndYear   int64  `csv:"END_YEAR"`
+	Payer     string `csv:"PAYER"`
+	Ownership string `csv:"OWNERSHIP"`
+}
+
+// Payer is a row of payers.csv
+type Payer struct {
+	ID                     string  `csv:"Id"`
+	Name                   string  `csv:"NAME"`
+	Address                string  `csv:"ADDRESS"`
+	City                   string  `csv:"CITY"`
+	StateHeadquartered     string  `csv:"STATE_HEADQUARTERED"`
+	Zip                    string  `csv:"ZIP"`
+	Phone                  string  `csv:"PHONE"`
+	AmountCovered          float64 `csv:"AMOUNT_COVERED"`
+	AmountUncovered        float64 `csv:"AMOUNT_UNCOVERED"`
+	Revenue                float64 `csv:"REVENUE"`
+	CoveredEncounters      int64   `csv:"COVERED_ENCOUNTERS"`
+	UncoveredEncounters    int64   `csv:"UNCOVERED_ENCOUNTERS"`
+	CoveredMedications     int64   `csv:"COVERED_MEDICATIONS"`
+	UncoveredMedications   int64   `csv:"UNCOVERED_MEDICATIONS"`
+	CoveredProcedures      int64   `csv:"COVERED_PROCEDURES"`
+	UncoveredProcedures    int64   `csv:"UNCOVERED_PROCEDURES"`
+	CoveredImmunizations   int64   `csv:"COVERED_IMMUNIZATIONS"`
+	UncoveredImmunizations int64   `csv:"UNCOVERED_IMMUNIZATIONS"`
+	UniqueCustomers        int64   `csv:"UNIQUE_CUSTOMERS"`
+	QOLSAvg                float64 `csv:"QOLS_AVG"`
+	MemberMonths           int64   `csv:"MEMBER_MONTHS"`
+}
+
+// Procedure is a row of procedures.csv
+type Procedure struct {
+	Date              time.Time `csv:"DATE"`
+	Patient           string    `csv:"PATIENT"`
+	Encounter         string    `csv:"ENCOUNTER"`
+	Code              string    `csv:"CODE"`
+	Description       string    `csv:"DESCRIPTION"`
+	BaseCost          float64   `csv:"BASE_COST"`
+	ReasonCode        string    `csv:"REASONCODE"`
+	ReasonDescription string    `csv:"REASONDESCRIPTION"`
+}
+
+// Provider is a row of providers.csv
+type Provider struct {
+	ID           string  `csv:"Id"`
+	Organization string  `csv:"ORGANIZATION"`
+	Name         string  `csv:"NAME"`
+	Gender       string  `csv:"GENDER"`
+	Speciality   string  `csv:"SPECIALITY"`
+	Address      string  `csv:"ADDRESS"`
+	City         string  `csv:"CITY"`
+	State        string  `csv:"STATE"`
+	Zip          string  `csv:"ZIP"`
+	Lat          float64 `csv:"LAT"`
+	Lon          float64 `csv:"LON"`
+	Utilization  int64   `csv:"UTILIZATION"`
+}
+
+// Supply is a row of supplies.csv
+type Supply struct {
+	Date        time.Time `csv:"DATE"`
+	Patient     string    `csv:"PATIENT"`
+	Encounter   string    `csv:"ENCOUNTER"`
+	Code        string    `csv:"CODE"`
+	Description string    `csv:"DESCRIPTION"`
+	Quantity    int64     `csv:"QUANTITY"`
+}
+
+// newRecords returns a new record for each Synthea CSV file, keyed by file name
+var newRecords = map[string]func() interface{}{
+	"allergies.csv":         func() interface{} { return &Allergy{} },
+	"careplans.csv":         func() interface{} { return &CarePlan{} },
+	"conditions.csv":        func() interface{} { return &Condition{} },
+	"devices.csv":           func() interface{} { return &Device{} },
+	"encounters.csv":        func() interface{} { return &Encounter{} },
+	"imaging_studies.csv":   func() interface{} { return &ImagingStudy{} },
+	"immunizations.csv":     func() interface{} { return &Immunization{} },
+	"medications.csv":       func() interface{} { return &Medication{} },
+	"observations.csv":      func() interface{} { return &Observation{} },
+	"organizations.csv":     func() interface{} { return &Organization{} },
+	"patients.csv":          func() interface{} { return &Patient{} },
+	"payer_transitions.csv": func() interface{} { return &PayerTransition{} },
+	"payers.csv":            func() interface{} { return &Payer{} },
+	"procedures.csv":        func() interface{} { return &Procedure{} },
+	"providers.csv":         func() interface{} { return &Provider{} },
+	"supplies.csv":          func() interface{} { return &Supply{} },
+}
+
+// NewRecord returns a pointer to a new typed record of a Synthea CSV file (eg: *Patient for patients.csv), or nil
+// if the file is unknown
+func NewRecord(file string) interface{} {
+	if newRecord, ok := newRecords[file]; ok {
+		return newRecord()
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package csv
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Type of the values of a CSV column
+type Type int
+
+const (
+	String   Type = iota // any text
+	UUID                 // a Synthea generated UUID
+	Date                 // YYYY-MM-DD
+	DateTime             // RFC 3339 timestamp; eg: 2020-03-01T10:00:00Z
+	Integer              // whole number
+	Decimal              // decimal number
+)
+
+// String returns the name of the type
+func (t Type) String() string {
+	return [...]string{"string", "uuid", "date", "dateTime", "integer", "decimal"}[t]
+}
+
+// Column of a Synthea CSV file
+type Column struct {
+	Name     string
+	Type     Type
+	Required bool // false if the column may be empty
+}
+
+// Schema is the ordered column layout of a Synthea CSV file
+type Schema []Column
+
+// DefaultVersion is the Synthea version whose CSV layout is validated against by default: the release cloned by
+// synthea.Clone
+const DefaultVersion = "2.6"
+
+// Versions holds the CSV schemas of each known Synthea version, keyed by version then file name
+var Versions = map[string]map[string]Schema{
+	"2.6": {
+		"allergies.csv": {
+			{"START", Date, true}, {"STOP", Date, false}, {"PATIENT", UUID, true}, {"ENCOUNTER", UUID, true},
+			{"CODE", String, true}, {"DESCRIPTION", String, true},
+		},
+		"careplans.csv": {
+			{"Id", UUID, true}, {"START", Date, true}, {"STOP", Date, false}, {"PATIENT", UUID, true},
+			{"ENCOUNTER", UUID, true}, {"CODE", String, true}, {"DESCRIPTION", String, true},
+			{"REASONCODE", String, false}, {"REASONDESCRIPTION", String, false},
+		},
+		"conditions.csv": {
+			{"START", Date, true}, {"STOP", Date, false}, {"PATIENT", UUID, true}, {"ENCOUNTER", UUID, true},
+			{"CODE", String, true}, {"DESCRIPTION", String, true},
+		},
+		"devices.csv": {
+			{"START", DateTime, true}, {"STOP", DateTime, false}, {"PATIENT", UUID, true}, {"ENCOUNTER", UUID, true},
+			{"CODE", String, true}, {"DESCRIPTION", String, true}, {"UDI", String, true},
+		},
+		"encounters.csv": {
+			{"Id", UUID, true}, {"START", DateTime, true}, {"STOP", DateTime, false}, {"PATIENT", UUID, true},
+			{"ORGANIZATION", UUID, true}, {"PROVIDER", UUID, true}, {"PAYER", UUID, true},
+			{"ENCOUNTERCLASS", String, true}, {"CODE", String, true}, {"DESCRIPTION", String, true},
+			{"BASE_ENCOUNTER_COST", Decimal, true}, {"TOTAL_CLAIM_COST", Decimal, true},
+			{"PAYER_COVERAGE", Decimal, true}, {"REASONCODE", String, false}, {"REASONDESCRIPTION", String, false},
+		},
+		"imaging_studies.csv": {
+			{"Id", UUID, true}, {"DATE", DateTime, true}, {"PATIENT", UUID, true}, {"ENCOUNTER", UUID, true},
+			{"BODYSITE_CODE", String, true}, {"BODYSITE_DESCRIPTION", String, true},
+			{"MODALITY_CODE", String, true}, {"MODALITY_DESCRIPTION", String, true},
+			{"SOP_CODE", String, true}, {"SOP_DESCRIPTION", String, true},
+		},
+		"immunizations.csv": {
+			{"DATE", DateTime, true}, {"PATIENT", UUID, true}, {"ENCOUNTER", UUID, true},
+			{"CODE", String, true}, {"DESCRIPTION", String, true}, {"BASE_COST", Decimal, true},
+		},
+		"medications.csv": {
+			{"START", DateTime, true}, {"STOP", DateTime, false}, {"PATIENT", UUID, true}, {"PAYER", UUID, true},
+			{"ENCOUNTER", UUID, true}, {"CODE", String, true}, {"DESCRIPTION", String, true},
+			{"BASE_COST", Decimal, true}, {"PAYER_COVERAGE", Decimal, true}, {"DISPENSES", Integer, true},
+			{"TOTALCOST", Decimal, true}, {"REASONCODE", String, false}, {"REASONDESCRIPTION", String, false},
+		},
+		"observations.csv": {
+			{"DATE", DateTime, true}, {"PATIENT", UUID, true}, {"ENCOUNTER", UUID, false},
+			{"CODE", String, true}, {"DESCRIPTION", String, true}, {"VALUE", String, true},
+			{"UNITS", String, false}, {"TYPE", String, true},
+		},
+		"organizations.csv": {
+			{"Id", UUID, true}, {"NAME", String, true}, {"ADDRESS", String, true}, {"CITY", String, true},
+			{"STATE", String, true}, {"ZIP", String, false}, {"LAT", Decimal, true}, {"LON", Decimal, true},
+			{"PHONE", String, false}, {"REVENUE", Decimal, true}, {"UTILIZATION", Integer, true},
+		},
+		"patients.csv": {
+			{"Id", UUID, true}, {"BIRTHDATE", Date, true}, {"DEATHDATE", Date, false}, {"SSN", String, true},
+			{"DRIVERS", String, false}, {"PASSPORT", String, false}, {"PREFIX", String, false},
+			{"FIRST", String, true}, {"LAST", String, true}, {"SUFFIX", String, false}, {"MAIDEN", String, false},
+			{"MARITAL", String, false}, {"RACE", String, true}, {"ETHNICITY", String, true}, {"GENDER", String, true},
+			{"BIRTHPLACE", String, true}, {"ADDRESS", String, true}, {"CITY", String, true}, {"STATE", String, true},
+			{"COUNTY", String, false}, {"ZIP", String, false}, {"LAT", Decimal, true}, {"LON", Decimal, true},
+			{"HEALTHCARE_EXPENSES", Decimal, true}, {"HEALTHCARE_COVERAGE", Decimal, true},
+		},
+		"payer_transitions.csv": {
+			{"PATIENT", UUID, true}, {"START_YEAR", Integer, true}, {"END_YEAR", Integer, true},
+			{"PAYER", UUID, true}, {"OWNERSHIP", String, false},
+		},
+		"payers.csv": {
+			{"Id", UUID, true}, {"NAME", String, true}, {"ADDRESS", String, false}, {"CITY", String, false},
+			{"STATE_HEADQUARTERED", String, false}, {"ZIP", String, false}, {"PHONE", String, false},
+			{"AMOUNT_COVERED", Decimal, true}, {"AMOUNT_UNCOVERED", Decimal, true}, {"REVENUE", Decimal, true},
+			{"COVERED_ENCOUNTERS", Integer, true}, {"UNCOVERED_ENCOUNTERS", Integer, true},
+			{"COVERED_MEDICATIONS", Integer, true}, {"UNCOVERED_MEDICATIONS", Integer, true},
+			{"COVERED_PROCEDURES", Integer, true}, {"UNCOVERED_PROCEDURES", Integer, true},
+			{"COVERED_IMMUNIZATIONS", Integer, true}, {"UNCOVERED_IMMUNIZATIONS", Integer, true},
+			{"UNIQUE_CUSTOMERS", Integer, true}, {"QOLS_AVG", Decimal, true}, {"MEMBER_MONTHS", Integer, true},
+		},
+		"procedures.csv": {
+			{"DATE", DateTime, true}, {"PATIENT", UUID, true}, {"ENCOUNTER", UUID, true},
+			{"CODE", String, true}, {"DESCRIPTION", String, true}, {"BASE_COST", Decimal, true},
+			{"REASONCODE", String, false}, {"REASONDESCRIPTION", String, false},
+		},
+		"providers.csv": {
+			{"Id", UUID, true}, {"ORGANIZATION", UUID, true}, {"NAME", String, true}, {"GENDER", String, true},
+			{"SPECIALITY", String, true}, {"ADDRESS", String, true}, {"CITY", String, true}, {"STATE", String, true},
+			{"ZIP", String, false}, {"LAT", Decimal, true}, {"LON", Decimal, true}, {"UTILIZATION", Integer, true},
+		},
+		"supplies.csv": {
+			{"DATE", Date, true}, {"PATIENT", UUID, true}, {"ENCOUNTER", UUID, true},
+			{"CODE", String, true}, {"DESCRIPTION", String, true}, {"QUANTITY", Integer, true},
+		},
+	},
+}
+
+// Schemas returns the CSV schemas of a known Synthea version, keyed by file name
+func Schemas(version string) (map[string]Schema, error) {
+	schemas, ok := Versions[version]
+	if !ok {
+		var known []string
+		for v := range Versions {
+			known = append(known, v)
+		}
+		sort.Strings(known)
+		return nil, fmt.Errorf("unknown Synthea version %s -- known versions: %v", version, known)
+	}
+	return schemas, nil
+}
+
+// Index returns the position of the named column, or -1 if it is not part of the schema
+func (s Schema) Index(name string) int {
+	for i, column := range s {
+		if column.Name == name {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,222 @@
+package csv
+
+import (
+	stdcsv "encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Subdir is the directory of the Synthea output holding the CSV files
+const Subdir = "csv"
+
+// Layouts of Date and DateTime values
+const (
+	DateLayout     = "2006-01-02"
+	DateTimeLayout = time.RFC3339
+)
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// Issue is a difference between a CSV file and the schema of its Synthea version
+type Issue struct {
+	File    string
+	Line    int    // line of the first occurrence -- 0 for issues with the file or its header
+	Column  string // empty for issues with the file
+	Message string
+	Count   int // number of rows with the same issue in this column
+}
+
+// String formats the issue as `file:line: column: message (n rows)`
+func (i Issue) String() string {
+	s := i.File
+	if i.Line > 0 {
+		s += ":" + strconv.Itoa(i.Line)
+	}
+	s += ": "
+	if i.Column != "" {
+		s += "column " + i.Column + ": "
+	}
+	s += i.Message
+	if i.Count > 1 {
+		s += fmt.Sprintf(" (%d rows, first shown)", i.Count)
+	}
+	return s
+}
+
+// Validate checks the header and values of every CSV file in dir against the schemas of a Synthea version.
+// Reports files and columns the version does not know, missing and reordered columns, empty required values and
+// values not matching the column type. Issues with the values of a column are reported once per file, with the line
+// of the first occurrence and the number of affected rows.
+func Validate(dir string, version string) ([]Issue, error) {
+	schemas, err := Schemas(version)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var issues []Issue
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.EqualFold(filepath.Ext(entry.Name()), ".csv") {
+			continue
+		}
+		schema, ok := schemas[entry.Name()]
+		if !ok {
+			issues = append(issues, Issue{File: entry.Name(), Message: fmt.Sprintf("unexpected file for Synthea %s", version)})
+			continue
+		}
+		fileIssues, err := validateFile(filepath.Join(dir, entry.Name()), schema)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading %s: %s", entry.Name(), err)
+		}
+		issues = append(issues, fileIssues...)
+	}
+	return issues, nil
+}
+
+// validateFile checks a CSV file against its schema
+func validateFile(path string, schema Schema) ([]Issue, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	file := filepath.Base(path)
+	reader := stdcsv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err == io.EOF {
+		return []Issue{{File: file, Message: "missing header"}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	issues := headerIssues(file, header, schema)
+
+	// columns of the header known to the schema, by header position
+	columns := make([]*Column, len(header))
+	for i, name := range header {
+		if j := schema.Index(name); j >= 0 {
+			columns[i] = &schema[j]
+		}
+	}
+	// value issues are aggregated per column and message
+	type valueKey struct{ column, message string }
+	valueIssues := map[valueKey]*Issue{}
+	var order []valueKey
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) != len(header) {
+			key := valueKey{message: "wrong number of fields"}
+			if issue, ok := valueIssues[key]; ok {
+				issue.Count++
+			} else {
+				valueIssues[key] = &Issue{File: file, Line: line, Message: fmt.Sprintf("expected %d fields, got %d", len(header), len(record)), Count: 1}
+				order = append(order, key)
+			}
+			continue
+		}
+		for i, value := range record {
+			if columns[i] == nil {
+				continue
+			}
+			message := checkValue(*columns[i], value)
+			if message == "" {
+				continue
+			}
+			key := valueKey{columns[i].Name, message}
+			if issue, ok := valueIssues[key]; ok {
+				issue.Count++
+				continue
+			}
+			if value != "" {
+				message = fmt.Sprintf("%s: %q", message, value)
+			}
+			valueIssues[key] = &Issue{File: file, Line: line, Column: columns[i].Name, Message: message, Count: 1}
+			order = append(order, key)
+		}
+	}
+	for _, key := range order {
+		issues = append(issues, *valueIssues[key])
+	}
+	return issues, nil
+}
+
+// headerIssues reports columns missing from, unexpected in, or out of order in a header
+func headerIssues(file string, header []string, schema Schema) []Issue {
+	var issues []Issue
+	seen := map[string]bool{}
+	var known []string // known columns of the header, in header order
+	for _, name := range header {
+		if seen[name] {
+			issues = append(issues, Issue{File: file, Column: name, Message: "duplicate column"})
+			continue
+		}
+		seen[name] = true
+		if schema.Index(name) < 0 {
+			issues = append(issues, Issue{File: file, Column: name, Message: "unexpected column"})
+			continue
+		}
+		known = append(known, name)
+	}
+	var expected []string // schema columns present in the header, in schema order
+	for _, column := range schema {
+		if !seen[column.Name] {
+			issues = append(issues, Issue{File: file, Column: column.Name, Message: fmt.Sprintf("missing %s column", column.Type)})
+			continue
+		}
+		expected = append(expected, column.Name)
+	}
+	for i := range known {
+		if known[i] != expected[i] {
+			issues = append(issues, Issue{File: file, Message: fmt.Sprintf("columns out of order: expected %s, got %s",
+				strings.Join(expected, ","), strings.Join(known, ","))})
+			break
+		}
+	}
+	return issues
+}
+
+// checkValue returns why value does not match the column, or "" if it does
+func checkValue(column Column, value string) string {
+	if value == "" {
+		if column.Required {
+			return "empty required value"
+		}
+		return ""
+	}
+	var err error
+	switch column.Type {
+	case UUID:
+		if !uuidPattern.MatchString(value) {
+			err = fmt.Errorf("not a UUID")
+		}
+	case Date:
+		_, err = time.Parse(DateLayout, value)
+	case DateTime:
+		_, err = time.Parse(DateTimeLayout, value)
+	case Integer:
+		_, err = strconv.ParseInt(value, 10, 64)
+	case Decimal:
+		_, err = strconv.ParseFloat(value, 64)
+	}
+	if err != nil {
+		return "expected " + column.Type.String()
+	}
+	return ""
+}
@@ -15,7 +15,11 @@ import (
 // if nil, it has not been cloned or set by user
 var InstallPath *string
 
-// Clone the Synthea repository locally to a temporary directory
+// Ref is the Synthea release Clone checks out. Its CSV layout is the one validated by default (see
+// csv.DefaultVersion), and it runs on the Java versions listed in the README.
+const Ref = "v2.6.1"
+
+// Clone the Synthea repository at Ref locally to a temporary directory
 func Clone() (err error) {
 	// Clone Synthea into a temp dir
 	tempDir, err := ioutil.TempDir("", "synthea")
@@ -25,11 +29,12 @@ func Clone() (err error) {
 	InstallPath = &tempDir
 
 	// Execute cloning
-	logger.Info(fmt.Sprintf("Cloning Synthea repository to %s", tempDir))
+	logger.Info(fmt.Sprintf("Cloning Synthea %s repository to %s", Ref, tempDir))
 	return git.Clone(git.CloneOptions{
-		Repo:  "https://github.com/synthetichealth/synthea",
-		Dir:   tempDir,
-		Depth: 1, // shallow clone -- repository is large
+		Repo:   "https://github.com/synthetichealth/synthea",
+		Dir:    tempDir,
+		Depth:  1, // shallow clone -- repository is large
+		Branch: Ref,
 	})
 }
 
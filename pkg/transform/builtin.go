@@ -0,0 +1,183 @@
+package transform
+
+import (
+	"errors"
+	"microsoft.com/divoc/pkg/dateshift"
+	"microsoft.com/divoc/pkg/fhir"
+	"microsoft.com/divoc/pkg/uuid"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	Register("meta-tag", newMetaTag)
+	Register("meta-source", newMetaSource)
+	Register("strip-narrative", newStripNarrative)
+	Register("strip-extensions", newStripExtensions)
+	Register("date-shift", newDateShift)
+	Register("namespace-ids", newNamespaceIDs)
+}
+
+// newMetaTag adds a meta.tag to every resource. arg is `system|code[|display]`.
+func newMetaTag(arg string) (Transformer, error) {
+	parts := strings.SplitN(arg, "|", 3)
+	if len(parts) < 2 || parts[1] == "" {
+		return nil, errors.New("expected `system|code[|display]`")
+	}
+	tag := map[string]interface{}{"system": parts[0], "code": parts[1]}
+	if len(parts) == 3 {
+		tag["display"] = parts[2]
+	}
+	return Func(func(r fhir.Resource) (fhir.Resource, error) {
+		meta := metaOf(r)
+		tags, _ := meta["tag"].([]interface{})
+		for _, item := range tags {
+			existing, _ := item.(map[string]interface{})
+			if existing["system"] == tag["system"] && existing["code"] == tag["code"] {
+				return r, nil
+			}
+		}
+		meta["tag"] = append(tags, copyMap(tag))
+		return r, nil
+	}), nil
+}
+
+// newMetaSource sets meta.source on every resource. arg is the source URI.
+func newMetaSource(arg string) (Transformer, error) {
+	if arg == "" {
+		return nil, errors.New("expected a source URI")
+	}
+	return Func(func(r fhir.Resource) (fhir.Resource, error) {
+		metaOf(r)["source"] = arg
+		return r, nil
+	}), nil
+}
+
+// newStripNarrative removes the narrative text of every resource, including contained resources
+func newStripNarrative(string) (Transformer, error) {
+	return Func(func(r fhir.Resource) (fhir.Resource, error) {
+		delete(r, "text")
+		contained, _ := r["contained"].([]interface{})
+		for _, item := range contained {
+			if c, ok := item.(map[string]interface{}); ok {
+				delete(c, "text")
+			}
+		}
+		return r, nil
+	}), nil
+}
+
+// newStripExtensions removes extensions at any depth. arg is an optional comma separated list of extension URLs to
+// remove; if empty, every extension is removed. modifierExtensions change the meaning of their element and are kept.
+func newStripExtensions(arg string) (Transformer, error) {
+	urls := map[string]bool{}
+	for _, url := range strings.Split(arg, ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			urls[url] = true
+		}
+	}
+	return Func(func(r fhir.Resource) (fhir.Resource, error) {
+		stripExtensions(map[string]interface{}(r), urls)
+		return r, nil
+	}), nil
+}
+
+// newDateShift moves every date, dateTime, instant and Period by a number of days. arg is the signed number of days.
+func newDateShift(arg string) (Transformer, error) {
+	days, err := strconv.Atoi(strings.TrimPrefix(arg, "+"))
+	if err != nil {
+		return nil, errors.New("expected a signed number of days")
+	}
+	return Func(func(r fhir.Resource) (fhir.Resource, error) {
+		dateshift.Resource(r, days)
+		return r, nil
+	}), nil
+}
+
+// newNamespaceIDs rewrites the id of every resource, and every reference to it, to the UUIDv5 of the original id
+// within a dataset namespace. arg is the namespace: a UUID, or any name which is hashed into a UUID.
+// Conditional (identifier) and contained references are left as-is.
+func newNamespaceIDs(arg string) (Transformer, error) {
+	if arg == "" {
+		return nil, errors.New("expected a namespace UUID or name")
+	}
+	namespace, err := uuid.Parse(arg)
+	if err != nil {
+		namespace = uuid.NewV5(uuid.NamespaceURL, arg)
+	}
+	newID := func(id string) string {
+		return uuid.NewV5(namespace, id).String()
+	}
+	return Func(func(r fhir.Resource) (fhir.Resource, error) {
+		if id := r.ID(); id != "" {
+			r.SetID(newID(id))
+		}
+		fhir.VisitReferences(r, func(s string) string {
+			ref, ok := fhir.ParseReference(s)
+			if !ok || ref.ID == "" {
+				return s
+			}
+			if strings.HasPrefix(s, "urn:uuid:") {
+				return "urn:uuid:" + newID(ref.ID)
+			}
+			// keep any base URL and _history suffix of the reference
+			old := ref.ID
+			if ref.Type != "" {
+				old = ref.Type + "/" + ref.ID
+			}
+			i := strings.LastIndex(s, old)
+			if i < 0 {
+				return s
+			}
+			return s[:i] + strings.TrimSuffix(old, ref.ID) + newID(ref.ID) + s[i+len(old):]
+		})
+		return r, nil
+	}), nil
+}
+
+// stripExtensions removes the extensions with the provided urls -- or all extensions if urls is empty -- from v
+func stripExtensions(v interface{}, urls map[string]bool) {
+	switch node := v.(type) {
+	case map[string]interface{}:
+		if extensions, ok := node["extension"].([]interface{}); ok {
+			var kept []interface{}
+			for _, item := range extensions {
+				ext, _ := item.(map[string]interface{})
+				if url, _ := ext["url"].(string); len(urls) > 0 && !urls[url] {
+					kept = append(kept, item)
+				}
+			}
+			if len(kept) == 0 {
+				delete(node, "extension")
+			} else {
+				node["extension"] = kept
+			}
+		}
+		for _, child := range node {
+			stripExtensions(child, urls)
+		}
+	case []interface{}:
+		for _, child := range node {
+			stripExtensions(child, urls)
+		}
+	}
+}
+
+// metaOf returns the meta element of r, creating it if needed
+func metaOf(r fhir.Resource) map[string]interface{} {
+	meta, ok := r["meta"].(map[string]interface{})
+	if !ok {
+		meta = map[string]interface{}{}
+		r["meta"] = meta
+	}
+	return meta
+}
+
+// copyMap returns a shallow copy of m so resources never share mutable elements
+func copyMap(m map[string]interface{}) map[string]interface{} {
+	c := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
@@ -0,0 +1,143 @@
+package transform
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"microsoft.com/divoc/pkg/fhir"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Transformer modifies a single FHIR resource on its way from Synthea to the upload.
+// Transform may modify r in place; returning a nil Resource drops it from the dataset.
+type Transformer interface {
+	Transform(r fhir.Resource) (fhir.Resource, error)
+}
+
+// Func adapts an ordinary function to a Transformer
+type Func func(r fhir.Resource) (fhir.Resource, error)
+
+// Transform calls f(r)
+func (f Func) Transform(r fhir.Resource) (fhir.Resource, error) {
+	return f(r)
+}
+
+// Factory creates a configured Transformer from its argument -- the text after '=' in `name=arg`.
+// arg is empty if none was provided.
+type Factory func(arg string) (Transformer, error)
+
+// registry of named Transformer factories available to the cli and config files
+var registry = map[string]Factory{}
+var registryLock = sync.RWMutex{}
+
+// Register makes a Transformer available by name to Spec.Build, the `-transform` flag and config files.
+// Registering an existing name replaces it.
+func Register(name string, factory Factory) {
+	registryLock.Lock()
+	registry[name] = factory
+	registryLock.Unlock()
+}
+
+// Names returns the sorted names of every registered Transformer
+func Names() (names []string) {
+	registryLock.RLock()
+	for name := range registry {
+		names = append(names, name)
+	}
+	registryLock.RUnlock()
+	sort.Strings(names)
+	return names
+}
+
+// Spec configures a registered Transformer
+type Spec struct {
+	Name string `json:"name"`
+	Arg  string `json:"arg,omitempty"`
+}
+
+// ParseSpec parses a Spec from its cli form `name[=arg]`
+func ParseSpec(s string) Spec {
+	if i := strings.Index(s, "="); i >= 0 {
+		return Spec{Name: s[:i], Arg: s[i+1:]}
+	}
+	return Spec{Name: s}
+}
+
+// Build creates the Transformer described by the spec
+func (spec Spec) Build() (Transformer, error) {
+	registryLock.RLock()
+	factory, ok := registry[spec.Name]
+	registryLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown transformer %q -- available transformers: %s", spec.Name, strings.Join(Names(), ", "))
+	}
+	t, err := factory(spec.Arg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration for transformer %s: %s", spec.Name, err)
+	}
+	return t, nil
+}
+
+// Config is the format of a transformer config file:
+//
+//	{"transformers": [{"name": "meta-tag", "arg": "https://example.com/tags|demo"}, {"name": "strip-narrative"}]}
+type Config struct {
+	Transformers []Spec `json:"transformers"`
+}
+
+// LoadConfig reads the Specs listed in a JSON config file
+func LoadConfig(path string) ([]Spec, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed parsing transformer config %s: %s", path, err)
+	}
+	return config.Transformers, nil
+}
+
+// Pipeline is a Transformer which applies each of its Transformers in order
+type Pipeline []Transformer
+
+// NewPipeline builds a Pipeline from the specs
+func NewPipeline(specs []Spec) (Pipeline, error) {
+	var p Pipeline
+	for _, spec := range specs {
+		t, err := spec.Build()
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, t)
+	}
+	return p, nil
+}
+
+// Transform passes r through every Transformer in order, stopping if any drops it
+func (p Pipeline) Transform(r fhir.Resource) (fhir.Resource, error) {
+	for _, t := range p {
+		var err error
+		if r, err = t.Transform(r); err != nil || r == nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Apply streams every resource of every FHIR file in dir through t, rewriting the files in place.
+// Returns the number of files rewritten.
+func Apply(dir string, t Transformer) (int, error) {
+	files, err := fhir.ListFiles(dir)
+	if err != nil {
+		return 0, err
+	}
+	for _, file := range files {
+		if err := fhir.RewriteFile(file, t.Transform); err != nil {
+			return 0, fmt.Errorf("failed transforming %s: %s", file.Path, err)
+		}
+	}
+	return len(files), nil
+}
@@ -0,0 +1,54 @@
+package uuid
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// UUID is an RFC 4122 UUID
+type UUID [16]byte
+
+// NamespaceURL is the RFC 4122 name space for URLs
+var NamespaceURL = MustParse("6ba7b811-9dad-11d1-80b4-00c04fd430c8")
+
+// Parse a UUID in its canonical hyphenated form; eg: 6ba7b811-9dad-11d1-80b4-00c04fd430c8
+func Parse(s string) (UUID, error) {
+	var u UUID
+	if len(s) != 36 || s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+		return u, fmt.Errorf("invalid UUID %q", s)
+	}
+	if _, err := hex.Decode(u[:], []byte(strings.Replace(s, "-", "", -1))); err != nil {
+		return u, fmt.Errorf("invalid UUID %q", s)
+	}
+	return u, nil
+}
+
+// MustParse is like Parse but panics if s is not a UUID
+func MustParse(s string) UUID {
+	u, err := Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return u
+}
+
+// NewV5 returns the name based (SHA-1) UUID of name within namespace.
+// The same namespace and name always produce the same UUID.
+func NewV5(namespace UUID, name string) UUID {
+	h := sha1.New()
+	h.Write(namespace[:])
+	h.Write([]byte(name))
+	var u UUID
+	copy(u[:], h.Sum(nil))
+	u[6] = (u[6] & 0x0f) | 0x50 // version 5
+	u[8] = (u[8] & 0x3f) | 0x80 // RFC 4122 variant
+	return u
+}
+
+// String returns the canonical hyphenated form of the UUID
+func (u UUID) String() string {
+	s := hex.EncodeToString(u[:])
+	return s[:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:]
+}